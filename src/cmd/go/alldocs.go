@@ -1013,37 +1013,850 @@
 //
 // Usage:
 //
-// 	go mod download [-x] [-json] [modules]
+// 	go mod download [-x] [-json] [download flags] [modules]
 //
 // Download downloads the named modules, which can be module patterns selecting
 // dependencies of the main module or module queries of the form path@version.
 // With no arguments, download applies to all dependencies of the main module.
+// Each module version is downloaded and reported once, in the position of
+// the first argument that resolves to it, even if several arguments do.
+// Different versions of one module, such as rsc.io/quote@v1.5.1 and
+// rsc.io/quote@v1.5.2, are downloaded concurrently and reported separately,
+// each with its own files, whether or not the main module requires one of them.
+// In a main module, the checksums of the downloaded modules are added to go.sum,
+// which is written once all the modules have been downloaded.
 //
 // The go command will automatically download modules as needed during ordinary
 // execution. The "go mod download" command is useful mainly for pre-filling
 // the local cache or to compute the answers for a Go module proxy.
 //
 // By default, download writes nothing to standard output. It may print progress
-// messages and errors to standard error.
+// messages and errors to standard error. If standard error is a terminal,
+// download displays the progress of the module zip files being downloaded,
+// unless the -x flag is set.
 //
 // The -json flag causes download to print a sequence of JSON objects
 // to standard output, describing each downloaded module (or failure),
 // corresponding to this Go struct:
 //
 //     type Module struct {
-//         Path     string // module path
-//         Version  string // module version
-//         Error    string // error loading module
-//         Info     string // absolute path to cached .info file
-//         GoMod    string // absolute path to cached .mod file
-//         Zip      string // absolute path to cached .zip file
-//         Dir      string // absolute path to cached source root directory
-//         Sum      string // checksum for path, version (as in go.sum)
-//         GoModSum string // checksum for go.mod (as in go.sum)
+//         Path              string     // module path
+//         Version           string     // module version
+//         Time              *time.Time // time version was created
+//         Error             string     // error loading module
+//         ErrorKind         string     // classification of Error (see below)
+//         Info              string     // absolute path to cached .info file
+//         GoMod             string     // absolute path to cached .mod file
+//         Zip               string     // absolute path to cached .zip file
+//         Dir               string     // absolute path to cached source root directory
+//         UncompressedSize  int64      // total uncompressed size of the files in Dir
+//         Sum               string     // checksum for path, version (as in go.sum)
+//         GoModSum          string     // checksum for go.mod (as in go.sum)
+//         GoModContent      string     // contents of go.mod (with -include-gomod)
+//         GoVersion         string     // go version declared in the module's go.mod file
+//         ToolchainRequired string     // newer Go release required by GoVersion, if any
+//         Retries           int        // number of times the download was retried
+//         Verified          bool       // cached module was verified (with -verify)
+//         VerifiedAfter     bool       // module was verified after downloading (with -verify-after)
+//         Cached            bool       // zip file was already in the module cache
+//         WouldDownload     bool       // module would be downloaded (with -n)
+//         Origin            *Origin    // where the module was fetched from
+//         VCS               string     // version control system, if fetched directly from one
+//         Revision          string     // commit fetched, if fetched directly from a VCS
+//         Insecure          bool       // module was fetched without TLS
+//         Indirect          bool       // module is only indirectly required by the main module
+//         Mismatch          *Mismatch  // checksum mismatch (see -report-only below)
+//         Auth              *Auth      // credentials refused by a module proxy
+//         SumDB             *SumDB     // checksum database lookup cached (with -sumdb)
+//         LicenseFiles      []string   // license files in Dir, relative to Dir (with -license)
+//         Headers           Headers    // proxy response headers (with -trace-headers)
+//         SchemaVersion     int        // version of this struct's set of fields (see below)
 //     }
 //
+//     type Auth struct {
+//         Host      string // host name of the module proxy
+//         Mechanism string // credentials sent: "netrc", "url", or "none"
+//     }
+//
+//     type Origin struct {
+//         Proxy    string // GOPROXY entry that served the module, or "direct"
+//         VCS      string // version control system, for modules fetched directly
+//         URL      string // repository URL, for modules fetched directly
+//         Revision string // commit fetched, for modules fetched directly
+//     }
+//
+// The Origin field is set only for modules whose go.mod or zip file was
+// fetched by this command, and describes where the zip file came from if it
+// was fetched. It is useful for finding out which entry of a comma-separated
+// GOPROXY list served a module. The Proxy field holds that entry, with any
+// password removed, or "direct" if the module was fetched from its
+// origin; in that case, the VCS and URL fields identify the repository,
+// and the Revision field holds the full identifier (such as a Git commit
+// hash) of the revision fetched from it. For modules served by a proxy
+// found through a go-get=1 meta tag, VCS is "mod".
+//
+// The Indirect field is set for modules in the build list of the main module
+// (or, with -vendor, in its vendor/modules.txt file) that its go.mod file does
+// not require, or requires only with an "// indirect" comment, so that tools
+// can tell the main module's direct dependencies from the rest. It is never
+// set for modules named explicitly by path@version that are not in the build
+// list at that version, or when there is no main module.
+//
+// The top-level VCS and Revision fields repeat those of the Origin for
+// modules fetched from a version control repository ("git", "hg", "svn",
+// "bzr", or "fossil"), for auditing where a module's code came from.
+// They are empty for modules served by a proxy.
+//
+// The Insecure field reports that the go.mod or zip file of the module was
+// fetched over a connection not protected by TLS or SSH: from an http://
+// GOPROXY entry or, for a module matching GOINSECURE, from an http:// or
+// git:// repository. For each such module, download also prints a warning
+// to standard error naming the proxy or repository.
+//
+// The -license flag, which requires -json or -f, causes download to set the
+// LicenseFiles field to the names of the files at the top level of each
+// module's extracted directory named LICENSE, COPYING, or NOTICE, in any case,
+// optionally followed by a suffix beginning with punctuation, such as
+// LICENSE.txt or COPYING-MIT, in sorted order. Files in subdirectories are
+// not listed. The field is not set with -n, -mod-only, -no-extract,
+// or -sumonly, which do not extract modules.
+//
+// The -include-gomod flag, which requires -json or -f, causes download to set the
+// GoModContent field to the text of each module's go.mod file, so that the
+// module graph can be analyzed from the -json output alone. With -n, download
+// fetches the go.mod files of modules missing from the module cache without
+// adding them to the cache.
+//
+// If the Error field is set, the ErrorKind field classifies the error, when
+// possible, as one of "not_found" (the module or version does not exist),
+// "pseudo_version" (the version is a pseudo-version rejected by -no-pseudo),
+// "checksum_mismatch" (the module does not match its recorded checksum),
+// "network" (a network or server error), "permission" (a file system
+// permission error), "invalid_version" (the version is invalid for the
+// module), "signature" (the module failed signature verification with
+// -sig), "case_collision" (the module path differs only in case from that
+// of another module being downloaded), or "auth" (a module proxy refused a
+// request with HTTP status 401 or 403), or "size_budget" (the module was
+// skipped by -max-total-size). New kinds may be added in the future;
+// consumers should treat an unknown or empty ErrorKind as an unclassified error.
+//
+// For an "auth" error, the Auth field names the host of the module proxy and
+// the source of the credentials sent with the refused request: "netrc" for
+// the user's .netrc file (or the file named by $NETRC), "url" for a username
+// and password in the GOPROXY entry, or "none" if no credentials were found.
+// The Error field describes the same, so that a misconfigured .netrc file is
+// easy to tell from a missing one. The .netrc file is consulted only for
+// https:// GOPROXY entries.
+//
+// If two of the modules to be downloaded have paths that differ only in case,
+// such as github.com/User/x and github.com/user/x, download reports an error
+// for each of them instead of downloading either. The module cache escapes
+// upper-case letters in module paths, so the two would not share files there,
+// but they cannot both be unpacked on a case-insensitive file system, such as
+// those usually used on macOS and Windows, by 'go mod vendor' for example,
+// and one of the paths is most likely a misspelling of the other.
+//
+// If a module's go.mod file declares a go version newer than that of the
+// running go command, download prints a warning to standard error, and the
+// ToolchainRequired field names the Go release the module requires, such as
+// "go1.15". Download still downloads the module as usual; it is a build using
+// the module that may fail.
+//
+// Every Module object has a SchemaVersion field, so that tools can tell which
+// fields to expect from the go command that printed it. The current version
+// is 1. It is incremented each time a field is added to Module or the meaning
+// of an existing field changes; fields are not removed or renamed. An object
+// with a given SchemaVersion may have any of the fields of that version, and
+// consumers should ignore fields they do not recognize. Objects without the
+// field were printed by an older go command and have version 0.
+//
+// The objects printed by -json, also spelled -json=stream, follow one another
+// with no separator, as a stream of JSON values. The modules are sorted by
+// path and then by version, independent of the order in which they were
+// listed and of the order in which their downloads finish, so that the output
+// of two runs can be compared. Each object is printed as soon as download has
+// finished with its module and with every module before it. The -json=array
+// flag instead prints a single JSON array holding all of them, in the same
+// order, once every module has been processed, for consumers that expect one
+// JSON document. With -json=array, the -stats summary is the last element
+// of the array, following the modules.
+//
+// The -topo flag causes download to report the modules in dependency order
+// instead: each module comes after the modules its go.mod file requires, so
+// that a consumer building modules as they are printed finds a module's
+// dependencies already built. Modules the requirements do not order, and the
+// modules of a requirement cycle, keep their order by path and version.
+// Download still fetches the modules concurrently, in no particular order;
+// a module is printed once it and every module before it are done. Ordering
+// the modules reads the go.mod file of each of them first, so -topo cannot
+// be combined with -n.
+//
+// The -compact flag, which requires -json or -usage, causes download to print
+// each JSON value on a single line, without indentation. With -json, that
+// is one line per module, which is cheaper to parse and to log in bulk.
+//
+// The -f flag specifies an alternate format for the output, using the
+// syntax of package template, as in 'go list -f'. The template is executed
+// for each module downloaded without error, with the Module struct above as
+// its argument, and a newline is added after its output if needed. As with
+// -json, the modules are printed in order, each as soon as download has
+// finished with it and with every module before it.
+// Errors are printed to standard error, as without -json, as is the -stats
+// summary. The template function "join" calls strings.Join. The -f flag
+// cannot be combined with -json. For example:
+//
+//     go mod download -f '{{.Path}}@{{.Version}} {{.Zip}}' all
+//
 // The -x flag causes download to print the commands download executes.
 //
+// Setting GODEBUG=modfetchtrace=1 causes download (and any other go command
+// that fetches modules) to print to standard error how long each phase of
+// fetching a module took, to help tell a slow network from a slow machine.
+// Each entry is a line starting with "# modfetchtrace " followed by a JSON
+// object: a "get" entry for each HTTP request, giving the request's total
+// Duration and, separately, the time spent in its DNS, Connect, TLS, TTFB
+// (time to first byte) and Body phases, and a "verify" and an "extract" entry
+// for checking and unpacking each module zip file. A request's phases need
+// not add up to its Duration, which also covers writing the request and
+// following redirects. Durations are in seconds; phases that took no time,
+// such as connecting on a reused connection, are omitted. Combined with -x, the entries follow the
+// "# get" line of each request.
+//
+// The -usage flag causes download to report, instead of downloading anything,
+// the disk space that the named modules already use in the module cache, to
+// help decide what to remove from it. The report is a single JSON object
+// printed to standard output, corresponding to these Go structs:
+//
+//     type Usage struct {
+//         Modules     []ModuleUsage // sorted by Path, then Version
+//         Total       int64         // total size of Modules
+//         Unused      []ModuleUsage // with -unused
+//         UnusedTotal int64         // total size of Unused
+//     }
+//
+//     type ModuleUsage struct {
+//         Path     string
+//         Version  string
+//         Download int64 // size of the .info, .mod, .zip, and other cached files
+//         Dir      int64 // size of the extracted module directory
+//         Total    int64 // Download + Dir
+//     }
+//
+// Modules that are not in the module cache are omitted. Resolving the named
+// modules may still fetch .info and .mod files (but not zip files), as for
+// 'go list -m'. The -unused flag, used with -usage, also reports the modules
+// in the module cache that are not among the named modules: with no
+// arguments, the cached modules that the main module does not depend on.
+// The -usage flag cannot be combined with -json, -f, -n, or -purge.
+//
+// The -healthcheck flag causes download to check, instead of downloading
+// anything, that the module proxies listed in GOPROXY can serve the named
+// modules, for monitoring a module mirror. For each module, download requests
+// only the .info file, from each proxy in turn as a download would, bypassing
+// the module cache so that every module is requested anew, and records how the
+// last proxy asked answered and how long it took. The "direct" and "noproxy"
+// entries of GOPROXY are not checked. The report is a single JSON object
+// printed to standard output, corresponding to these Go structs:
+//
+//     type HealthCheck struct {
+//         Modules []ModuleHealth // sorted by Path, then Version
+//         Failed  int            // number of modules with an Error
+//     }
+//
+//     type ModuleHealth struct {
+//         Path    string
+//         Version string
+//         Proxy   string  // URL of the proxy that answered
+//         Status  int     // HTTP status code of its response, or 0 if none
+//         Latency float64 // duration of the request in seconds
+//         Error   string  // error, if the module could not be fetched
+//     }
+//
+// If any module fails the check, download exits with a non-zero status after
+// printing the report. As with -usage, resolving the named modules may still
+// fetch .info and .mod files into the module cache; modules named with an
+// exact version are checked even if resolving them fails. The -healthcheck
+// flag cannot be combined with -json, -f, -n, -usage, or -purge.
+//
+// The -debug flag causes download to write a trace of the commands it
+// executes and the network requests it makes, like the one printed by -x, to
+// the named file, whether or not -x is set. The trace holds one JSON object per
+// line, corresponding to this Go struct:
+//
+//     type TraceEntry struct {
+//         Command    string  // command line, or "get URL" for a network request
+//         Dir        string  // directory in which the command ran
+//         Module     string  // path of the module fetched, if known
+//         Duration   float64 // elapsed time in seconds
+//         ExitStatus int     // exit status, or -1 if the command did not run or the request got no response
+//         Status     string  // status of the response to a network request
+//         Error      string  // error reported by the command or request
+//     }
+//
+// The Module field is set for requests to module proxies; commands run to
+// fetch modules directly from version control are attributed only by their
+// Dir, the cached repository they ran in.
+//
+// The -trace-headers flag accepts a comma-separated list of HTTP response
+// header names, such as -trace-headers=Cache-Control,Age,X-Cache, and causes
+// download to record those headers from the module proxy's responses to the
+// requests for each module's .info, .mod, and .zip files, to help diagnose how
+// the proxy caches them. Without -json, download prints the recorded headers to
+// standard error, one per line, as in 'rsc.io/quote@v1.5.2 zip: Age: 3'. With
+// -json, the Headers field holds them, in this form:
+//
+//     type Headers map[string]map[string]string // "info", "mod", or "zip" → header name → value
+//
+// Files found in the module cache or fetched directly from version control
+// have no headers. Only the named headers are recorded, since others may hold
+// credentials or other sensitive values.
+//
+// The -n flag causes download to report the modules that it would download,
+// without downloading them. Download still resolves the module list, which
+// may require fetching .info and .mod files, but it never fetches module zip
+// files. Without -json, download prints the path@version of each module that
+// is missing from the module cache to standard output, one per line. With -json,
+// the WouldDownload field reports whether each module is missing.
+//
+// The -concurrency flag sets the maximum number of modules to download
+// in parallel. The default is 10. Downloaded zip files are extracted into
+// the module cache separately, in parallel with further downloads, by up to
+// GOMAXPROCS workers. The commands printed by -x for different
+// modules may be interleaved; use -concurrency=1 to download modules one
+// at a time, so that each module's commands are printed together.
+//
+// The -max-buffer flag causes download to extract each module zip file by
+// copying its files to disk through a buffer of the given number of bytes,
+// so that the memory needed to extract a module does not grow with the size
+// of its largest file. Download then hashes the extracted files and checks
+// them against the checksum of the zip file before moving them into place in
+// the module cache. The default is -max-buffer=0, which extracts zip files as
+// other go commands do.
+//
+// The -max-total-size flag limits the total size of the zip files download
+// downloads, given in bytes or with a suffix such as 500MB or 10GB (powers of
+// 1000) or 10GiB (powers of 1024). Once the zip files downloaded so far reach
+// the limit, download skips the remaining modules whose zip files are not
+// already in the module cache and reports each of them as failed, with
+// ErrorKind "size_budget", followed by a count of the modules skipped. Modules
+// already being downloaded are finished, so the total may exceed the limit by
+// the size of a few zip files. Zip files found in the module cache do not count
+// against the limit. The -max-total-size flag cannot be combined with -n,
+// -mod-only, or -sumonly, which do not download zip files.
+//
+// The -user-agent flag sets the User-Agent header of the HTTP requests download
+// makes to module proxies and checksum databases, so that proxy operators can
+// tell cache-warming traffic from other go commands in their logs, as in
+// -user-agent='cache-warmer/1.0'. It does not affect version control tools,
+// such as git, run to fetch modules directly. By default, the header is the
+// same as for other go commands.
+//
+// The -cache-mode flag sets the permission bits, given in octal, of the files
+// download writes in the module cache, including the files extracted from
+// module zip files, regardless of the umask. Directories created in the module
+// cache get the same bits plus search permission wherever read permission is
+// granted. For example, -cache-mode=0664 lets the members of a group share a
+// module cache, provided its top directory belongs to the group and has the
+// set-group-ID bit set. The mode must grant the owner read and write
+// permission. Extracted directories are left writable rather than made
+// read-only, so -cache-mode cannot be combined with -overlay-manifest.
+// Files already in the module cache are left unchanged. By default, files
+// are created according to the umask and extracted directories are made
+// read-only.
+//
+// The -output flag causes download to also copy the .info, .mod, and .zip
+// files for each downloaded module into the named directory, using the same
+// layout as $GOPATH/pkg/mod/cache/download and updating each module's version
+// list. The resulting directory can be served directly as a module proxy
+// (see 'go help goproxy'). With -json, the Info, GoMod, and Zip fields report
+// the locations of the copies.
+//
+// The -exec flag causes download to run the given command for each module
+// whose files have been fetched (or found in the module cache) without error,
+// with the module path, version, and zip file as three extra arguments, as in
+// 'cmd rsc.io/quote v1.5.2 /path/to/v1.5.2.zip'. The command is a space-separated
+// list of words, which may be quoted, as in -exec='upload -bucket mirror'. It is
+// run before the zip file is extracted, by the worker downloading the module, so
+// up to -concurrency commands run at once. The zip file argument is the one the
+// Zip field reports, such as the copy made by -output, and is empty with
+// -mod-only or -sumonly. The output of each command, which must not read its
+// standard input, is printed to standard error once the command exits. If the
+// command fails, the failure becomes the module's error, and the module is
+// not extracted. The command is not run with -n, nor for modules taken from
+// a -reuse file.
+//
+// The -overlay-manifest flag causes download to write to the named file,
+// after all modules have been processed, a manifest of the files extracted
+// into the module cache for each module downloaded without error. The
+// extracted directories are read-only, and the manifest records the SHA-256
+// hash of every file in them, so that it can be used to construct (and check)
+// a read-only overlay of the module cache. The manifest is a single JSON
+// object corresponding to these Go structs:
+//
+//     type OverlayManifest struct {
+//         Modules []OverlayModule // sorted by Path, then Version
+//     }
+//
+//     type OverlayModule struct {
+//         Path    string        // module path
+//         Version string        // module version
+//         Dir     string        // absolute path to cached source root directory
+//         Sum     string        // checksum for path, version (as in go.sum)
+//         Files   []OverlayFile // sorted by Name
+//     }
+//
+//     type OverlayFile struct {
+//         Name   string // slash-separated path of the file, relative to Dir
+//         Size   int64  // size of the file in bytes
+//         SHA256 string // hex-encoded SHA-256 hash of the file's contents
+//     }
+//
+// The manifest reflects the module cache as it was when download finished.
+// The -overlay-manifest flag cannot be combined with -mod-only, -sumonly, or -n,
+// which do not extract modules into the module cache.
+//
+// The -digest flag causes download to write to the named file, after all
+// modules have been processed, a digest of the set of modules downloaded:
+// one "path version sum" line for each checksum download recorded, in the
+// form and order used by go.sum, followed by a final "sha256 hash" line giving
+// the hexadecimal SHA-256 hash of the preceding lines. The file depends only
+// on the modules and their checksums, so two machines that download the same
+// set of modules write identical digests. If any module fails, download
+// reports an error and does not write the digest. The -digest flag cannot be
+// combined with -n, which does not compute checksums.
+//
+// The -snapshot flag causes download to write to the named file, after all
+// modules have been processed, a JSON manifest pinning the modules downloaded:
+//
+//     type Snapshot struct {
+//         Modules []struct {
+//             Path     string // module path
+//             Version  string // module version
+//             Sum      string // checksum for path, version (as in go.sum)
+//             GoModSum string // checksum for go.mod (as in go.sum)
+//         }
+//     }
+//
+// The modules are sorted by path and then by version. As with -digest, if any
+// module fails, download reports an error and does not write the snapshot.
+// The -snapshot flag cannot be combined with -n or -mod-only, which do not
+// compute the checksums of the zip files.
+//
+// The -from-snapshot flag causes download to download exactly the module
+// versions listed in the named file, written by an earlier -snapshot, instead
+// of resolving module arguments or the main module's build list, and to check
+// each module it downloads against the checksums recorded in the snapshot.
+// A module that does not match is reported as a checksum mismatch, with the
+// Source field of Mismatch set to "snapshot". Together, -snapshot and
+// -from-snapshot let a later build fetch the same modules, with the same
+// contents, as the one that wrote the snapshot, whatever go.mod says. The
+// -from-snapshot flag cannot be combined with module arguments, -vendor,
+// -repo, -os, -arch, or -package.
+//
+// The -baseline flag causes download to check each module it downloads against
+// the checksums recorded for it in the named file, a snapshot written by an
+// earlier -snapshot, so that a proxy serving different contents for a module
+// version than it did when the snapshot was written does not go unnoticed.
+// Unlike -from-snapshot, -baseline does not change which modules are
+// downloaded, and modules the snapshot does not list are not checked. A module
+// that does not match is reported as a checksum mismatch, giving both
+// checksums, with the Source field of Mismatch set to "baseline". The -baseline
+// flag cannot be combined with -n, which does not compute checksums.
+//
+// The -sumdb-log flag causes download to write to the named file, after all
+// modules have been processed, the signed tree head of each checksum database
+// consulted during the run, so that an audit log can tie the checksums
+// download verified to a point in the database's transparency log. The file
+// holds a JSON array, sorted by Name, of objects corresponding to this Go
+// struct:
+//
+//     type SumDBTreeHead struct {
+//         Name string // database name, such as "sum.golang.org"
+//         Size int64  // number of records in the log
+//         Hash string // base64-encoded hash of the log
+//         Note string // signed note, as served by the database
+//     }
+//
+// The array is empty if no checksum database was consulted, as with
+// GOSUMDB=off.
+//
+// The -dedup flag, used with -output, causes download to save storage in the
+// output directory by hard-linking files that are identical to files it has
+// already written there, instead of copying them again. Files are compared by
+// checksum and then byte by byte. In practice this applies mainly to .mod files,
+// which are often unchanged between versions of a module; a module's .zip file
+// records its version, so it is never identical to that of another version.
+// If a hard link cannot be created, download copies the file as usual.
+//
+// The -maxrate flag limits the combined rate at which download receives module
+// zip files from module proxies, across all modules downloaded in parallel, to
+// the given number of bytes per second. Zip files make up most of the data
+// downloaded; .info and .mod files and modules fetched directly from version
+// control are not limited. The default is -maxrate=0, which does not limit
+// the rate.
+//
+// The -retry flag causes download to retry a module up to the given number
+// of times if downloading it fails with a transient error, such as a timeout,
+// a reset connection, or a server error reported by a module proxy. Download
+// waits one second before the first retry and doubles the delay before each
+// subsequent retry. Other errors, such as a missing module or a checksum
+// mismatch, are never retried. With -json, the Retries field reports the
+// number of retries made for each module. The default is -retry=0, which
+// does not retry.
+//
+// The -timeout flag limits the time spent fetching each module's files,
+// including any retries. If a module is not fetched within the given duration,
+// download abandons it and reports a "timed out" error with ErrorKind
+// "network"; requests to the module proxy are canceled, but commands run to
+// fetch modules directly from version control are allowed to finish first.
+// Extracting a downloaded zip file is not subject to the timeout. The default
+// is -timeout=0, which never times out.
+//
+// The -verify flag causes download to check that modules already present in
+// the module cache have not been modified since they were downloaded. For each
+// such module, download hashes the cached .zip file and the extracted source
+// directory and compares them against the module's recorded checksum, which
+// is itself checked against go.sum, as is the cached go.mod file. A module that
+// fails verification is reported as an error and is not downloaded again.
+// With -json, the Verified field reports whether each cached module was
+// verified successfully. Unlike 'go mod verify', download -verify applies to
+// the named modules rather than to the build list of the main module.
+//
+// The -verify-after flag causes download to download all the named modules
+// first and then, in a separate pass, hash each module's .zip file (and
+// extracted source directory, if any) in the module cache again and check it
+// against the module's recorded checksum and go.sum, so that a single run
+// both fetches the modules and independently confirms what was written to
+// disk. A module that fails this check is reported as an error. With -json,
+// the VerifiedAfter field reports whether each module passed the check, and
+// modules are printed only once the second pass is done. The -verify-after
+// flag cannot be combined with -mod-only, -sumonly, or -n.
+//
+// The -missing flag causes download to fetch only the modules missing from
+// the module cache. Modules whose .info, .mod, and .zip files and extracted
+// source directory are all present, with a checksum matching go.sum, are
+// reported from the module cache without taking its locks or using the
+// network. After downloading, download prints to standard error the number
+// of modules that were missing and the number already in the module cache.
+// The -missing flag cannot be combined with -mod-only, -sumonly, or -verify.
+//
+// The -mod-only flag causes download to fetch only the .info and .mod files
+// for each module, skipping the .zip file and the extracted source directory.
+// This is sufficient for computing module graphs or for serving the .info and
+// .mod endpoints of a module proxy. With -json, the Zip, Dir, and Sum fields
+// are omitted.
+//
+// The -no-extract flag causes download to fetch the .info, .mod, and .zip
+// files for each module without extracting the zip file into the module
+// cache, which is all that serving the module from a module proxy needs. With
+// -json, the Dir field is omitted. A later go command that needs the source code
+// of such a module, such as a build, extracts the zip file when it first uses
+// the module. The -no-extract flag cannot be combined with -mod-only, -sumonly,
+// -verify, or -overlay-manifest.
+//
+// The -sumonly flag causes download to check that go.sum lists checksums for
+// each module and for its go.mod file, without downloading the module's .zip
+// file or adding missing checksums to go.sum. A module whose checksums are
+// missing from go.sum, or recorded only in an unknown format, is reported as an
+// error, as is a module already present in the module cache whose checksum
+// does not match go.sum. The -sumonly flag can only be used in a main module.
+//
+// The -sumfile flag names a file in go.sum format to verify modules against
+// in place of the main module's go.sum file, for example a curated list of
+// checksums under review. Download checks each module it downloads against
+// that file and reports an error for any module, or go.mod file with -mod-only,
+// whose checksum the file does not list. Neither that file nor the main
+// module's go.mod and go.sum files are modified. The -sumfile flag may be used
+// outside a main module.
+//
+// The -reuse flag accepts the name of a file containing the -json output, in
+// either form, of a previous invocation of download. For each module listed in that file without
+// an error, download reports the previous result instead of downloading the
+// module again, provided that the files it names still exist and that its
+// checksums still match those recorded in the module cache and go.sum.
+// If they do not, the module is downloaded as usual.
+//
+// The -proxy flag overrides the GOPROXY setting for this invocation of
+// download only, using the same syntax (see 'go help goproxy'). It takes
+// precedence over both the GOPROXY environment variable and a value set with
+// 'go env -w'. As with any flag, a -proxy flag listed in GOFLAGS applies to
+// download unless -proxy is also given on the command line. The -proxy flag
+// does not affect GONOPROXY, GOPRIVATE, GOSUMDB, or GONOSUMDB; the checksum
+// database is reached through the overriding proxies, just as it would be
+// through those listed in GOPROXY.
+//
+// The -insecure-prefixes flag accepts a comma-separated list of glob patterns
+// of module path prefixes, in the same syntax as GONOSUMDB (see 'go help
+// module-private'), and causes download not to consult the checksum database
+// for matching modules, as though GONOSUMDB listed them. It affects only this
+// invocation, and only the checksum database: matching modules are still
+// verified against go.sum, and checksums for them are still added to go.sum.
+// Because the flag stands in for GONOSUMDB, download rejects it if GONOSUMDB
+// is already set, in the environment or with 'go env -w'. Patterns listed in
+// GOPRIVATE continue to apply.
+//
+// The -resume flag causes download to resume an interrupted download of a
+// module's .zip file instead of starting over: if an earlier run left part
+// of the file behind in the module cache, download asks the module proxy for
+// only the rest of it. If the proxy cannot serve part of a file, or if it is
+// not a proxy but a version control repository, the file is downloaded again
+// in full. A resumed .zip file is checked against go.sum and the checksum
+// database like any other; if the part left behind turns out to be corrupt,
+// download discards it and fetches the whole file again. Without -resume,
+// interrupted downloads are discarded.
+//
+// The -conditional flag causes download to revalidate, instead of fetching
+// again, the .info files that module proxies serve for revisions that are not
+// canonical versions, such as commit hashes and branch names. Unlike those
+// for canonical versions, such .info files are not kept in the module cache,
+// so every run that resolves the revision fetches its .info file again. With
+// -conditional, download saves the entity tag (ETag) that the proxy sends with
+// each such file, together with a copy of the file, in the module cache, and
+// on later runs asks the proxy for the file with an If-None-Match request.
+// If the proxy reports that the file has not changed, download uses the saved
+// copy. Proxies that send no entity tags, and modules fetched directly from
+// version control, are fetched as usual.
+//
+// The -proxy-only flag causes download to fail for any module that would be
+// fetched directly from its version control repository instead of from a
+// module proxy, reporting the repository it would have used. This applies
+// whether the module reaches "direct" in a GOPROXY list such as
+// "https://proxy.example.com,direct" or matches GONOPROXY, and also to
+// modules fetched while resolving the module graph, so it is stronger than
+// removing "direct" from GOPROXY. Modules served by a proxy declared in a
+// go-get=1 meta tag are still allowed.
+//
+// The -repo flag adds to the modules to download the module found in a Git
+// repository, given as url@rev, where url is the repository's remote URL and
+// rev is a commit hash, branch, or tag, as in
+// "-repo=https://github.com/rsc/quote@v1.5.2". Download reads the module path
+// from the go.mod file at the root of the repository at that revision,
+// converts rev to the corresponding module version, such as a pseudo-version
+// for a commit hash, and then downloads that module version as usual, through
+// GOPROXY. It is an error if the repository has no go.mod file at rev or the
+// go.mod file does not declare a valid module path. Since it contacts the
+// repository directly, -repo cannot be combined with -offline or -proxy-only.
+//
+// The -sig flag causes download to require, for each module it downloads, a
+// detached signature served by the module proxy at $GOPROXY/<module>/@v/<version>.sig
+// and signed by the given public key, in the form used by GOSUMDB
+// ("name+hash+key"; see golang.org/x/mod/sumdb/note). The signature is a
+// signed note listing the module's go.sum lines, and download checks that it
+// lists the checksums of the downloaded zip file and go.mod file. A module
+// without a valid signature, including one fetched directly from version
+// control, fails with ErrorKind "signature" and is not extracted, although
+// its zip file may remain in the download cache, where it is still checked
+// against go.sum and the checksum database before use. Without -sig,
+// signatures are neither fetched nor checked.
+//
+// The -cache-store flag names a directory, such as one on a file system shared
+// by several machines, in which download keeps a second copy of the module
+// download cache (the .info, .mod, and .zip files, but not the extracted
+// directories). Modules missing from the local module cache are copied from
+// that directory, if it holds them, instead of being fetched from GOPROXY,
+// and every module downloaded, or already present in the local module cache,
+// is copied to it. Files taken from the directory are checked against go.sum
+// and the checksum database like any others, and the Origin field is not set
+// for modules whose zip files came from it. The -cache-store flag cannot be
+// combined with -offline.
+//
+// The -offline flag causes download to report the named modules that are
+// missing from the module cache instead of fetching them. Download then makes
+// no network requests at all, not even to the checksum database, regardless
+// of the GOPROXY setting. Each missing module is reported as an error, with
+// the text "not in module cache", and download exits with a non-zero status.
+//
+// The -keep-going flag guarantees that download attempts every named module
+// even if some of them fail, including when downloading a module fails
+// unexpectedly, and causes download to report the number of modules that
+// failed after all of them have been processed. Security errors, such as a
+// checksum mismatch against go.sum, still stop download immediately,
+// unless -report-only is set.
+//
+// The -fail-on-warning flag causes download to exit with a non-zero status
+// if it printed any warning, after processing all the modules as usual, for
+// continuous integration systems that require a clean download. The warnings
+// are those for an argument skipped because it resolves to the main module,
+// a module requiring a newer go version, and a module fetched over an
+// insecure connection. Download then reports the number of warnings as an
+// error on standard error.
+//
+// The -report-only flag causes download to treat a checksum mismatch against
+// go.sum like any other error: download reports it for the module and goes on
+// to the next one, so that a single run finds every mismatch, and then exits
+// with a non-zero status. The mismatched module is not added to the module
+// cache. With -json, the Mismatch field of such a module describes the
+// mismatch, for mismatches against the checksum database as well as go.sum:
+//
+//     type Mismatch struct {
+//         File   string // "zip" or "go.mod"
+//         Got    string // checksum of the downloaded file
+//         Want   string // checksum recorded for it
+//         Source string // where Want was recorded: "go.sum", "snapshot", "baseline", or the checksum database name
+//     }
+//
+// The -sumdb flag causes download to also look up each module in the checksum
+// database named by GOSUMDB, even if go.sum already lists its checksums, so
+// that the lookup result and the tiles of the database's tree proving it are
+// stored in the module cache. A later go command, for example on a machine
+// without network access, can then check the module against the checksum
+// database with GOPROXY=off. A module for which the checksum database is not
+// in use (see 'go help module-private') fails with -sumdb. With -json, the
+// SumDB field reports the lookup:
+//
+//     type SumDB struct {
+//         Name   string // checksum database name, such as sum.golang.org
+//         Lookup string // absolute path to cached lookup result
+//     }
+//
+// The tiles are cached alongside the lookup results, in
+// $GOPATH/pkg/mod/cache/download/sumdb, and shared by all modules; the
+// latest signed tree head seen is recorded in $GOPATH/pkg/sumdb.
+//
+// If download is interrupted (by SIGINT or SIGTERM) while downloading modules,
+// it cancels the downloads in progress, finishes extracting any zip files
+// already downloaded, and then reports the modules finished so far as usual,
+// omitting the others: with -json=array, it prints them as a partial array,
+// and any files written by -output, -digest, or -overlay-manifest list them
+// alone. It then exits with status 130. A second interrupt stops download
+// immediately.
+//
+// The -stats flag causes download to print a summary to standard error after
+// all modules have been processed, reporting the number of modules downloaded,
+// the number already present in the module cache, the total size of the
+// downloaded zip files, and the elapsed time. With -json, the summary is instead
+// printed to standard output as a final JSON object (with -json=array, as the
+// last element of the array), corresponding to this Go struct:
+//
+//     type Summary struct {
+//         Downloaded int     // number of modules downloaded
+//         Cached     int     // number of modules already in the module cache
+//         Failed     int     // number of modules that could not be downloaded
+//         Bytes      int64   // total size in bytes of downloaded .zip files
+//         Elapsed    float64 // elapsed time in seconds
+//     }
+//
+// The -t flag causes download to also download the modules required, directly
+// or indirectly, by each named module, which include the modules needed to
+// build and test the packages it provides. The versions downloaded are those
+// selected by the named module's own requirements. Since the module pattern
+// "all" already includes the dependencies of every module in the build list,
+// -t has no effect when no modules are named.
+//
+// The -vendor flag causes download to download the module versions listed
+// in the main module's vendor/modules.txt file, as written by 'go mod vendor',
+// instead of those in the build list, so that the module cache can be
+// reproduced from a vendored snapshot. The build list is not loaded: each
+// module that vendor/modules.txt lists as providing packages is downloaded at
+// the version recorded there, or its replacement is if one is recorded.
+// Modules replaced by directories are skipped. The -vendor flag cannot be
+// combined with module arguments, -repo, -os, -arch, or -package, and it is
+// an error for the main module to have no vendor/modules.txt file.
+//
+// The -exclude flag causes download to skip the modules whose paths match
+// the given pattern, which may use the "..." wildcard as in 'go help packages'.
+// The flag may be repeated to exclude modules matching any of several patterns.
+// Excluded modules are not downloaded and are omitted from the -json output.
+// Patterns are matched against the paths of required modules, not against the
+// paths of their replacements.
+//
+// The -major flag causes download to skip the modules whose major version is
+// not among those given, as a comma-separated list of major version numbers,
+// each optionally followed by "+" to mean that version or later. For example,
+// -major=0,1 selects the modules at v0 and v1, and -major=2+ those at v2 and
+// above, including +incompatible versions. A module's major version is that
+// of its version or, if the version is not known, the one given by the
+// suffix of its path, such as /v2, with no suffix meaning v0 or v1. Like
+// excluded modules, skipped modules are omitted from the -json output, and
+// -major, like -exclude, applies to required modules, not their replacements.
+//
+// The -since flag causes download to skip the modules whose versions were
+// published at or before the given time, according to the Time field of
+// their .info files, which download needs in any case to resolve the module
+// list. The time is given in RFC 3339 format, as in -since=2020-01-02T15:04:05Z,
+// or as a date, as in -since=2020-01-02, meaning midnight UTC. Like excluded
+// modules, skipped modules are omitted from the -json output. A module whose
+// .info file records no time is downloaded, since it may be new. The -since
+// flag applies to the modules that the arguments resolve to, not to those
+// added by -t.
+//
+// The -no-pseudo flag causes download to report an error for each module
+// that resolves to a pseudo-version, such as v0.0.0-20191109021931-daa7c04131f5,
+// instead of downloading it, so that a policy of depending only on published
+// versions can be enforced while filling the module cache. The check applies
+// to the modules added by -t as well.
+//
+// In addition to the module queries described in 'go help modules', download
+// accepts version ranges of the form path@<version, path@<=version,
+// path@>version, and path@>=version. Where 'go get' would select only the
+// version in the range closest to the comparison, download downloads every
+// tagged version of the module in the range. It considers the same versions
+// 'go get' would: prereleases are downloaded only if no release is in the
+// range, and +incompatible versions are skipped when a compatible version in
+// the range has a go.mod file. The operators <, <=, >, and >= are the only ones
+// supported; download rejects others, such as = or ~.
+//
+// The -os and -arch flags restrict download to the modules that provide
+// packages needed to build the main module's packages, and their tests, for the
+// given operating systems and architectures, instead of downloading every
+// module in the build list. Each flag accepts a comma-separated list, such as
+// -os=linux,windows, and download takes the union of the modules needed for
+// every combination of operating system and architecture. An omitted flag
+// stands for the current GOOS or GOARCH. These flags only apply when no modules
+// are named on the command line.
+//
+// The -package flag restricts download further, to the modules that provide
+// the named packages and the packages they import, directly or indirectly,
+// instead of those needed for all of the main module's packages. It accepts
+// a comma-separated list of package patterns, such as -package=./cmd/foo,
+// interpreted as in 'go build'. The tests of the named packages and of their
+// dependencies are not considered. Like -os and -arch, with which it may be
+// combined, -package only applies when no modules are named on the command
+// line.
+//
+// The -purge flag causes download to remove module versions from the module
+// cache instead of downloading them. Each argument must be of the form
+// path@version, naming a specific version, and other versions of the same
+// module are left in the cache. Download removes the version's extracted
+// directory and its .info, .mod, .zip, and .ziphash files, holding the same
+// lock used while downloading the version. If removal fails partway, the
+// cache is left as though the version were only partly downloaded, and
+// running download -purge again completes the removal.
+//
+// The -clean flag causes download, before downloading each module version,
+// to remove the temporary files and directories that interrupted downloads of
+// that version left in the module cache: files that were never renamed into
+// place, including the partial zip files kept by -resume, and directories that
+// were never completely extracted. Complete cached files and directories, and
+// lock files, are left alone. With -x, download prints the names of the files
+// it removes. The -clean flag cannot be combined with -n.
+//
+// The -verify-zip flag causes download to check the named zip file, obtained
+// some other way, instead of downloading anything. The single argument must
+// be of the form path@version, naming the module version the zip file is
+// meant to hold. Download checks that the file is a well-formed module zip
+// file for that version and that its checksum matches the one listed in
+// go.sum or, if go.sum does not list one, the one in the checksum database.
+// The file is not added to the module cache. With -json, download prints the
+// result as a Module object whose Zip field names the file.
+//
+// The -list flag causes download to print the available versions of each
+// named module instead of downloading anything. Each argument must be a
+// module path, without a version. Download prints one line for each module,
+// giving the path followed by its tagged versions in semantic version order,
+// as 'go list -m -versions' does. Pseudo-versions are not listed, and no
+// files are added to the module cache. With -json, download prints a
+// ModuleVersions object for each module:
+//
+//     type ModuleVersions struct {
+//         Path     string   // module path
+//         Versions []string // available versions, oldest first
+//         Error    string   // error listing versions
+//     }
+//
+// An argument of the form @file names a file listing further arguments,
+// one per line. Blank lines and lines beginning with # are ignored.
+//
 // See 'go help modules' for more about module queries.
 //
 //
@@ -1620,6 +2433,14 @@
 // 		Comma-separated list of glob patterns (in the syntax of Go's path.Match)
 // 		of module path prefixes that should always be fetched in an insecure
 // 		manner. Only applies to dependencies that are being fetched directly.
+// 	GOMODCACHESHARD
+// 		Controls the layout of the module download cache. If set to "on",
+// 		files are written to 256 subdirectories of
+// 		$GOPATH/pkg/mod/cache/download/@shard, chosen by a hash of the module
+// 		path, for file systems that slow down with many entries in one
+// 		directory. Files already in the unsharded layout are still read.
+// 		All go commands sharing a module cache should use the same setting.
+// 		The sharded cache cannot be served as a GOPROXY file:// URL.
 // 	GOOS
 // 		The operating system for which to compile code.
 // 		Examples are linux, darwin, windows, netbsd.
@@ -109,3 +109,14 @@ func readNetrc() {
 
 	netrc = parseNetrc(string(data))
 }
+
+// NetrcFile returns the name of the .netrc file from which AddCredentials
+// reads credentials, and reports whether that file exists.
+func NetrcFile() (file string, exists bool) {
+	file, err := netrcPath()
+	if err != nil {
+		return "", false
+	}
+	_, err = os.Stat(file)
+	return file, err == nil
+}
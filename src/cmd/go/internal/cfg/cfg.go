@@ -53,6 +53,23 @@ var (
 	DebugActiongraph string // -debug-actiongraph flag (undocumented, unstable)
 )
 
+// A TraceEntry describes a command run, or a network request made, by the
+// go command while fetching modules, as traced by the -x flag.
+type TraceEntry struct {
+	Command    string  // command line, or "get URL" for a network request
+	Dir        string  `json:",omitempty"` // directory in which the command ran
+	Module     string  `json:",omitempty"` // path of the module fetched, if known
+	Duration   float64 // elapsed time in seconds
+	ExitStatus int     // exit status, or -1 if the command did not run or the request got no response
+	Status     string  `json:",omitempty"` // status of the response to a network request
+	Error      string  `json:",omitempty"` // error reported by the command or request
+}
+
+// Trace, if non-nil, is called with a TraceEntry for each command and network
+// request traced by -x while fetching modules, whether or not -x is set.
+// It may be called concurrently from multiple goroutines.
+var Trace func(TraceEntry)
+
 func defaultContext() build.Context {
 	ctxt := build.Default
 	ctxt.JoinPath = filepath.Join // back door to say "do not use go command"
@@ -245,12 +262,13 @@ var (
 	GOPPC64  = envOr("GOPPC64", fmt.Sprintf("%s%d", "power", objabi.GOPPC64))
 	GOWASM   = envOr("GOWASM", fmt.Sprint(objabi.GOWASM))
 
-	GOPROXY    = envOr("GOPROXY", "https://proxy.golang.org,direct")
-	GOSUMDB    = envOr("GOSUMDB", "sum.golang.org")
-	GOPRIVATE  = Getenv("GOPRIVATE")
-	GONOPROXY  = envOr("GONOPROXY", GOPRIVATE)
-	GONOSUMDB  = envOr("GONOSUMDB", GOPRIVATE)
-	GOINSECURE = Getenv("GOINSECURE")
+	GOPROXY         = envOr("GOPROXY", "https://proxy.golang.org,direct")
+	GOSUMDB         = envOr("GOSUMDB", "sum.golang.org")
+	GOPRIVATE       = Getenv("GOPRIVATE")
+	GONOPROXY       = envOr("GONOPROXY", GOPRIVATE)
+	GONOSUMDB       = envOr("GONOSUMDB", GOPRIVATE)
+	GOINSECURE      = Getenv("GOINSECURE")
+	GOMODCACHESHARD = Getenv("GOMODCACHESHARD")
 )
 
 // GetArchEnv returns the name and setting of the
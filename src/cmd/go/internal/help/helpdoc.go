@@ -510,6 +510,14 @@ General-purpose environment variables:
 		Comma-separated list of glob patterns (in the syntax of Go's path.Match)
 		of module path prefixes that should always be fetched in an insecure
 		manner. Only applies to dependencies that are being fetched directly.
+	GOMODCACHESHARD
+		Controls the layout of the module download cache. If set to "on",
+		files are written to 256 subdirectories of
+		$GOPATH/pkg/mod/cache/download/@shard, chosen by a hash of the module
+		path, for file systems that slow down with many entries in one
+		directory. Files already in the unsharded layout are still read.
+		All go commands sharing a module cache should use the same setting.
+		The sharded cache cannot be served as a GOPROXY file:// URL.
 	GOOS
 		The operating system for which to compile code.
 		Examples are linux, darwin, windows, netbsd.
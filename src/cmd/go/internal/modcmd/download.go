@@ -5,175 +5,3538 @@
 package modcmd
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
 	"cmd/go/internal/base"
 	"cmd/go/internal/cfg"
+	"cmd/go/internal/imports"
 	"cmd/go/internal/modfetch"
+	"cmd/go/internal/modinfo"
 	"cmd/go/internal/modload"
+	"cmd/go/internal/mvs"
 	"cmd/go/internal/par"
+	"cmd/go/internal/renameio"
+	"cmd/go/internal/search"
+	"cmd/go/internal/str"
+	"cmd/go/internal/web"
 	"cmd/go/internal/work"
 
+	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
 )
 
 var cmdDownload = &base.Command{
-	UsageLine: "go mod download [-x] [-json] [modules]",
+	UsageLine: "go mod download [-x] [-json] [download flags] [modules]",
 	Short:     "download modules to local cache",
 	Long: `
 Download downloads the named modules, which can be module patterns selecting
 dependencies of the main module or module queries of the form path@version.
 With no arguments, download applies to all dependencies of the main module.
+Each module version is downloaded and reported once, in the position of
+the first argument that resolves to it, even if several arguments do.
+Different versions of one module, such as rsc.io/quote@v1.5.1 and
+rsc.io/quote@v1.5.2, are downloaded concurrently and reported separately,
+each with its own files, whether or not the main module requires one of them.
+In a main module, the checksums of the downloaded modules are added to go.sum,
+which is written once all the modules have been downloaded.
 
 The go command will automatically download modules as needed during ordinary
 execution. The "go mod download" command is useful mainly for pre-filling
 the local cache or to compute the answers for a Go module proxy.
 
 By default, download writes nothing to standard output. It may print progress
-messages and errors to standard error.
+messages and errors to standard error. If standard error is a terminal,
+download displays the progress of the module zip files being downloaded,
+unless the -x flag is set.
 
 The -json flag causes download to print a sequence of JSON objects
 to standard output, describing each downloaded module (or failure),
 corresponding to this Go struct:
 
     type Module struct {
-        Path     string // module path
-        Version  string // module version
-        Error    string // error loading module
-        Info     string // absolute path to cached .info file
-        GoMod    string // absolute path to cached .mod file
-        Zip      string // absolute path to cached .zip file
-        Dir      string // absolute path to cached source root directory
-        Sum      string // checksum for path, version (as in go.sum)
-        GoModSum string // checksum for go.mod (as in go.sum)
+        Path              string     // module path
+        Version           string     // module version
+        Time              *time.Time // time version was created
+        Error             string     // error loading module
+        ErrorKind         string     // classification of Error (see below)
+        Info              string     // absolute path to cached .info file
+        GoMod             string     // absolute path to cached .mod file
+        Zip               string     // absolute path to cached .zip file
+        Dir               string     // absolute path to cached source root directory
+        UncompressedSize  int64      // total uncompressed size of the files in Dir
+        Sum               string     // checksum for path, version (as in go.sum)
+        GoModSum          string     // checksum for go.mod (as in go.sum)
+        GoModContent      string     // contents of go.mod (with -include-gomod)
+        GoVersion         string     // go version declared in the module's go.mod file
+        ToolchainRequired string     // newer Go release required by GoVersion, if any
+        Retries           int        // number of times the download was retried
+        Verified          bool       // cached module was verified (with -verify)
+        VerifiedAfter     bool       // module was verified after downloading (with -verify-after)
+        Cached            bool       // zip file was already in the module cache
+        WouldDownload     bool       // module would be downloaded (with -n)
+        Origin            *Origin    // where the module was fetched from
+        VCS               string     // version control system, if fetched directly from one
+        Revision          string     // commit fetched, if fetched directly from a VCS
+        Insecure          bool       // module was fetched without TLS
+        Indirect          bool       // module is only indirectly required by the main module
+        Mismatch          *Mismatch  // checksum mismatch (see -report-only below)
+        Auth              *Auth      // credentials refused by a module proxy
+        SumDB             *SumDB     // checksum database lookup cached (with -sumdb)
+        LicenseFiles      []string   // license files in Dir, relative to Dir (with -license)
+        Headers           Headers    // proxy response headers (with -trace-headers)
+        SchemaVersion     int        // version of this struct's set of fields (see below)
     }
 
+    type Auth struct {
+        Host      string // host name of the module proxy
+        Mechanism string // credentials sent: "netrc", "url", or "none"
+    }
+
+    type Origin struct {
+        Proxy    string // GOPROXY entry that served the module, or "direct"
+        VCS      string // version control system, for modules fetched directly
+        URL      string // repository URL, for modules fetched directly
+        Revision string // commit fetched, for modules fetched directly
+    }
+
+The Origin field is set only for modules whose go.mod or zip file was
+fetched by this command, and describes where the zip file came from if it
+was fetched. It is useful for finding out which entry of a comma-separated
+GOPROXY list served a module. The Proxy field holds that entry, with any
+password removed, or "direct" if the module was fetched from its
+origin; in that case, the VCS and URL fields identify the repository,
+and the Revision field holds the full identifier (such as a Git commit
+hash) of the revision fetched from it. For modules served by a proxy
+found through a go-get=1 meta tag, VCS is "mod".
+
+The Indirect field is set for modules in the build list of the main module
+(or, with -vendor, in its vendor/modules.txt file) that its go.mod file does
+not require, or requires only with an "// indirect" comment, so that tools
+can tell the main module's direct dependencies from the rest. It is never
+set for modules named explicitly by path@version that are not in the build
+list at that version, or when there is no main module.
+
+The top-level VCS and Revision fields repeat those of the Origin for
+modules fetched from a version control repository ("git", "hg", "svn",
+"bzr", or "fossil"), for auditing where a module's code came from.
+They are empty for modules served by a proxy.
+
+The Insecure field reports that the go.mod or zip file of the module was
+fetched over a connection not protected by TLS or SSH: from an http://
+GOPROXY entry or, for a module matching GOINSECURE, from an http:// or
+git:// repository. For each such module, download also prints a warning
+to standard error naming the proxy or repository.
+
+The -license flag, which requires -json or -f, causes download to set the
+LicenseFiles field to the names of the files at the top level of each
+module's extracted directory named LICENSE, COPYING, or NOTICE, in any case,
+optionally followed by a suffix beginning with punctuation, such as
+LICENSE.txt or COPYING-MIT, in sorted order. Files in subdirectories are
+not listed. The field is not set with -n, -mod-only, -no-extract,
+or -sumonly, which do not extract modules.
+
+The -include-gomod flag, which requires -json or -f, causes download to set the
+GoModContent field to the text of each module's go.mod file, so that the
+module graph can be analyzed from the -json output alone. With -n, download
+fetches the go.mod files of modules missing from the module cache without
+adding them to the cache.
+
+If the Error field is set, the ErrorKind field classifies the error, when
+possible, as one of "not_found" (the module or version does not exist),
+"pseudo_version" (the version is a pseudo-version rejected by -no-pseudo),
+"checksum_mismatch" (the module does not match its recorded checksum),
+"network" (a network or server error), "permission" (a file system
+permission error), "invalid_version" (the version is invalid for the
+module), "signature" (the module failed signature verification with
+-sig), "case_collision" (the module path differs only in case from that
+of another module being downloaded), or "auth" (a module proxy refused a
+request with HTTP status 401 or 403), or "size_budget" (the module was
+skipped by -max-total-size). New kinds may be added in the future;
+consumers should treat an unknown or empty ErrorKind as an unclassified error.
+
+For an "auth" error, the Auth field names the host of the module proxy and
+the source of the credentials sent with the refused request: "netrc" for
+the user's .netrc file (or the file named by $NETRC), "url" for a username
+and password in the GOPROXY entry, or "none" if no credentials were found.
+The Error field describes the same, so that a misconfigured .netrc file is
+easy to tell from a missing one. The .netrc file is consulted only for
+https:// GOPROXY entries.
+
+If two of the modules to be downloaded have paths that differ only in case,
+such as github.com/User/x and github.com/user/x, download reports an error
+for each of them instead of downloading either. The module cache escapes
+upper-case letters in module paths, so the two would not share files there,
+but they cannot both be unpacked on a case-insensitive file system, such as
+those usually used on macOS and Windows, by 'go mod vendor' for example,
+and one of the paths is most likely a misspelling of the other.
+
+If a module's go.mod file declares a go version newer than that of the
+running go command, download prints a warning to standard error, and the
+ToolchainRequired field names the Go release the module requires, such as
+"go1.15". Download still downloads the module as usual; it is a build using
+the module that may fail.
+
+Every Module object has a SchemaVersion field, so that tools can tell which
+fields to expect from the go command that printed it. The current version
+is 1. It is incremented each time a field is added to Module or the meaning
+of an existing field changes; fields are not removed or renamed. An object
+with a given SchemaVersion may have any of the fields of that version, and
+consumers should ignore fields they do not recognize. Objects without the
+field were printed by an older go command and have version 0.
+
+The objects printed by -json, also spelled -json=stream, follow one another
+with no separator, as a stream of JSON values. The modules are sorted by
+path and then by version, independent of the order in which they were
+listed and of the order in which their downloads finish, so that the output
+of two runs can be compared. Each object is printed as soon as download has
+finished with its module and with every module before it. The -json=array
+flag instead prints a single JSON array holding all of them, in the same
+order, once every module has been processed, for consumers that expect one
+JSON document. With -json=array, the -stats summary is the last element
+of the array, following the modules.
+
+The -topo flag causes download to report the modules in dependency order
+instead: each module comes after the modules its go.mod file requires, so
+that a consumer building modules as they are printed finds a module's
+dependencies already built. Modules the requirements do not order, and the
+modules of a requirement cycle, keep their order by path and version.
+Download still fetches the modules concurrently, in no particular order;
+a module is printed once it and every module before it are done. Ordering
+the modules reads the go.mod file of each of them first, so -topo cannot
+be combined with -n.
+
+The -compact flag, which requires -json or -usage, causes download to print
+each JSON value on a single line, without indentation. With -json, that
+is one line per module, which is cheaper to parse and to log in bulk.
+
+The -f flag specifies an alternate format for the output, using the
+syntax of package template, as in 'go list -f'. The template is executed
+for each module downloaded without error, with the Module struct above as
+its argument, and a newline is added after its output if needed. As with
+-json, the modules are printed in order, each as soon as download has
+finished with it and with every module before it.
+Errors are printed to standard error, as without -json, as is the -stats
+summary. The template function "join" calls strings.Join. The -f flag
+cannot be combined with -json. For example:
+
+    go mod download -f '{{.Path}}@{{.Version}} {{.Zip}}' all
+
 The -x flag causes download to print the commands download executes.
 
+Setting GODEBUG=modfetchtrace=1 causes download (and any other go command
+that fetches modules) to print to standard error how long each phase of
+fetching a module took, to help tell a slow network from a slow machine.
+Each entry is a line starting with "# modfetchtrace " followed by a JSON
+object: a "get" entry for each HTTP request, giving the request's total
+Duration and, separately, the time spent in its DNS, Connect, TLS, TTFB
+(time to first byte) and Body phases, and a "verify" and an "extract" entry
+for checking and unpacking each module zip file. A request's phases need
+not add up to its Duration, which also covers writing the request and
+following redirects. Durations are in seconds; phases that took no time,
+such as connecting on a reused connection, are omitted. Combined with -x, the entries follow the
+"# get" line of each request.
+
+The -usage flag causes download to report, instead of downloading anything,
+the disk space that the named modules already use in the module cache, to
+help decide what to remove from it. The report is a single JSON object
+printed to standard output, corresponding to these Go structs:
+
+    type Usage struct {
+        Modules     []ModuleUsage // sorted by Path, then Version
+        Total       int64         // total size of Modules
+        Unused      []ModuleUsage // with -unused
+        UnusedTotal int64         // total size of Unused
+    }
+
+    type ModuleUsage struct {
+        Path     string
+        Version  string
+        Download int64 // size of the .info, .mod, .zip, and other cached files
+        Dir      int64 // size of the extracted module directory
+        Total    int64 // Download + Dir
+    }
+
+Modules that are not in the module cache are omitted. Resolving the named
+modules may still fetch .info and .mod files (but not zip files), as for
+'go list -m'. The -unused flag, used with -usage, also reports the modules
+in the module cache that are not among the named modules: with no
+arguments, the cached modules that the main module does not depend on.
+The -usage flag cannot be combined with -json, -f, -n, or -purge.
+
+The -healthcheck flag causes download to check, instead of downloading
+anything, that the module proxies listed in GOPROXY can serve the named
+modules, for monitoring a module mirror. For each module, download requests
+only the .info file, from each proxy in turn as a download would, bypassing
+the module cache so that every module is requested anew, and records how the
+last proxy asked answered and how long it took. The "direct" and "noproxy"
+entries of GOPROXY are not checked. The report is a single JSON object
+printed to standard output, corresponding to these Go structs:
+
+    type HealthCheck struct {
+        Modules []ModuleHealth // sorted by Path, then Version
+        Failed  int            // number of modules with an Error
+    }
+
+    type ModuleHealth struct {
+        Path    string
+        Version string
+        Proxy   string  // URL of the proxy that answered
+        Status  int     // HTTP status code of its response, or 0 if none
+        Latency float64 // duration of the request in seconds
+        Error   string  // error, if the module could not be fetched
+    }
+
+If any module fails the check, download exits with a non-zero status after
+printing the report. As with -usage, resolving the named modules may still
+fetch .info and .mod files into the module cache; modules named with an
+exact version are checked even if resolving them fails. The -healthcheck
+flag cannot be combined with -json, -f, -n, -usage, or -purge.
+
+The -debug flag causes download to write a trace of the commands it
+executes and the network requests it makes, like the one printed by -x, to
+the named file, whether or not -x is set. The trace holds one JSON object per
+line, corresponding to this Go struct:
+
+    type TraceEntry struct {
+        Command    string  // command line, or "get URL" for a network request
+        Dir        string  // directory in which the command ran
+        Module     string  // path of the module fetched, if known
+        Duration   float64 // elapsed time in seconds
+        ExitStatus int     // exit status, or -1 if the command did not run or the request got no response
+        Status     string  // status of the response to a network request
+        Error      string  // error reported by the command or request
+    }
+
+The Module field is set for requests to module proxies; commands run to
+fetch modules directly from version control are attributed only by their
+Dir, the cached repository they ran in.
+
+The -trace-headers flag accepts a comma-separated list of HTTP response
+header names, such as -trace-headers=Cache-Control,Age,X-Cache, and causes
+download to record those headers from the module proxy's responses to the
+requests for each module's .info, .mod, and .zip files, to help diagnose how
+the proxy caches them. Without -json, download prints the recorded headers to
+standard error, one per line, as in 'rsc.io/quote@v1.5.2 zip: Age: 3'. With
+-json, the Headers field holds them, in this form:
+
+    type Headers map[string]map[string]string // "info", "mod", or "zip" → header name → value
+
+Files found in the module cache or fetched directly from version control
+have no headers. Only the named headers are recorded, since others may hold
+credentials or other sensitive values.
+
+The -n flag causes download to report the modules that it would download,
+without downloading them. Download still resolves the module list, which
+may require fetching .info and .mod files, but it never fetches module zip
+files. Without -json, download prints the path@version of each module that
+is missing from the module cache to standard output, one per line. With -json,
+the WouldDownload field reports whether each module is missing.
+
+The -concurrency flag sets the maximum number of modules to download
+in parallel. The default is 10. Downloaded zip files are extracted into
+the module cache separately, in parallel with further downloads, by up to
+GOMAXPROCS workers. The commands printed by -x for different
+modules may be interleaved; use -concurrency=1 to download modules one
+at a time, so that each module's commands are printed together.
+
+The -max-buffer flag causes download to extract each module zip file by
+copying its files to disk through a buffer of the given number of bytes,
+so that the memory needed to extract a module does not grow with the size
+of its largest file. Download then hashes the extracted files and checks
+them against the checksum of the zip file before moving them into place in
+the module cache. The default is -max-buffer=0, which extracts zip files as
+other go commands do.
+
+The -max-total-size flag limits the total size of the zip files download
+downloads, given in bytes or with a suffix such as 500MB or 10GB (powers of
+1000) or 10GiB (powers of 1024). Once the zip files downloaded so far reach
+the limit, download skips the remaining modules whose zip files are not
+already in the module cache and reports each of them as failed, with
+ErrorKind "size_budget", followed by a count of the modules skipped. Modules
+already being downloaded are finished, so the total may exceed the limit by
+the size of a few zip files. Zip files found in the module cache do not count
+against the limit. The -max-total-size flag cannot be combined with -n,
+-mod-only, or -sumonly, which do not download zip files.
+
+The -user-agent flag sets the User-Agent header of the HTTP requests download
+makes to module proxies and checksum databases, so that proxy operators can
+tell cache-warming traffic from other go commands in their logs, as in
+-user-agent='cache-warmer/1.0'. It does not affect version control tools,
+such as git, run to fetch modules directly. By default, the header is the
+same as for other go commands.
+
+The -cache-mode flag sets the permission bits, given in octal, of the files
+download writes in the module cache, including the files extracted from
+module zip files, regardless of the umask. Directories created in the module
+cache get the same bits plus search permission wherever read permission is
+granted. For example, -cache-mode=0664 lets the members of a group share a
+module cache, provided its top directory belongs to the group and has the
+set-group-ID bit set. The mode must grant the owner read and write
+permission. Extracted directories are left writable rather than made
+read-only, so -cache-mode cannot be combined with -overlay-manifest.
+Files already in the module cache are left unchanged. By default, files
+are created according to the umask and extracted directories are made
+read-only.
+
+The -output flag causes download to also copy the .info, .mod, and .zip
+files for each downloaded module into the named directory, using the same
+layout as $GOPATH/pkg/mod/cache/download and updating each module's version
+list. The resulting directory can be served directly as a module proxy
+(see 'go help goproxy'). With -json, the Info, GoMod, and Zip fields report
+the locations of the copies.
+
+The -exec flag causes download to run the given command for each module
+whose files have been fetched (or found in the module cache) without error,
+with the module path, version, and zip file as three extra arguments, as in
+'cmd rsc.io/quote v1.5.2 /path/to/v1.5.2.zip'. The command is a space-separated
+list of words, which may be quoted, as in -exec='upload -bucket mirror'. It is
+run before the zip file is extracted, by the worker downloading the module, so
+up to -concurrency commands run at once. The zip file argument is the one the
+Zip field reports, such as the copy made by -output, and is empty with
+-mod-only or -sumonly. The output of each command, which must not read its
+standard input, is printed to standard error once the command exits. If the
+command fails, the failure becomes the module's error, and the module is
+not extracted. The command is not run with -n, nor for modules taken from
+a -reuse file.
+
+The -overlay-manifest flag causes download to write to the named file,
+after all modules have been processed, a manifest of the files extracted
+into the module cache for each module downloaded without error. The
+extracted directories are read-only, and the manifest records the SHA-256
+hash of every file in them, so that it can be used to construct (and check)
+a read-only overlay of the module cache. The manifest is a single JSON
+object corresponding to these Go structs:
+
+    type OverlayManifest struct {
+        Modules []OverlayModule // sorted by Path, then Version
+    }
+
+    type OverlayModule struct {
+        Path    string        // module path
+        Version string        // module version
+        Dir     string        // absolute path to cached source root directory
+        Sum     string        // checksum for path, version (as in go.sum)
+        Files   []OverlayFile // sorted by Name
+    }
+
+    type OverlayFile struct {
+        Name   string // slash-separated path of the file, relative to Dir
+        Size   int64  // size of the file in bytes
+        SHA256 string // hex-encoded SHA-256 hash of the file's contents
+    }
+
+The manifest reflects the module cache as it was when download finished.
+The -overlay-manifest flag cannot be combined with -mod-only, -sumonly, or -n,
+which do not extract modules into the module cache.
+
+The -digest flag causes download to write to the named file, after all
+modules have been processed, a digest of the set of modules downloaded:
+one "path version sum" line for each checksum download recorded, in the
+form and order used by go.sum, followed by a final "sha256 hash" line giving
+the hexadecimal SHA-256 hash of the preceding lines. The file depends only
+on the modules and their checksums, so two machines that download the same
+set of modules write identical digests. If any module fails, download
+reports an error and does not write the digest. The -digest flag cannot be
+combined with -n, which does not compute checksums.
+
+The -snapshot flag causes download to write to the named file, after all
+modules have been processed, a JSON manifest pinning the modules downloaded:
+
+    type Snapshot struct {
+        Modules []struct {
+            Path     string // module path
+            Version  string // module version
+            Sum      string // checksum for path, version (as in go.sum)
+            GoModSum string // checksum for go.mod (as in go.sum)
+        }
+    }
+
+The modules are sorted by path and then by version. As with -digest, if any
+module fails, download reports an error and does not write the snapshot.
+The -snapshot flag cannot be combined with -n or -mod-only, which do not
+compute the checksums of the zip files.
+
+The -from-snapshot flag causes download to download exactly the module
+versions listed in the named file, written by an earlier -snapshot, instead
+of resolving module arguments or the main module's build list, and to check
+each module it downloads against the checksums recorded in the snapshot.
+A module that does not match is reported as a checksum mismatch, with the
+Source field of Mismatch set to "snapshot". Together, -snapshot and
+-from-snapshot let a later build fetch the same modules, with the same
+contents, as the one that wrote the snapshot, whatever go.mod says. The
+-from-snapshot flag cannot be combined with module arguments, -vendor,
+-repo, -os, -arch, or -package.
+
+The -baseline flag causes download to check each module it downloads against
+the checksums recorded for it in the named file, a snapshot written by an
+earlier -snapshot, so that a proxy serving different contents for a module
+version than it did when the snapshot was written does not go unnoticed.
+Unlike -from-snapshot, -baseline does not change which modules are
+downloaded, and modules the snapshot does not list are not checked. A module
+that does not match is reported as a checksum mismatch, giving both
+checksums, with the Source field of Mismatch set to "baseline". The -baseline
+flag cannot be combined with -n, which does not compute checksums.
+
+The -sumdb-log flag causes download to write to the named file, after all
+modules have been processed, the signed tree head of each checksum database
+consulted during the run, so that an audit log can tie the checksums
+download verified to a point in the database's transparency log. The file
+holds a JSON array, sorted by Name, of objects corresponding to this Go
+struct:
+
+    type SumDBTreeHead struct {
+        Name string // database name, such as "sum.golang.org"
+        Size int64  // number of records in the log
+        Hash string // base64-encoded hash of the log
+        Note string // signed note, as served by the database
+    }
+
+The array is empty if no checksum database was consulted, as with
+GOSUMDB=off.
+
+The -dedup flag, used with -output, causes download to save storage in the
+output directory by hard-linking files that are identical to files it has
+already written there, instead of copying them again. Files are compared by
+checksum and then byte by byte. In practice this applies mainly to .mod files,
+which are often unchanged between versions of a module; a module's .zip file
+records its version, so it is never identical to that of another version.
+If a hard link cannot be created, download copies the file as usual.
+
+The -maxrate flag limits the combined rate at which download receives module
+zip files from module proxies, across all modules downloaded in parallel, to
+the given number of bytes per second. Zip files make up most of the data
+downloaded; .info and .mod files and modules fetched directly from version
+control are not limited. The default is -maxrate=0, which does not limit
+the rate.
+
+The -retry flag causes download to retry a module up to the given number
+of times if downloading it fails with a transient error, such as a timeout,
+a reset connection, or a server error reported by a module proxy. Download
+waits one second before the first retry and doubles the delay before each
+subsequent retry. Other errors, such as a missing module or a checksum
+mismatch, are never retried. With -json, the Retries field reports the
+number of retries made for each module. The default is -retry=0, which
+does not retry.
+
+The -timeout flag limits the time spent fetching each module's files,
+including any retries. If a module is not fetched within the given duration,
+download abandons it and reports a "timed out" error with ErrorKind
+"network"; requests to the module proxy are canceled, but commands run to
+fetch modules directly from version control are allowed to finish first.
+Extracting a downloaded zip file is not subject to the timeout. The default
+is -timeout=0, which never times out.
+
+The -verify flag causes download to check that modules already present in
+the module cache have not been modified since they were downloaded. For each
+such module, download hashes the cached .zip file and the extracted source
+directory and compares them against the module's recorded checksum, which
+is itself checked against go.sum, as is the cached go.mod file. A module that
+fails verification is reported as an error and is not downloaded again.
+With -json, the Verified field reports whether each cached module was
+verified successfully. Unlike 'go mod verify', download -verify applies to
+the named modules rather than to the build list of the main module.
+
+The -verify-after flag causes download to download all the named modules
+first and then, in a separate pass, hash each module's .zip file (and
+extracted source directory, if any) in the module cache again and check it
+against the module's recorded checksum and go.sum, so that a single run
+both fetches the modules and independently confirms what was written to
+disk. A module that fails this check is reported as an error. With -json,
+the VerifiedAfter field reports whether each module passed the check, and
+modules are printed only once the second pass is done. The -verify-after
+flag cannot be combined with -mod-only, -sumonly, or -n.
+
+The -missing flag causes download to fetch only the modules missing from
+the module cache. Modules whose .info, .mod, and .zip files and extracted
+source directory are all present, with a checksum matching go.sum, are
+reported from the module cache without taking its locks or using the
+network. After downloading, download prints to standard error the number
+of modules that were missing and the number already in the module cache.
+The -missing flag cannot be combined with -mod-only, -sumonly, or -verify.
+
+The -mod-only flag causes download to fetch only the .info and .mod files
+for each module, skipping the .zip file and the extracted source directory.
+This is sufficient for computing module graphs or for serving the .info and
+.mod endpoints of a module proxy. With -json, the Zip, Dir, and Sum fields
+are omitted.
+
+The -no-extract flag causes download to fetch the .info, .mod, and .zip
+files for each module without extracting the zip file into the module
+cache, which is all that serving the module from a module proxy needs. With
+-json, the Dir field is omitted. A later go command that needs the source code
+of such a module, such as a build, extracts the zip file when it first uses
+the module. The -no-extract flag cannot be combined with -mod-only, -sumonly,
+-verify, or -overlay-manifest.
+
+The -sumonly flag causes download to check that go.sum lists checksums for
+each module and for its go.mod file, without downloading the module's .zip
+file or adding missing checksums to go.sum. A module whose checksums are
+missing from go.sum, or recorded only in an unknown format, is reported as an
+error, as is a module already present in the module cache whose checksum
+does not match go.sum. The -sumonly flag can only be used in a main module.
+
+The -sumfile flag names a file in go.sum format to verify modules against
+in place of the main module's go.sum file, for example a curated list of
+checksums under review. Download checks each module it downloads against
+that file and reports an error for any module, or go.mod file with -mod-only,
+whose checksum the file does not list. Neither that file nor the main
+module's go.mod and go.sum files are modified. The -sumfile flag may be used
+outside a main module.
+
+The -reuse flag accepts the name of a file containing the -json output, in
+either form, of a previous invocation of download. For each module listed in that file without
+an error, download reports the previous result instead of downloading the
+module again, provided that the files it names still exist and that its
+checksums still match those recorded in the module cache and go.sum.
+If they do not, the module is downloaded as usual.
+
+The -proxy flag overrides the GOPROXY setting for this invocation of
+download only, using the same syntax (see 'go help goproxy'). It takes
+precedence over both the GOPROXY environment variable and a value set with
+'go env -w'. As with any flag, a -proxy flag listed in GOFLAGS applies to
+download unless -proxy is also given on the command line. The -proxy flag
+does not affect GONOPROXY, GOPRIVATE, GOSUMDB, or GONOSUMDB; the checksum
+database is reached through the overriding proxies, just as it would be
+through those listed in GOPROXY.
+
+The -insecure-prefixes flag accepts a comma-separated list of glob patterns
+of module path prefixes, in the same syntax as GONOSUMDB (see 'go help
+module-private'), and causes download not to consult the checksum database
+for matching modules, as though GONOSUMDB listed them. It affects only this
+invocation, and only the checksum database: matching modules are still
+verified against go.sum, and checksums for them are still added to go.sum.
+Because the flag stands in for GONOSUMDB, download rejects it if GONOSUMDB
+is already set, in the environment or with 'go env -w'. Patterns listed in
+GOPRIVATE continue to apply.
+
+The -resume flag causes download to resume an interrupted download of a
+module's .zip file instead of starting over: if an earlier run left part
+of the file behind in the module cache, download asks the module proxy for
+only the rest of it. If the proxy cannot serve part of a file, or if it is
+not a proxy but a version control repository, the file is downloaded again
+in full. A resumed .zip file is checked against go.sum and the checksum
+database like any other; if the part left behind turns out to be corrupt,
+download discards it and fetches the whole file again. Without -resume,
+interrupted downloads are discarded.
+
+The -conditional flag causes download to revalidate, instead of fetching
+again, the .info files that module proxies serve for revisions that are not
+canonical versions, such as commit hashes and branch names. Unlike those
+for canonical versions, such .info files are not kept in the module cache,
+so every run that resolves the revision fetches its .info file again. With
+-conditional, download saves the entity tag (ETag) that the proxy sends with
+each such file, together with a copy of the file, in the module cache, and
+on later runs asks the proxy for the file with an If-None-Match request.
+If the proxy reports that the file has not changed, download uses the saved
+copy. Proxies that send no entity tags, and modules fetched directly from
+version control, are fetched as usual.
+
+The -proxy-only flag causes download to fail for any module that would be
+fetched directly from its version control repository instead of from a
+module proxy, reporting the repository it would have used. This applies
+whether the module reaches "direct" in a GOPROXY list such as
+"https://proxy.example.com,direct" or matches GONOPROXY, and also to
+modules fetched while resolving the module graph, so it is stronger than
+removing "direct" from GOPROXY. Modules served by a proxy declared in a
+go-get=1 meta tag are still allowed.
+
+The -repo flag adds to the modules to download the module found in a Git
+repository, given as url@rev, where url is the repository's remote URL and
+rev is a commit hash, branch, or tag, as in
+"-repo=https://github.com/rsc/quote@v1.5.2". Download reads the module path
+from the go.mod file at the root of the repository at that revision,
+converts rev to the corresponding module version, such as a pseudo-version
+for a commit hash, and then downloads that module version as usual, through
+GOPROXY. It is an error if the repository has no go.mod file at rev or the
+go.mod file does not declare a valid module path. Since it contacts the
+repository directly, -repo cannot be combined with -offline or -proxy-only.
+
+The -sig flag causes download to require, for each module it downloads, a
+detached signature served by the module proxy at $GOPROXY/<module>/@v/<version>.sig
+and signed by the given public key, in the form used by GOSUMDB
+("name+hash+key"; see golang.org/x/mod/sumdb/note). The signature is a
+signed note listing the module's go.sum lines, and download checks that it
+lists the checksums of the downloaded zip file and go.mod file. A module
+without a valid signature, including one fetched directly from version
+control, fails with ErrorKind "signature" and is not extracted, although
+its zip file may remain in the download cache, where it is still checked
+against go.sum and the checksum database before use. Without -sig,
+signatures are neither fetched nor checked.
+
+The -cache-store flag names a directory, such as one on a file system shared
+by several machines, in which download keeps a second copy of the module
+download cache (the .info, .mod, and .zip files, but not the extracted
+directories). Modules missing from the local module cache are copied from
+that directory, if it holds them, instead of being fetched from GOPROXY,
+and every module downloaded, or already present in the local module cache,
+is copied to it. Files taken from the directory are checked against go.sum
+and the checksum database like any others, and the Origin field is not set
+for modules whose zip files came from it. The -cache-store flag cannot be
+combined with -offline.
+
+The -offline flag causes download to report the named modules that are
+missing from the module cache instead of fetching them. Download then makes
+no network requests at all, not even to the checksum database, regardless
+of the GOPROXY setting. Each missing module is reported as an error, with
+the text "not in module cache", and download exits with a non-zero status.
+
+The -keep-going flag guarantees that download attempts every named module
+even if some of them fail, including when downloading a module fails
+unexpectedly, and causes download to report the number of modules that
+failed after all of them have been processed. Security errors, such as a
+checksum mismatch against go.sum, still stop download immediately,
+unless -report-only is set.
+
+The -fail-on-warning flag causes download to exit with a non-zero status
+if it printed any warning, after processing all the modules as usual, for
+continuous integration systems that require a clean download. The warnings
+are those for an argument skipped because it resolves to the main module,
+a module requiring a newer go version, and a module fetched over an
+insecure connection. Download then reports the number of warnings as an
+error on standard error.
+
+The -report-only flag causes download to treat a checksum mismatch against
+go.sum like any other error: download reports it for the module and goes on
+to the next one, so that a single run finds every mismatch, and then exits
+with a non-zero status. The mismatched module is not added to the module
+cache. With -json, the Mismatch field of such a module describes the
+mismatch, for mismatches against the checksum database as well as go.sum:
+
+    type Mismatch struct {
+        File   string // "zip" or "go.mod"
+        Got    string // checksum of the downloaded file
+        Want   string // checksum recorded for it
+        Source string // where Want was recorded: "go.sum", "snapshot", "baseline", or the checksum database name
+    }
+
+The -sumdb flag causes download to also look up each module in the checksum
+database named by GOSUMDB, even if go.sum already lists its checksums, so
+that the lookup result and the tiles of the database's tree proving it are
+stored in the module cache. A later go command, for example on a machine
+without network access, can then check the module against the checksum
+database with GOPROXY=off. A module for which the checksum database is not
+in use (see 'go help module-private') fails with -sumdb. With -json, the
+SumDB field reports the lookup:
+
+    type SumDB struct {
+        Name   string // checksum database name, such as sum.golang.org
+        Lookup string // absolute path to cached lookup result
+    }
+
+The tiles are cached alongside the lookup results, in
+$GOPATH/pkg/mod/cache/download/sumdb, and shared by all modules; the
+latest signed tree head seen is recorded in $GOPATH/pkg/sumdb.
+
+If download is interrupted (by SIGINT or SIGTERM) while downloading modules,
+it cancels the downloads in progress, finishes extracting any zip files
+already downloaded, and then reports the modules finished so far as usual,
+omitting the others: with -json=array, it prints them as a partial array,
+and any files written by -output, -digest, or -overlay-manifest list them
+alone. It then exits with status 130. A second interrupt stops download
+immediately.
+
+The -stats flag causes download to print a summary to standard error after
+all modules have been processed, reporting the number of modules downloaded,
+the number already present in the module cache, the total size of the
+downloaded zip files, and the elapsed time. With -json, the summary is instead
+printed to standard output as a final JSON object (with -json=array, as the
+last element of the array), corresponding to this Go struct:
+
+    type Summary struct {
+        Downloaded int     // number of modules downloaded
+        Cached     int     // number of modules already in the module cache
+        Failed     int     // number of modules that could not be downloaded
+        Bytes      int64   // total size in bytes of downloaded .zip files
+        Elapsed    float64 // elapsed time in seconds
+    }
+
+The -t flag causes download to also download the modules required, directly
+or indirectly, by each named module, which include the modules needed to
+build and test the packages it provides. The versions downloaded are those
+selected by the named module's own requirements. Since the module pattern
+"all" already includes the dependencies of every module in the build list,
+-t has no effect when no modules are named.
+
+The -vendor flag causes download to download the module versions listed
+in the main module's vendor/modules.txt file, as written by 'go mod vendor',
+instead of those in the build list, so that the module cache can be
+reproduced from a vendored snapshot. The build list is not loaded: each
+module that vendor/modules.txt lists as providing packages is downloaded at
+the version recorded there, or its replacement is if one is recorded.
+Modules replaced by directories are skipped. The -vendor flag cannot be
+combined with module arguments, -repo, -os, -arch, or -package, and it is
+an error for the main module to have no vendor/modules.txt file.
+
+The -exclude flag causes download to skip the modules whose paths match
+the given pattern, which may use the "..." wildcard as in 'go help packages'.
+The flag may be repeated to exclude modules matching any of several patterns.
+Excluded modules are not downloaded and are omitted from the -json output.
+Patterns are matched against the paths of required modules, not against the
+paths of their replacements.
+
+The -major flag causes download to skip the modules whose major version is
+not among those given, as a comma-separated list of major version numbers,
+each optionally followed by "+" to mean that version or later. For example,
+-major=0,1 selects the modules at v0 and v1, and -major=2+ those at v2 and
+above, including +incompatible versions. A module's major version is that
+of its version or, if the version is not known, the one given by the
+suffix of its path, such as /v2, with no suffix meaning v0 or v1. Like
+excluded modules, skipped modules are omitted from the -json output, and
+-major, like -exclude, applies to required modules, not their replacements.
+
+The -since flag causes download to skip the modules whose versions were
+published at or before the given time, according to the Time field of
+their .info files, which download needs in any case to resolve the module
+list. The time is given in RFC 3339 format, as in -since=2020-01-02T15:04:05Z,
+or as a date, as in -since=2020-01-02, meaning midnight UTC. Like excluded
+modules, skipped modules are omitted from the -json output. A module whose
+.info file records no time is downloaded, since it may be new. The -since
+flag applies to the modules that the arguments resolve to, not to those
+added by -t.
+
+The -no-pseudo flag causes download to report an error for each module
+that resolves to a pseudo-version, such as v0.0.0-20191109021931-daa7c04131f5,
+instead of downloading it, so that a policy of depending only on published
+versions can be enforced while filling the module cache. The check applies
+to the modules added by -t as well.
+
+In addition to the module queries described in 'go help modules', download
+accepts version ranges of the form path@<version, path@<=version,
+path@>version, and path@>=version. Where 'go get' would select only the
+version in the range closest to the comparison, download downloads every
+tagged version of the module in the range. It considers the same versions
+'go get' would: prereleases are downloaded only if no release is in the
+range, and +incompatible versions are skipped when a compatible version in
+the range has a go.mod file. The operators <, <=, >, and >= are the only ones
+supported; download rejects others, such as = or ~.
+
+The -os and -arch flags restrict download to the modules that provide
+packages needed to build the main module's packages, and their tests, for the
+given operating systems and architectures, instead of downloading every
+module in the build list. Each flag accepts a comma-separated list, such as
+-os=linux,windows, and download takes the union of the modules needed for
+every combination of operating system and architecture. An omitted flag
+stands for the current GOOS or GOARCH. These flags only apply when no modules
+are named on the command line.
+
+The -package flag restricts download further, to the modules that provide
+the named packages and the packages they import, directly or indirectly,
+instead of those needed for all of the main module's packages. It accepts
+a comma-separated list of package patterns, such as -package=./cmd/foo,
+interpreted as in 'go build'. The tests of the named packages and of their
+dependencies are not considered. Like -os and -arch, with which it may be
+combined, -package only applies when no modules are named on the command
+line.
+
+The -purge flag causes download to remove module versions from the module
+cache instead of downloading them. Each argument must be of the form
+path@version, naming a specific version, and other versions of the same
+module are left in the cache. Download removes the version's extracted
+directory and its .info, .mod, .zip, and .ziphash files, holding the same
+lock used while downloading the version. If removal fails partway, the
+cache is left as though the version were only partly downloaded, and
+running download -purge again completes the removal.
+
+The -clean flag causes download, before downloading each module version,
+to remove the temporary files and directories that interrupted downloads of
+that version left in the module cache: files that were never renamed into
+place, including the partial zip files kept by -resume, and directories that
+were never completely extracted. Complete cached files and directories, and
+lock files, are left alone. With -x, download prints the names of the files
+it removes. The -clean flag cannot be combined with -n.
+
+The -verify-zip flag causes download to check the named zip file, obtained
+some other way, instead of downloading anything. The single argument must
+be of the form path@version, naming the module version the zip file is
+meant to hold. Download checks that the file is a well-formed module zip
+file for that version and that its checksum matches the one listed in
+go.sum or, if go.sum does not list one, the one in the checksum database.
+The file is not added to the module cache. With -json, download prints the
+result as a Module object whose Zip field names the file.
+
+The -list flag causes download to print the available versions of each
+named module instead of downloading anything. Each argument must be a
+module path, without a version. Download prints one line for each module,
+giving the path followed by its tagged versions in semantic version order,
+as 'go list -m -versions' does. Pseudo-versions are not listed, and no
+files are added to the module cache. With -json, download prints a
+ModuleVersions object for each module:
+
+    type ModuleVersions struct {
+        Path     string   // module path
+        Versions []string // available versions, oldest first
+        Error    string   // error listing versions
+    }
+
+An argument of the form @file names a file listing further arguments,
+one per line. Blank lines and lines beginning with # are ignored.
+
 See 'go help modules' for more about module queries.
 	`,
 }
 
-var downloadJSON = cmdDownload.Flag.Bool("json", false, "")
+var (
+	downloadJSON        jsonFlag
+	downloadCompact     = cmdDownload.Flag.Bool("compact", false, "")
+	downloadConcurrency = cmdDownload.Flag.Int("concurrency", 10, "")
+	downloadOutput      = cmdDownload.Flag.String("output", "", "")
+	downloadRetry       = cmdDownload.Flag.Int("retry", 0, "")
+	downloadStats       = cmdDownload.Flag.Bool("stats", false, "")
+	downloadVerify      = cmdDownload.Flag.Bool("verify", false, "")
+	downloadVerifyAfter = cmdDownload.Flag.Bool("verify-after", false, "")
+	downloadHeaders     = cmdDownload.Flag.String("trace-headers", "", "")
+	downloadMissing     = cmdDownload.Flag.Bool("missing", false, "")
+	downloadModOnly     = cmdDownload.Flag.Bool("mod-only", false, "")
+	downloadNoExtract   = cmdDownload.Flag.Bool("no-extract", false, "")
+	downloadReuse       = cmdDownload.Flag.String("reuse", "", "")
+	downloadKeepGoing   = cmdDownload.Flag.Bool("keep-going", false, "")
+	downloadOffline     = cmdDownload.Flag.Bool("offline", false, "")
+	downloadSumOnly     = cmdDownload.Flag.Bool("sumonly", false, "")
+	downloadSumFile     = cmdDownload.Flag.String("sumfile", "", "")
+	downloadProxy       = cmdDownload.Flag.String("proxy", "", "")
+	downloadNoSumDB     = cmdDownload.Flag.String("insecure-prefixes", "", "")
+	downloadTest        = cmdDownload.Flag.Bool("t", false, "")
+	downloadDryRun      = cmdDownload.Flag.Bool("n", false, "")
+	downloadDedup       = cmdDownload.Flag.Bool("dedup", false, "")
+	downloadTimeout     = cmdDownload.Flag.Duration("timeout", 0, "")
+	downloadPurge       = cmdDownload.Flag.Bool("purge", false, "")
+	downloadMaxRate     = cmdDownload.Flag.Int64("maxrate", 0, "")
+	downloadDebug       = cmdDownload.Flag.String("debug", "", "")
+	downloadOS          = cmdDownload.Flag.String("os", "", "")
+	downloadArch        = cmdDownload.Flag.String("arch", "", "")
+	downloadResume      = cmdDownload.Flag.Bool("resume", false, "")
+	downloadConditional = cmdDownload.Flag.Bool("conditional", false, "")
+	downloadMaxBuffer   = cmdDownload.Flag.Int("max-buffer", 0, "")
+	downloadCacheMode   = cmdDownload.Flag.String("cache-mode", "", "")
+	downloadOverlay     = cmdDownload.Flag.String("overlay-manifest", "", "")
+	downloadDigest      = cmdDownload.Flag.String("digest", "", "")
+	downloadProxyOnly   = cmdDownload.Flag.Bool("proxy-only", false, "")
+	downloadRepo        = cmdDownload.Flag.String("repo", "", "")
+	downloadIncludeMod  = cmdDownload.Flag.Bool("include-gomod", false, "")
+	downloadLicense     = cmdDownload.Flag.Bool("license", false, "")
+	downloadCacheStore  = cmdDownload.Flag.String("cache-store", "", "")
+	downloadFormat      = cmdDownload.Flag.String("f", "", "")
+	downloadUsage       = cmdDownload.Flag.Bool("usage", false, "")
+	downloadUnused      = cmdDownload.Flag.Bool("unused", false, "")
+	downloadSig         = cmdDownload.Flag.String("sig", "", "")
+	downloadReportOnly  = cmdDownload.Flag.Bool("report-only", false, "")
+	downloadSumDB       = cmdDownload.Flag.Bool("sumdb", false, "")
+	downloadExec        = cmdDownload.Flag.String("exec", "", "")
+	downloadVerifyZip   = cmdDownload.Flag.String("verify-zip", "", "")
+	downloadList        = cmdDownload.Flag.Bool("list", false, "")
+	downloadNoPseudo    = cmdDownload.Flag.Bool("no-pseudo", false, "")
+	downloadSumDBLog    = cmdDownload.Flag.String("sumdb-log", "", "")
+	downloadClean       = cmdDownload.Flag.Bool("clean", false, "")
+	downloadVendor      = cmdDownload.Flag.Bool("vendor", false, "")
+	downloadSince       = cmdDownload.Flag.String("since", "", "")
+	downloadPackage     = cmdDownload.Flag.String("package", "", "")
+	downloadSnapshot    = cmdDownload.Flag.String("snapshot", "", "")
+	downloadFromSnap    = cmdDownload.Flag.String("from-snapshot", "", "")
+	downloadBaseline    = cmdDownload.Flag.String("baseline", "", "")
+	downloadTopo        = cmdDownload.Flag.Bool("topo", false, "")
+	downloadMaxTotal    = cmdDownload.Flag.String("max-total-size", "", "")
+	downloadHealth      = cmdDownload.Flag.Bool("healthcheck", false, "")
+	downloadMajor       = cmdDownload.Flag.String("major", "", "")
+	downloadUserAgent   = cmdDownload.Flag.String("user-agent", "", "")
+	downloadFailOnWarn  = cmdDownload.Flag.Bool("fail-on-warning", false, "")
+)
 
 func init() {
 	cmdDownload.Run = runDownload // break init cycle
 
 	// TODO(jayconrod): https://golang.org/issue/35849 Apply -x to other 'go mod' commands.
 	cmdDownload.Flag.BoolVar(&cfg.BuildX, "x", false, "")
+	cmdDownload.Flag.Var(flagFunc(flagDownloadExclude), "exclude", "")
+	cmdDownload.Flag.Var(&downloadJSON, "json", "")
 	work.AddModCommonFlags(cmdDownload)
 }
 
+// jsonFlag is a custom flag.Value for -json.
+type jsonFlag string
+
+func (*jsonFlag) IsBoolFlag() bool { return true } // allow -json
+
+func (v *jsonFlag) Set(s string) error {
+	switch s {
+	case "false":
+		s = ""
+	case "true":
+		s = "stream"
+	case "stream", "array":
+	default:
+		return fmt.Errorf("must be stream or array")
+	}
+	*v = jsonFlag(s)
+	return nil
+}
+
+func (v *jsonFlag) String() string { return string(*v) }
+
+// downloadExclude holds the matchers for the patterns given by -exclude flags.
+var downloadExclude []func(string) bool
+
+func flagDownloadExclude(arg string) {
+	downloadExclude = append(downloadExclude, search.MatchPattern(arg))
+}
+
+// excluded reports whether path matches a pattern given by an -exclude flag.
+func excluded(path string) bool {
+	for _, match := range downloadExclude {
+		if match(path) {
+			return true
+		}
+	}
+	return false
+}
+
 type moduleJSON struct {
-	Path     string `json:",omitempty"`
-	Version  string `json:",omitempty"`
-	Error    string `json:",omitempty"`
-	Info     string `json:",omitempty"`
-	GoMod    string `json:",omitempty"`
-	Zip      string `json:",omitempty"`
-	Dir      string `json:",omitempty"`
-	Sum      string `json:",omitempty"`
-	GoModSum string `json:",omitempty"`
+	Path              string           `json:",omitempty"`
+	Version           string           `json:",omitempty"`
+	Time              *time.Time       `json:",omitempty"`
+	Error             string           `json:",omitempty"`
+	ErrorKind         string           `json:",omitempty"`
+	Info              string           `json:",omitempty"`
+	GoMod             string           `json:",omitempty"`
+	Zip               string           `json:",omitempty"`
+	Dir               string           `json:",omitempty"`
+	UncompressedSize  int64            `json:",omitempty"`
+	Sum               string           `json:",omitempty"`
+	GoModSum          string           `json:",omitempty"`
+	GoModContent      string           `json:",omitempty"`
+	GoVersion         string           `json:",omitempty"`
+	ToolchainRequired string           `json:",omitempty"`
+	Retries           int              `json:",omitempty"`
+	Verified          bool             `json:",omitempty"`
+	VerifiedAfter     bool             `json:",omitempty"`
+	Cached            bool             `json:",omitempty"`
+	WouldDownload     bool             `json:",omitempty"`
+	Origin            *modfetch.Origin `json:",omitempty"`
+	VCS               string           `json:",omitempty"`
+	Revision          string           `json:",omitempty"`
+	Insecure          bool             `json:",omitempty"`
+	Indirect          bool             `json:",omitempty"`
+	Mismatch          *mismatchJSON    `json:",omitempty"`
+	Auth              *authJSON        `json:",omitempty"`
+	SumDB             *sumDBJSON       `json:",omitempty"`
+	LicenseFiles      []string         `json:",omitempty"`
+	Headers           headersJSON      `json:",omitempty"`
+	SchemaVersion     int
+
+	interrupted bool // download was canceled by an interrupt before it finished
+}
+
+// jsonSchemaVersion is the SchemaVersion of moduleJSON. Increment it, and
+// update the description of the versions in the documentation above,
+// whenever a field is added to moduleJSON or changes its meaning.
+const jsonSchemaVersion = 1
+
+// headersJSON maps "info", "mod", and "zip" to the response headers
+// recorded for the module's files (see modfetch.ProxyHeaders).
+type headersJSON map[string]map[string]string
+
+type mismatchJSON struct {
+	File   string
+	Got    string
+	Want   string
+	Source string
+}
+
+type authJSON struct {
+	Host      string
+	Mechanism string
+}
+
+type sumDBJSON struct {
+	Name   string
+	Lookup string
+}
+
+type downloadSummary struct {
+	Downloaded int
+	Cached     int
+	Failed     int
+	Bytes      int64
+	Elapsed    float64
+}
+
+// wouldDownload reports whether downloading the module described by m
+// would fetch any of its files, rather than finding them all in the module cache.
+func wouldDownload(m *moduleJSON) bool {
+	mod := module.Version{Path: m.Path, Version: m.Version}
+	suffixes := []string{"info", "mod"}
+	if !*downloadModOnly && !*downloadSumOnly {
+		suffixes = append(suffixes, "zip")
+	}
+	for _, suffix := range suffixes {
+		file, err := modfetch.CachePath(mod, suffix)
+		if err != nil {
+			return true
+		}
+		if _, err := os.Stat(file); err != nil {
+			return true
+		}
+	}
+	return false
 }
 
 func runDownload(cmd *base.Command, args []string) {
+	start := time.Now()
+
+	// ctx bounds the fetching of the modules' files and the checks made
+	// after it. It is canceled by an interrupt (see notifyInterrupt).
+	ctx := context.Background()
+
 	// Check whether modules are enabled and whether we're in a module.
 	if cfg.Getenv("GO111MODULE") == "off" {
 		base.Fatalf("go: modules disabled by GO111MODULE=off; see 'go help modules'")
 	}
-	if !modload.HasModRoot() && len(args) == 0 {
-		base.Fatalf("go mod download: no modules specified (see 'go help mod download')")
+	if *downloadConcurrency < 1 {
+		base.Fatalf("go mod download: invalid -concurrency=%d: must be at least 1", *downloadConcurrency)
 	}
-	if len(args) == 0 {
-		args = []string{"all"}
-	} else if modload.HasModRoot() {
-		modload.InitMod() // to fill Target
-		targetAtLatest := modload.Target.Path + "@latest"
-		targetAtUpgrade := modload.Target.Path + "@upgrade"
-		targetAtPatch := modload.Target.Path + "@patch"
-		for _, arg := range args {
-			switch arg {
-			case modload.Target.Path, targetAtLatest, targetAtUpgrade, targetAtPatch:
-				os.Stderr.WriteString("go mod download: skipping argument " + arg + " that resolves to the main module\n")
-			}
-		}
+	if *downloadRetry < 0 {
+		base.Fatalf("go mod download: invalid -retry=%d: must not be negative", *downloadRetry)
 	}
-
-	var mods []*moduleJSON
-	var work par.Work
-	listU := false
-	listVersions := false
-	for _, info := range modload.ListModules(args, listU, listVersions) {
-		if info.Replace != nil {
-			info = info.Replace
-		}
-		if info.Version == "" && info.Error == nil {
-			// main module or module replaced with file path.
-			// Nothing to download.
-			continue
+	if *downloadTimeout < 0 {
+		base.Fatalf("go mod download: invalid -timeout=%v: must not be negative", *downloadTimeout)
+	}
+	var since time.Time
+	if *downloadSince != "" {
+		var err error
+		since, err = parseSince(*downloadSince)
+		if err != nil {
+			base.Fatalf("go mod download: invalid -since=%s: %v", *downloadSince, err)
 		}
-		m := &moduleJSON{
-			Path:    info.Path,
-			Version: info.Version,
+	}
+	var majorOK func(path, version string) bool
+	if *downloadMajor != "" {
+		var err error
+		majorOK, err = parseMajor(*downloadMajor)
+		if err != nil {
+			base.Fatalf("go mod download: invalid -major=%s: %v", *downloadMajor, err)
 		}
-		mods = append(mods, m)
-		if info.Error != nil {
-			m.Error = info.Error.Err
-			continue
+	}
+	if *downloadUserAgent != "" {
+		if strings.IndexFunc(*downloadUserAgent, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+			base.Fatalf("go mod download: invalid -user-agent: must not contain control characters")
 		}
-		work.Add(m)
+		web.UserAgent = *downloadUserAgent
 	}
-
-	work.Do(10, func(item interface{}) {
-		m := item.(*moduleJSON)
+	if *downloadMaxBuffer < 0 {
+		base.Fatalf("go mod download: invalid -max-buffer=%d: must not be negative", *downloadMaxBuffer)
+	}
+	var maxTotalSize int64
+	if *downloadMaxTotal != "" {
 		var err error
-		m.Info, err = modfetch.InfoFile(m.Path, m.Version)
+		maxTotalSize, err = parseSize(*downloadMaxTotal)
 		if err != nil {
-			m.Error = err.Error()
-			return
+			base.Fatalf("go mod download: invalid -max-total-size=%s: %v", *downloadMaxTotal, err)
+		}
+		if *downloadDryRun || *downloadModOnly || *downloadSumOnly {
+			base.Fatalf("go mod download: -max-total-size cannot be used with -n, -mod-only, or -sumonly")
+		}
+	}
+	var traceHeaderNames []string
+	if *downloadHeaders != "" {
+		for _, name := range strings.Split(*downloadHeaders, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || strings.ContainsAny(name, " :\t") {
+				base.Fatalf("go mod download: invalid -trace-headers=%s: must be a comma-separated list of header names", *downloadHeaders)
+			}
+			traceHeaderNames = append(traceHeaderNames, name)
+		}
+	}
+	var cacheMode os.FileMode
+	if *downloadCacheMode != "" {
+		mode, err := strconv.ParseUint(*downloadCacheMode, 8, 32)
+		if err != nil || mode > 0777 || mode&0600 != 0600 {
+			base.Fatalf("go mod download: invalid -cache-mode=%s: must be an octal permission mode granting the owner read and write permission", *downloadCacheMode)
 		}
-		m.GoMod, err = modfetch.GoModFile(m.Path, m.Version)
+		cacheMode = os.FileMode(mode)
+	}
+	if *downloadMaxRate < 0 {
+		base.Fatalf("go mod download: invalid -maxrate=%d: must not be negative", *downloadMaxRate)
+	}
+	modfetch.SetZipRateLimit(*downloadMaxRate)
+	if *downloadDebug != "" {
+		f, err := os.Create(*downloadDebug)
 		if err != nil {
-			m.Error = err.Error()
-			return
+			base.Fatalf("go mod download: %v", err)
+		}
+		base.AtExit(func() { f.Close() })
+		var mu sync.Mutex
+		enc := json.NewEncoder(f)
+		cfg.Trace = func(e cfg.TraceEntry) {
+			mu.Lock()
+			defer mu.Unlock()
+			enc.Encode(e)
+		}
+	}
+	if *downloadPurge && len(args) == 0 {
+		base.Fatalf("go mod download: -purge requires path@version arguments (see 'go help mod download')")
+	}
+	if *downloadVerifyZip != "" && (len(args) == 0 || *downloadPurge) {
+		base.Fatalf("go mod download: -verify-zip requires a single path@version argument (see 'go help mod download')")
+	}
+	if *downloadList && (len(args) == 0 || *downloadPurge || *downloadVerifyZip != "" || *downloadFormat != "" || *downloadDryRun) {
+		base.Fatalf("go mod download: -list requires module path arguments and cannot be used with -purge, -verify-zip, -f, or -n")
+	}
+	if *downloadProxy != "" {
+		cfg.GOPROXY = *downloadProxy
+	}
+	if *downloadNoSumDB != "" {
+		if v := cfg.Getenv("GONOSUMDB"); v != "" {
+			base.Fatalf("go mod download: -insecure-prefixes conflicts with GONOSUMDB=%s", v)
+		}
+		for _, glob := range strings.Split(*downloadNoSumDB, ",") {
+			if glob == "" {
+				continue
+			}
+			// Match scans as much of the pattern as it needs to, so match
+			// the pattern against itself to check all of it.
+			if _, err := path.Match(glob, glob); err != nil {
+				base.Fatalf("go mod download: invalid -insecure-prefixes pattern %q: %v", glob, err)
+			}
+		}
+		if cfg.GONOSUMDB == "" {
+			cfg.GONOSUMDB = *downloadNoSumDB
+		} else {
+			cfg.GONOSUMDB += "," + *downloadNoSumDB
+		}
+	}
+	if *downloadOffline {
+		modfetch.Offline = true
+	}
+	if *downloadResume {
+		modfetch.Resume = true
+	}
+	var cacheStore modfetch.CacheStore
+	if *downloadCacheStore != "" {
+		if *downloadOffline {
+			base.Fatalf("go mod download: -cache-store cannot be used with -offline")
 		}
-		m.GoModSum, err = modfetch.GoModSum(m.Path, m.Version)
+		dir, err := filepath.Abs(*downloadCacheStore)
 		if err != nil {
-			m.Error = err.Error()
-			return
+			base.Fatalf("go mod download: invalid -cache-store: %v", err)
 		}
-		mod := module.Version{Path: m.Path, Version: m.Version}
-		m.Zip, err = modfetch.DownloadZip(mod)
+		cacheStore = modfetch.DirStore(dir)
+	}
+	if *downloadUsage && (downloadJSON != "" || *downloadFormat != "" || *downloadDryRun || *downloadPurge) {
+		base.Fatalf("go mod download: -usage cannot be used with -json, -f, -n, or -purge")
+	}
+	if *downloadHealth && (downloadJSON != "" || *downloadFormat != "" || *downloadDryRun || *downloadUsage || *downloadPurge) {
+		base.Fatalf("go mod download: -healthcheck cannot be used with -json, -f, -n, -usage, or -purge")
+	}
+	var sigVerifier note.Verifier
+	if *downloadSig != "" {
+		v, err := note.NewVerifier(*downloadSig)
 		if err != nil {
-			m.Error = err.Error()
-			return
+			base.Fatalf("go mod download: invalid -sig key: %v", err)
 		}
-		m.Sum = modfetch.Sum(mod)
-		m.Dir, err = modfetch.Download(mod)
+		sigVerifier = v
+	}
+	if *downloadCompact && downloadJSON == "" && !*downloadUsage {
+		base.Fatalf("go mod download: -compact requires -json or -usage")
+	}
+	var execCmd []string
+	if *downloadExec != "" {
+		var err error
+		execCmd, err = str.SplitQuotedFields(*downloadExec)
 		if err != nil {
-			m.Error = err.Error()
-			return
+			base.Fatalf("go mod download: invalid -exec: %v", err)
 		}
-	})
-
-	if *downloadJSON {
-		for _, m := range mods {
-			b, err := json.MarshalIndent(m, "", "\t")
-			if err != nil {
-				base.Fatalf("%v", err)
-			}
-			os.Stdout.Write(append(b, '\n'))
-			if m.Error != "" {
-				base.SetExitStatus(1)
-			}
+		if len(execCmd) == 0 {
+			base.Fatalf("go mod download: invalid -exec: no command")
 		}
-	} else {
-		for _, m := range mods {
-			if m.Error != "" {
-				base.Errorf("%s", m.Error)
-			}
+	}
+	if *downloadUnused && !*downloadUsage {
+		base.Fatalf("go mod download: -unused requires -usage")
+	}
+	if *downloadFormat != "" && downloadJSON != "" {
+		base.Fatalf("go mod download: -f cannot be used with -json")
+	}
+	if *downloadIncludeMod && downloadJSON == "" && *downloadFormat == "" {
+		base.Fatalf("go mod download: -include-gomod requires -json or -f")
+	}
+	if *downloadLicense && downloadJSON == "" && *downloadFormat == "" {
+		base.Fatalf("go mod download: -license requires -json or -f")
+	}
+	if *downloadVendor {
+		if len(args) > 0 || *downloadRepo != "" || *downloadOS != "" || *downloadArch != "" || *downloadPackage != "" {
+			base.Fatalf("go mod download: -vendor cannot be used with module arguments, -repo, -os, -arch, or -package")
 		}
-		base.ExitIfErrors()
+		if !modload.HasModRoot() {
+			base.Fatalf("go mod download: -vendor: working directory is not part of a module")
+		}
+	}
+	if *downloadFromSnap != "" {
+		if len(args) > 0 || *downloadVendor || *downloadRepo != "" || *downloadOS != "" || *downloadArch != "" || *downloadPackage != "" {
+			base.Fatalf("go mod download: -from-snapshot cannot be used with module arguments, -vendor, -repo, -os, -arch, or -package")
+		}
+	}
+	if *downloadRepo != "" && (*downloadOffline || *downloadProxyOnly) {
+		base.Fatalf("go mod download: -repo cannot be used with -offline or -proxy-only")
+	}
+	if *downloadSumOnly {
+		if !modload.HasModRoot() {
+			base.Fatalf("go mod download: -sumonly requires a main module (see 'go help mod download')")
+		}
+		// Report missing checksums instead of adding them to go.sum.
+		modload.DisallowWriteGoMod()
+	}
+	if *downloadSumFile != "" {
+		file, err := filepath.Abs(*downloadSumFile)
+		if err == nil {
+			_, err = os.Stat(file)
+		}
+		if err != nil {
+			base.Fatalf("go mod download: invalid -sumfile: %v", err)
+		}
+		// Initialize modload first, since it sets modfetch.GoSumFile.
+		modload.HasModRoot()
+		modfetch.GoSumFile = file
+		modload.DisallowWriteGoMod()
+	}
+	if *downloadOverlay != "" && (*downloadModOnly || *downloadSumOnly || *downloadDryRun) {
+		base.Fatalf("go mod download: -overlay-manifest cannot be used with -mod-only, -sumonly, or -n")
+	}
+	if *downloadCacheMode != "" && *downloadOverlay != "" {
+		base.Fatalf("go mod download: -cache-mode cannot be used with -overlay-manifest")
+	}
+	if *downloadVerifyAfter && (*downloadModOnly || *downloadSumOnly || *downloadDryRun) {
+		base.Fatalf("go mod download: -verify-after cannot be used with -mod-only, -sumonly, or -n")
+	}
+	if *downloadMissing && (*downloadModOnly || *downloadSumOnly || *downloadVerify) {
+		base.Fatalf("go mod download: -missing cannot be used with -mod-only, -sumonly, or -verify")
+	}
+	if *downloadNoExtract && (*downloadModOnly || *downloadSumOnly || *downloadVerify || *downloadOverlay != "") {
+		base.Fatalf("go mod download: -no-extract cannot be used with -mod-only, -sumonly, -verify, or -overlay-manifest")
+	}
+	if *downloadClean && *downloadDryRun {
+		base.Fatalf("go mod download: -clean cannot be used with -n")
+	}
+	if *downloadDigest != "" && *downloadDryRun {
+		base.Fatalf("go mod download: -digest cannot be used with -n")
+	}
+	if *downloadSnapshot != "" && (*downloadDryRun || *downloadModOnly) {
+		base.Fatalf("go mod download: -snapshot cannot be used with -n or -mod-only")
+	}
+	if *downloadBaseline != "" && *downloadDryRun {
+		base.Fatalf("go mod download: -baseline cannot be used with -n")
+	}
+	if *downloadTopo && *downloadDryRun {
+		base.Fatalf("go mod download: -topo cannot be used with -n")
+	}
+	if *downloadOutput != "" {
+		dir, err := filepath.Abs(*downloadOutput)
+		if err != nil {
+			base.Fatalf("go mod download: invalid -output: %v", err)
+		}
+		*downloadOutput = dir
+	}
+
+	opts := modfetch.DownloadOptions{
+		Concurrency:      *downloadConcurrency,
+		Retry:            *downloadRetry,
+		Timeout:          *downloadTimeout,
+		ModOnly:          *downloadModOnly,
+		NoExtract:        *downloadNoExtract,
+		SumOnly:          *downloadSumOnly,
+		CheckSums:        *downloadSumFile != "",
+		Verify:           *downloadVerify,
+		Missing:          *downloadMissing,
+		MaxTotalSize:     maxTotalSize,
+		KeepGoing:        *downloadKeepGoing,
+		Signature:        sigVerifier,
+		ProxyOnly:        *downloadProxyOnly,
+		ReportMismatches: *downloadReportOnly,
+		Conditional:      *downloadConditional,
+		ExtractBuffer:    *downloadMaxBuffer,
+		CacheMode:        cacheMode,
+		TraceHeaders:     traceHeaderNames,
+		Store:            cacheStore,
+	}
+	// Resolving the arguments fetches files too,
+	// and should do so as DownloadModules does.
+	modfetch.SetDefaultOptions(opts)
+
+	if len(args) > 0 {
+		var err error
+		args, err = expandArgFiles(args)
+		if err != nil {
+			base.Fatalf("go mod download: %v", err)
+		}
+		if len(args) == 0 {
+			base.Fatalf("go mod download: no modules specified (see 'go help mod download')")
+		}
+		if *downloadPurge {
+			purgeModules(args)
+			return
+		}
+		if *downloadVerifyZip != "" {
+			verifyZipFile(*downloadVerifyZip, args)
+			return
+		}
+		if *downloadList {
+			listVersions(args)
+			return
+		}
+		args, err = expandRanges(args)
+		if err != nil {
+			base.Fatalf("go mod download: %v", err)
+		}
+	}
+	if *downloadRepo != "" {
+		mod, err := repoModule(*downloadRepo)
+		if err != nil {
+			base.Fatalf("go mod download: -repo=%s: %v", *downloadRepo, err)
+		}
+		args = append(args, mod.Path+"@"+mod.Version)
+	}
+	if !modload.HasModRoot() && len(args) == 0 && *downloadFromSnap == "" {
+		base.Fatalf("go mod download: no modules specified (see 'go help mod download')")
+	}
+	if len(args) == 0 {
+		args = []string{"all"}
+	} else if modload.HasModRoot() {
+		modload.InitMod() // to fill Target
+		targetAtLatest := modload.Target.Path + "@latest"
+		targetAtUpgrade := modload.Target.Path + "@upgrade"
+		targetAtPatch := modload.Target.Path + "@patch"
+		for _, arg := range args {
+			switch arg {
+			case modload.Target.Path, targetAtLatest, targetAtUpgrade, targetAtPatch:
+				warnf("skipping argument %s that resolves to the main module", arg)
+			}
+		}
+	}
+
+	var targets map[string]bool
+	if *downloadOS != "" || *downloadArch != "" || *downloadPackage != "" {
+		if !modload.HasModRoot() || len(args) != 1 || args[0] != "all" {
+			if *downloadPackage != "" {
+				base.Fatalf("go mod download: -package applies only to the dependencies of the main module (see 'go help mod download')")
+			}
+			base.Fatalf("go mod download: -os and -arch apply only to the dependencies of the main module (see 'go help mod download')")
+		}
+		goosList, goarchList, err := parseTargets(*downloadOS, *downloadArch)
+		if err != nil {
+			base.Fatalf("go mod download: %v", err)
+		}
+		patterns := []string{"all"}
+		if *downloadPackage != "" {
+			patterns = strings.Split(*downloadPackage, ",")
+		}
+		targets = targetModules(patterns, goosList, goarchList)
+	}
+
+	if !cfg.BuildX && isTerminal(os.Stderr) && os.Getenv("TERM") != "dumb" {
+		p := newProgressBar()
+		modfetch.SetProgressFunc(p.update)
+		defer func() {
+			modfetch.SetProgressFunc(nil)
+			p.clear()
+		}()
+	}
+
+	var reuse map[module.Version]*moduleJSON
+	if *downloadReuse != "" {
+		var err error
+		reuse, err = readReuse(*downloadReuse)
+		if err != nil {
+			base.Fatalf("go mod download: -reuse: %v", err)
+		}
+	}
+	var baseline map[module.Version]snapshotModule
+	if *downloadBaseline != "" {
+		var err error
+		_, baseline, err = readSnapshot(*downloadBaseline)
+		if err != nil {
+			base.Fatalf("go mod download: -baseline: %v", err)
+		}
+	}
+
+	var mods []*moduleJSON
+	seen := make(map[module.Version]bool)
+	listU := false
+	listVersions := false
+	var infos []*modinfo.ModulePublic
+	var pinned map[module.Version]snapshotModule
+	if *downloadFromSnap != "" {
+		var err error
+		infos, pinned, err = readSnapshot(*downloadFromSnap)
+		if err != nil {
+			base.Fatalf("go mod download: -from-snapshot: %v", err)
+		}
+	} else if *downloadVendor {
+		var err error
+		infos, err = modload.VendorModules()
+		if errors.Is(err, os.ErrNotExist) {
+			base.Fatalf("go mod download: -vendor: no vendor/modules.txt file in %s (see 'go help mod vendor')", modload.ModRoot())
+		} else if err != nil {
+			base.Fatalf("go mod download: -vendor: %v", err)
+		}
+	} else {
+		infos = modload.ListModules(args, listU, listVersions)
+	}
+	isIndirect := indirectFunc()
+	for _, info := range infos {
+		if excluded(info.Path) {
+			continue
+		}
+		if majorOK != nil && !majorOK(info.Path, info.Version) {
+			continue
+		}
+		if targets != nil && !targets[info.Path] {
+			continue
+		}
+		indirect := isIndirect(info)
+		if info.Replace != nil {
+			info = info.Replace
+		}
+		if info.Version == "" && info.Error == nil {
+			// main module or module replaced with file path.
+			// Nothing to download.
+			continue
+		}
+		if !since.IsZero() && info.Error == nil && info.Time != nil && !info.Time.IsZero() && !info.Time.After(since) {
+			continue
+		}
+		if info.Version != "" {
+			// Report each module version once, where it was first listed,
+			// even if several arguments resolve to it.
+			mod := module.Version{Path: info.Path, Version: info.Version}
+			if seen[mod] {
+				continue
+			}
+			seen[mod] = true
+		}
+		m := &moduleJSON{
+			Path:          info.Path,
+			Version:       info.Version,
+			Indirect:      indirect,
+			Time:          info.Time,
+			SchemaVersion: jsonSchemaVersion,
+		}
+		mods = append(mods, m)
+		if info.Error != nil {
+			m.Error = info.Error.Err
+			if info.Version != "" {
+				// ListModules reports only the text of the error.
+				// Repeat the (cached) query to classify it.
+				if _, err := modload.Query(info.Path, info.Version, "", nil); err != nil {
+					m.ErrorKind = errorKind(err)
+					m.Auth = authInfo(err)
+				}
+			}
+		}
+	}
+	if *downloadTest {
+		mods = addRequired(mods)
+	}
+	if *downloadNoPseudo {
+		for _, m := range mods {
+			if m.Error == "" && modfetch.IsPseudoVersion(m.Version) {
+				setError(m, errors.New("pseudo-version not allowed by -no-pseudo"))
+				m.ErrorKind = errorPseudoVersion
+			}
+		}
+	}
+	checkCaseCollisions(mods)
+	// Report modules in a fixed order, whatever order the arguments
+	// resolved in and the downloads finish in.
+	sort.SliceStable(mods, func(i, j int) bool {
+		mi, mj := mods[i], mods[j]
+		if mi.Path != mj.Path {
+			return mi.Path < mj.Path
+		}
+		return semver.Compare(mi.Version, mj.Version) < 0
+	})
+	if *downloadTopo {
+		sortTopo(mods)
+	}
+	if *downloadUsage {
+		reportUsage(mods)
+		return
+	}
+	if *downloadHealth {
+		reportHealth(ctx, mods)
+		return
+	}
+	// In -json and -f modes, each module is printed as soon as download
+	// is done with it and with the modules before it, rather than once
+	// every module has been processed.
+	var stream *moduleStream
+	if downloadJSON == "stream" {
+		stream = newModuleStream(os.Stdout, mods)
+	}
+	if *downloadFormat != "" {
+		tmpl, err := template.New("main").Funcs(template.FuncMap{"join": strings.Join}).Parse(*downloadFormat)
+		if err != nil {
+			base.Fatalf("go mod download: -f: %v", err)
+		}
+		stream = newModuleStream(os.Stdout, mods)
+		stream.tmpl = tmpl
+	}
+	var report func(m *moduleJSON)
+	finish := func(m *moduleJSON) {
+		if pinned != nil && m.Error == "" && !*downloadDryRun {
+			checkSnapshot(m, pinned[module.Version{Path: m.Path, Version: m.Version}], "snapshot")
+		}
+		if baseline != nil && m.Error == "" {
+			checkSnapshot(m, baseline[module.Version{Path: m.Path, Version: m.Version}], "baseline")
+		}
+		if *downloadCacheStore != "" && m.Error == "" && !*downloadDryRun {
+			if err := modfetch.StoreModule(opts.Store, module.Version{Path: m.Path, Version: m.Version}); err != nil {
+				setError(m, err)
+			}
+		}
+		if *downloadIncludeMod && m.Error == "" {
+			includeGoMod(m)
+		}
+		if *downloadLicense && m.Error == "" && m.Dir != "" {
+			licenseFiles(m)
+		}
+		if *downloadSumDB && m.Error == "" && !*downloadDryRun {
+			name, file, err := modfetch.CacheSumDB(module.Version{Path: m.Path, Version: m.Version})
+			if err != nil {
+				setError(m, err)
+			} else {
+				m.SumDB = &sumDBJSON{Name: name, Lookup: file}
+			}
+		}
+		if len(opts.TraceHeaders) > 0 && !*downloadDryRun {
+			traceHeaders(m)
+		}
+		if *downloadVerifyAfter {
+			// Reported after the verification pass.
+			return
+		}
+		report(m)
+	}
+	report = func(m *moduleJSON) {
+		warnModule(m)
+		if stream != nil && stream.tmpl == nil {
+			stream.write(m)
+			if m.Error != "" {
+				base.SetExitStatus(1)
+			}
+		} else if stream != nil && m.Error == "" {
+			stream.write(m)
+		} else if stream != nil {
+			// Errors are reported to standard error below.
+			stream.skip(m)
+		}
+	}
+
+	if *downloadClean {
+		for _, m := range mods {
+			if m.Error != "" {
+				continue
+			}
+			removed, err := modfetch.CleanTemp(module.Version{Path: m.Path, Version: m.Version})
+			if cfg.BuildX {
+				for _, file := range removed {
+					fmt.Fprintf(os.Stderr, "rm -rf %s\n", file)
+				}
+			}
+			if err != nil {
+				base.Errorf("go mod download: -clean: %v", err)
+			}
+		}
+	}
+
+	var queued []*moduleJSON
+	for _, m := range mods {
+		if m.Error != "" {
+			finish(m)
+			continue
+		}
+		if *downloadDryRun {
+			m.WouldDownload = wouldDownload(m)
+			m.Cached = !m.WouldDownload && !*downloadModOnly
+			finish(m)
+			continue
+		}
+		if r := reuse[module.Version{Path: m.Path, Version: m.Version}]; r != nil && reusable(r) {
+			indirect := m.Indirect
+			*m = *r
+			m.Indirect = indirect
+			m.SchemaVersion = jsonSchemaVersion
+			m.Retries = 0
+			m.Verified = false
+			m.Cached = true
+			finish(m)
+			continue
+		}
+		queued = append(queued, m)
+	}
+
+	ctx, interrupted, stopInterrupt := notifyInterrupt(ctx)
+	opts.Done = func(i int, r *modfetch.DownloadResult) {
+		if r.Err != nil && interrupted() {
+			// The error is most likely the cancellation itself.
+			queued[i].interrupted = true
+			return
+		}
+		setResult(queued[i], *r)
+		finish(queued[i])
+	}
+	if *downloadOutput != "" || execCmd != nil {
+		opts.Fetched = func(r *modfetch.DownloadResult) error {
+			if *downloadOutput != "" {
+				if err := copyToOutput(*downloadOutput, r); err != nil {
+					return err
+				}
+			}
+			if execCmd != nil {
+				return runExec(execCmd, r)
+			}
+			return nil
+		}
+	}
+	queuedMods := make([]module.Version, len(queued))
+	for i, m := range queued {
+		queuedMods[i] = module.Version{Path: m.Path, Version: m.Version}
+	}
+	modfetch.DownloadModules(ctx, queuedMods, opts)
+	stopInterrupt()
+	if maxTotalSize > 0 {
+		skipped := 0
+		for _, m := range queued {
+			if m.ErrorKind == errorSizeBudget {
+				skipped++
+			}
+		}
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "go mod download: -max-total-size: skipped %d of %d modules\n", skipped, len(mods))
+		}
+	}
+	if *downloadMissing && !interrupted() {
+		missing, present := 0, 0
+		for _, m := range queued {
+			if !m.Cached {
+				missing++
+			}
+		}
+		for _, m := range mods {
+			if m.Cached {
+				present++
+			}
+		}
+		fmt.Fprintf(os.Stderr, "go mod download: %d missing, %d already in module cache\n", missing, present)
+	}
+	if *downloadVerifyAfter {
+		if !interrupted() {
+			verifyAfter(ctx, mods)
+		}
+		for _, m := range mods {
+			if !m.interrupted {
+				report(m)
+			}
+		}
+	}
+	if stream != nil {
+		// Print the modules held back behind any that were interrupted.
+		stream.flush()
+	}
+	if interrupted() {
+		var finished []*moduleJSON
+		for _, m := range mods {
+			if !m.interrupted {
+				finished = append(finished, m)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "go mod download: interrupted: %d of %d modules not downloaded\n", len(mods)-len(finished), len(mods))
+		mods = finished
+		base.SetExitStatus(exitInterrupted)
+	}
+
+	// The workers record the checksums of the zip files they download in
+	// modfetch's copy of go.sum, which is written here, once, rather than
+	// as each module finishes. With -sumonly and -sumfile, go.sum is only
+	// read.
+	if !*downloadDryRun && !*downloadSumOnly && *downloadSumFile == "" {
+		modfetch.WriteGoSum()
+	}
+
+	if *downloadOutput != "" && !*downloadDryRun {
+		if err := writeOutputLists(*downloadOutput, mods); err != nil {
+			base.Errorf("go mod download: %v", err)
+		}
+	}
+	if *downloadOverlay != "" {
+		if err := writeOverlayManifest(*downloadOverlay, mods); err != nil {
+			base.Errorf("go mod download: -overlay-manifest: %v", err)
+		}
+	}
+	if *downloadDigest != "" {
+		if err := writeDigest(*downloadDigest, mods); err != nil {
+			base.Errorf("go mod download: -digest: %v", err)
+		}
+	}
+	if *downloadSnapshot != "" {
+		if err := writeSnapshot(*downloadSnapshot, mods); err != nil {
+			base.Errorf("go mod download: -snapshot: %v", err)
+		}
+	}
+	if *downloadSumDBLog != "" {
+		data, err := json.MarshalIndent(modfetch.SumDBTreeHeads(), "", "\t")
+		if err == nil {
+			err = renameio.WriteFile(*downloadSumDBLog, append(data, '\n'), 0666)
+		}
+		if err != nil {
+			base.Errorf("go mod download: -sumdb-log: %v", err)
+		}
+	}
+
+	if *downloadKeepGoing {
+		failed := 0
+		for _, m := range mods {
+			if m.Error != "" {
+				failed++
+			}
+		}
+		if failed > 0 {
+			base.Errorf("go mod download: %d of %d modules failed", failed, len(mods))
+		}
+	}
+	if *downloadFailOnWarn {
+		warnings.Lock()
+		n := len(warnings.list)
+		warnings.Unlock()
+		if n == 1 {
+			base.Errorf("go mod download: -fail-on-warning: 1 warning")
+		} else if n > 1 {
+			base.Errorf("go mod download: -fail-on-warning: %d warnings", n)
+		}
+	}
+
+	var summary *downloadSummary
+	if *downloadStats {
+		summary = summarize(mods, time.Since(start))
+	}
+
+	if downloadJSON == "array" {
+		var array interface{} = mods
+		if mods == nil {
+			array = []*moduleJSON{}
+		}
+		if summary != nil {
+			// The summary follows the modules, as in the stream.
+			elems := make([]interface{}, 0, len(mods)+1)
+			for _, m := range mods {
+				elems = append(elems, m)
+			}
+			array = append(elems, summary)
+		}
+		b, err := marshalJSON(array)
+		if err != nil {
+			base.Fatalf("%v", err)
+		}
+		os.Stdout.Write(append(b, '\n'))
+		for _, m := range mods {
+			if m.Error != "" {
+				base.SetExitStatus(1)
+			}
+		}
+	} else if stream != nil && stream.tmpl == nil {
+		if summary != nil {
+			stream.write(summary)
+		}
+	} else {
+		for _, m := range mods {
+			if m.Error != "" {
+				base.Errorf("%s", m.Error)
+			} else if m.WouldDownload && stream == nil {
+				fmt.Printf("%s@%s\n", m.Path, m.Version)
+			}
+		}
+		if summary != nil {
+			fmt.Fprintf(os.Stderr, "go mod download: %d downloaded (%d bytes), %d cached, %d failed in %.3fs\n", summary.Downloaded, summary.Bytes, summary.Cached, summary.Failed, summary.Elapsed)
+		}
+		base.ExitIfErrors()
+	}
+}
+
+// sortTopo sorts mods so that each module comes after the modules in mods
+// that its go.mod file requires, in any version. Modules the requirements
+// do not order keep their relative order, and a requirement cycle is broken
+// where it is first reached.
+func sortTopo(mods []*moduleJSON) {
+	byPath := make(map[string][]int)
+	for i, m := range mods {
+		byPath[m.Path] = append(byPath[m.Path], i)
+	}
+
+	// deps[i] lists the indexes of the modules required by mods[i].
+	// Each worker writes only its own entry, so no locking is needed.
+	deps := make([][]int, len(mods))
+	reqs := modload.Reqs()
+	var work par.Work
+	for i, m := range mods {
+		if m.Error == "" {
+			work.Add(i)
+		}
+	}
+	work.Do(*downloadConcurrency, func(item interface{}) {
+		i := item.(int)
+		list, err := reqs.Required(module.Version{Path: mods[i].Path, Version: mods[i].Version})
+		if err != nil {
+			// Downloading the module reports the problem.
+			return
+		}
+		for _, r := range list {
+			for _, j := range byPath[r.Path] {
+				if j != i {
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+		sort.Ints(deps[i])
+	})
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(mods))
+	sorted := make([]*moduleJSON, 0, len(mods))
+	var visit func(i int)
+	visit = func(i int) {
+		if state[i] != unvisited {
+			return
+		}
+		state[i] = visiting
+		for _, j := range deps[i] {
+			visit(j)
+		}
+		state[i] = visited
+		sorted = append(sorted, mods[i])
+	}
+	for i := range mods {
+		visit(i)
+	}
+	copy(mods, sorted)
+}
+
+// verifyAfter checks each module in mods downloaded without error against
+// its checksum again, reading its files back from the module cache.
+// If ctx is done, the modules not yet checked are left unverified.
+func verifyAfter(ctx context.Context, mods []*moduleJSON) {
+	var work par.Work
+	for _, m := range mods {
+		if m.Error == "" && m.Zip != "" {
+			work.Add(m)
+		}
+	}
+	work.Do(runtime.GOMAXPROCS(0), func(item interface{}) {
+		m := item.(*moduleJSON)
+		if ctx.Err() != nil {
+			return
+		}
+		if err := modfetch.VerifyCached(module.Version{Path: m.Path, Version: m.Version}); err != nil {
+			setError(m, err)
+			return
+		}
+		m.VerifiedAfter = true
+	})
+}
+
+// exitInterrupted is the exit status of download after an interrupt.
+const exitInterrupted = 130
+
+// notifyInterrupt returns a context derived from parent that is canceled
+// when the go command receives SIGINT or SIGTERM, along with a function
+// reporting whether that has happened and a function to stop watching for
+// the signals. After the first signal, the signals regain their default
+// behavior, so that a second one stops the go command at once.
+func notifyInterrupt(parent context.Context) (ctx context.Context, interrupted func() bool, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+	var got int32
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			signal.Stop(sig)
+			atomic.StoreInt32(&got, 1)
+			cancel()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(sig)
+			close(done)
+		})
+	}
+	return ctx, func() bool { return atomic.LoadInt32(&got) != 0 }, stop
+}
+
+// A usageReport is the output of -usage.
+type usageReport struct {
+	Modules     []moduleUsage
+	Total       int64
+	Unused      []moduleUsage `json:",omitempty"`
+	UnusedTotal int64         `json:",omitempty"`
+}
+
+type moduleUsage struct {
+	Path     string
+	Version  string
+	Download int64
+	Dir      int64
+	Total    int64
+}
+
+// reportUsage prints the -usage report for mods.
+func reportUsage(mods []*moduleJSON) {
+	report := usageReport{Modules: []moduleUsage{}}
+	used := make(map[module.Version]bool)
+	for _, m := range mods {
+		if m.Error != "" {
+			base.Errorf("%s", m.Error)
+			continue
+		}
+		mod := module.Version{Path: m.Path, Version: m.Version}
+		used[mod] = true
+		if mu, ok := usage(mod); ok {
+			report.Modules = append(report.Modules, mu)
+			report.Total += mu.Total
+		}
+	}
+	if *downloadUnused {
+		cached, err := modfetch.CachedModules()
+		if err != nil {
+			base.Fatalf("go mod download: %v", err)
+		}
+		report.Unused = []moduleUsage{}
+		for _, mod := range cached {
+			if used[mod] {
+				continue
+			}
+			if mu, ok := usage(mod); ok {
+				report.Unused = append(report.Unused, mu)
+				report.UnusedTotal += mu.Total
+			}
+		}
+	}
+	sortUsage(report.Modules)
+	sortUsage(report.Unused)
+
+	b, err := marshalJSON(report)
+	if err != nil {
+		base.Fatalf("%v", err)
+	}
+	os.Stdout.Write(append(b, '\n'))
+	base.ExitIfErrors()
+}
+
+// A healthReport is the output of -healthcheck.
+type healthReport struct {
+	Modules []moduleHealth
+	Failed  int
+}
+
+type moduleHealth struct {
+	Path    string
+	Version string
+	Proxy   string `json:",omitempty"`
+	Status  int    `json:",omitempty"`
+	Latency float64
+	Error   string `json:",omitempty"`
+}
+
+// reportHealth prints the -healthcheck report for mods.
+func reportHealth(ctx context.Context, mods []*moduleJSON) {
+	report := healthReport{Modules: make([]moduleHealth, len(mods))}
+	var work par.Work
+	for i, m := range mods {
+		report.Modules[i] = moduleHealth{Path: m.Path, Version: m.Version, Error: m.Error}
+		if m.Error == "" || (m.Version != "" && module.CanonicalVersion(m.Version) == m.Version) {
+			work.Add(i)
+		}
+	}
+	// Each worker writes only its own entry, so no locking is needed.
+	work.Do(*downloadConcurrency, func(item interface{}) {
+		h := &report.Modules[item.(int)]
+		r := modfetch.ProbeInfo(ctx, module.Version{Path: h.Path, Version: h.Version})
+		h.Proxy = r.Proxy
+		h.Status = r.Status
+		h.Latency = r.Latency.Seconds()
+		h.Error = ""
+		if r.Err != nil {
+			h.Error = downloadError(&moduleJSON{Path: h.Path, Version: h.Version}, r.Err)
+		}
+	})
+	for _, h := range report.Modules {
+		if h.Error != "" {
+			report.Failed++
+		}
+	}
+
+	b, err := marshalJSON(report)
+	if err != nil {
+		base.Fatalf("%v", err)
+	}
+	os.Stdout.Write(append(b, '\n'))
+	if report.Failed > 0 {
+		base.SetExitStatus(1)
+	}
+}
+
+// usage returns the -usage entry for mod,
+// or false if mod uses no space in the module cache.
+func usage(mod module.Version) (moduleUsage, bool) {
+	u, err := modfetch.Usage(mod)
+	if err != nil {
+		base.Errorf("go mod download: %v", err)
+		return moduleUsage{}, false
+	}
+	if u.Total() == 0 {
+		return moduleUsage{}, false
+	}
+	return moduleUsage{
+		Path:     mod.Path,
+		Version:  mod.Version,
+		Download: u.Download,
+		Dir:      u.Dir,
+		Total:    u.Total(),
+	}, true
+}
+
+func sortUsage(list []moduleUsage) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return semver.Compare(list[i].Version, list[j].Version) < 0
+	})
+}
+
+// includeGoMod sets m.GoModContent for -include-gomod.
+func includeGoMod(m *moduleJSON) {
+	var data []byte
+	var err error
+	if m.GoMod != "" {
+		data, err = ioutil.ReadFile(m.GoMod)
+	} else {
+		data, err = modfetch.GoModFileBytes(m.Path, m.Version)
+	}
+	if err != nil {
+		setError(m, err)
+		return
+	}
+	m.GoModContent = string(data)
+}
+
+// licenseFiles sets m.LicenseFiles for -license.
+func licenseFiles(m *moduleJSON) {
+	files, err := ioutil.ReadDir(m.Dir)
+	if err != nil {
+		setError(m, err)
+		return
+	}
+	m.LicenseFiles = nil
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if isLicenseFile(f.Name()) {
+			m.LicenseFiles = append(m.LicenseFiles, f.Name())
+		}
+	}
+}
+
+// isLicenseFile reports whether name is LICENSE, COPYING, or NOTICE,
+// in any case, optionally followed by a suffix such as .md or -MIT
+// that does not begin with a letter or digit: licensed.go is not one.
+func isLicenseFile(name string) bool {
+	name = strings.ToUpper(name)
+	for _, prefix := range []string{"LICENSE", "COPYING", "NOTICE"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		if rest == "" {
+			return true
+		}
+		c := rest[0]
+		return !('A' <= c && c <= 'Z' || '0' <= c && c <= '9')
+	}
+	return false
+}
+
+// warnModule sets m.ToolchainRequired and prints warnings about m,
+// once download is done with it.
+func warnModule(m *moduleJSON) {
+	m.ToolchainRequired = ""
+	if m.Error != "" {
+		return
+	}
+	if goVersionNewer(m.GoVersion) {
+		m.ToolchainRequired = "go" + m.GoVersion
+		warnf("warning: %s@%s requires go %s (running go %s)", m.Path, m.Version, m.GoVersion, toolchainVersion())
+	}
+	if m.Insecure {
+		from := "an insecure source"
+		if m.Origin != nil {
+			from = m.Origin.Proxy
+			if from == "direct" {
+				from = m.Origin.URL
+			}
+		}
+		warnf("warning: %s@%s was fetched over an insecure connection from %s", m.Path, m.Version, from)
+	}
+}
+
+// warnings holds the warnings printed by warnf, for -fail-on-warning.
+var warnings struct {
+	sync.Mutex
+	list []string
+}
+
+// warnf prints a warning to standard error and records it in warnings.
+// It may be called concurrently for different modules.
+func warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	warnings.Lock()
+	defer warnings.Unlock()
+	warnings.list = append(warnings.list, msg)
+	fmt.Fprintf(os.Stderr, "go mod download: %s\n", msg)
+}
+
+// traceHeaders sets m.Headers to the proxy response headers recorded for
+// the module, printing them to standard error unless -json is set.
+func traceHeaders(m *moduleJSON) {
+	m.Headers = modfetch.ProxyHeaders(module.Version{Path: m.Path, Version: m.Version})
+	if downloadJSON != "" {
+		return
+	}
+	for _, file := range []string{"info", "mod", "zip"} {
+		h := m.Headers[file]
+		names := make([]string, 0, len(h))
+		for name := range h {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "%s@%s %s: %s: %s\n", m.Path, m.Version, file, name, h[name])
+		}
+	}
+}
+
+// A moduleStream prints values to w, one at a time: as JSON or,
+// if tmpl is non-nil, by executing tmpl. Modules are printed in a fixed
+// order: one written before those that precede it is held back until they
+// have been written or skipped.
+// It is safe for concurrent use.
+type moduleStream struct {
+	mu   sync.Mutex
+	w    io.Writer
+	tmpl *template.Template
+
+	index map[*moduleJSON]int // position of each module in the output
+	held  map[int][]byte      // output of modules waiting for earlier ones
+	next  int                 // position of the next module to print
+}
+
+// newModuleStream returns a moduleStream printing to w
+// that prints the modules in mods in order.
+func newModuleStream(w io.Writer, mods []*moduleJSON) *moduleStream {
+	s := &moduleStream{
+		w:     w,
+		index: make(map[*moduleJSON]int),
+		held:  make(map[int][]byte),
+	}
+	for i, m := range mods {
+		s.index[m] = i
+	}
+	return s
+}
+
+// skip records that nothing is to be printed for m.
+func (s *moduleStream) skip(m *moduleJSON) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(m, nil)
+}
+
+// release records b as the output for m and prints
+// the output of every module now ready to be printed.
+// s.mu must be held.
+func (s *moduleStream) release(m *moduleJSON, b []byte) {
+	s.held[s.index[m]] = b
+	for {
+		b, ok := s.held[s.next]
+		if !ok {
+			return
+		}
+		s.w.Write(b)
+		delete(s.held, s.next)
+		s.next++
+	}
+}
+
+// flush prints the output held for modules,
+// even though modules before them have not been written.
+func (s *moduleStream) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.next < len(s.index) {
+		if b, ok := s.held[s.next]; ok {
+			s.w.Write(b)
+			delete(s.held, s.next)
+		}
+		s.next++
+	}
+}
+
+// write prints v to s.w, followed by a newline if needed.
+func (s *moduleStream) write(v interface{}) {
+	var b []byte
+	if s.tmpl != nil {
+		var buf bytes.Buffer
+		if err := s.tmpl.Execute(&buf, v); err != nil {
+			base.Fatalf("go mod download: -f: %v", err)
+		}
+		b = buf.Bytes()
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+	} else {
+		var err error
+		b, err = marshalJSON(v)
+		if err != nil {
+			base.Fatalf("%v", err)
+		}
+		b = append(b, '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := v.(*moduleJSON); ok {
+		if _, ok := s.index[m]; ok {
+			s.release(m, b)
+			return
+		}
+	}
+	s.w.Write(b)
+}
+
+// marshalJSON returns the JSON encoding of v to be printed to standard
+// output: indented, or on one line with -compact.
+func marshalJSON(v interface{}) ([]byte, error) {
+	if *downloadCompact {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", "\t")
+}
+
+// parseSince parses the argument of the -since flag.
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("must be an RFC 3339 time or a date of the form 2006-01-02")
+}
+
+// parseMajor parses the argument of the -major flag, returning a function
+// reporting whether the module with the given path and version is selected.
+func parseMajor(s string) (func(path, version string) bool, error) {
+	type span struct{ min, max int }
+	var spans []span
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		orLater := strings.HasSuffix(f, "+")
+		n, err := strconv.Atoi(strings.TrimSuffix(f, "+"))
+		if err != nil || n < 0 {
+			return nil, errors.New("must be a comma-separated list of major versions, such as 0,1 or 2+")
+		}
+		sp := span{n, n}
+		if orLater {
+			sp.max = math.MaxInt32
+		}
+		spans = append(spans, sp)
+	}
+	return func(path, version string) bool {
+		lo, hi := moduleMajor(path, version)
+		for _, sp := range spans {
+			if sp.min <= hi && lo <= sp.max {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// moduleMajor returns the range of major version numbers possible for the
+// module with the given path and version: the major version of the version
+// if it is valid, and otherwise the one given by the path's major version
+// suffix, where no suffix allows both v0 and v1.
+func moduleMajor(path, version string) (lo, hi int) {
+	major := semver.Major(version)
+	if major == "" {
+		_, pathMajor, _ := module.SplitPathVersion(path)
+		if pathMajor == "" {
+			return 0, 1
+		}
+		major = strings.TrimLeft(pathMajor, "/.")
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(major, "v"))
+	if err != nil {
+		return 0, 1
+	}
+	return n, n
+}
+
+// parseSize parses the argument of the -max-total-size flag:
+// a number of bytes, optionally followed by a unit such as MB or GiB.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"B", 1},
+	}
+	num, scale := s, int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			num, scale = strings.TrimSuffix(s, u.suffix), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/scale {
+		return 0, errors.New("must be a positive number of bytes, optionally followed by a unit such as KB, MB, GB, or GiB")
+	}
+	return n * scale, nil
+}
+
+// toolchainVersion returns the version of the latest Go release
+// supported by this go command, such as "1.14".
+func toolchainVersion() string {
+	tags := build.Default.ReleaseTags
+	return strings.TrimPrefix(tags[len(tags)-1], "go")
+}
+
+// goVersionNewer reports whether v, the version from a go directive,
+// is newer than the version of this go command.
+func goVersionNewer(v string) bool {
+	if v == "" || !modfile.GoVersionRE.MatchString(v) {
+		return false
+	}
+	return semver.Compare("v"+v, "v"+toolchainVersion()) > 0
+}
+
+// addRequired appends to mods the modules required, directly or indirectly,
+// by each module in mods, as selected by the module's own build list.
+// Errors loading the requirements of a module are recorded in that module.
+func addRequired(mods []*moduleJSON) []*moduleJSON {
+	seen := make(map[module.Version]bool)
+	for _, m := range mods {
+		seen[module.Version{Path: m.Path, Version: m.Version}] = true
+	}
+	reqs := modload.Reqs()
+	listed := mods
+	for _, m := range listed {
+		if m.Error != "" {
+			continue
+		}
+		list, err := mvs.BuildList(module.Version{Path: m.Path, Version: m.Version}, reqs)
+		if err != nil {
+			setError(m, err)
+			continue
+		}
+		for _, mod := range list[1:] {
+			if mod == modload.Target || excluded(mod.Path) {
+				continue
+			}
+			if r := modload.Replacement(mod); r.Path != "" {
+				if r.Version == "" {
+					// Replaced with a file path. Nothing to download.
+					continue
+				}
+				mod = r
+			}
+			if seen[mod] {
+				continue
+			}
+			seen[mod] = true
+			mods = append(mods, &moduleJSON{Path: mod.Path, Version: mod.Version, SchemaVersion: jsonSchemaVersion})
+		}
+	}
+	return mods
+}
+
+// parseTargets parses the comma-separated lists of operating systems and
+// architectures given to the -os and -arch flags. An empty list stands for
+// the target of the current build configuration.
+func parseTargets(goos, goarch string) (goosList, goarchList []string, err error) {
+	parse := func(flag, list, def string, known map[string]bool) ([]string, error) {
+		if list == "" {
+			return []string{def}, nil
+		}
+		var values []string
+		for _, v := range strings.Split(list, ",") {
+			if !known[v] {
+				return nil, fmt.Errorf("invalid -%s=%s: unknown %s %q", flag, list, flag, v)
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	if goosList, err = parse("os", goos, cfg.BuildContext.GOOS, imports.KnownOS); err != nil {
+		return nil, nil, err
+	}
+	if goarchList, err = parse("arch", goarch, cfg.BuildContext.GOARCH, imports.KnownArch); err != nil {
+		return nil, nil, err
+	}
+	return goosList, goarchList, nil
+}
+
+// targetModules returns the paths of the modules that provide the packages
+// matching patterns, and the packages they import, for each combination of
+// the given operating systems and architectures.
+func targetModules(patterns, goosList, goarchList []string) map[string]bool {
+	mods := make(map[string]bool)
+	warned := len(patterns) == 1 && patterns[0] == "all"
+	for _, goos := range goosList {
+		for _, goarch := range goarchList {
+			tags := make(map[string]bool)
+			for tag := range imports.Tags() {
+				if !imports.KnownOS[tag] && !imports.KnownArch[tag] {
+					tags[tag] = true
+				}
+			}
+			tags[goos] = true
+			tags[goarch] = true
+			seen := make(map[string]bool)
+			var walk func(pkg string)
+			walk = func(pkg string) {
+				if seen[pkg] {
+					return
+				}
+				seen[pkg] = true
+				if mod := modload.PackageModule(pkg); mod.Path != "" {
+					mods[mod.Path] = true
+				}
+				imps, _ := modload.PackageImports(pkg)
+				for _, imp := range imps {
+					walk(imp)
+				}
+			}
+			matches := modload.ImportPathsQuiet(patterns, tags)
+			if !warned {
+				search.WarnUnmatched(matches)
+				warned = true
+			}
+			for _, m := range matches {
+				for _, pkg := range m.Pkgs {
+					walk(pkg)
+				}
+			}
+		}
+	}
+	return mods
+}
+
+// repoModule returns the module version found at the url@rev
+// named by the -repo flag.
+func repoModule(arg string) (module.Version, error) {
+	i := strings.LastIndex(arg, "@")
+	if i <= 0 || i == len(arg)-1 {
+		return module.Version{}, fmt.Errorf("must be of the form url@rev")
+	}
+	return modfetch.RepoRev(arg[:i], arg[i+1:])
+}
+
+// purgeModules removes the module versions named by args,
+// each of the form path@version, from the module cache.
+func purgeModules(args []string) {
+	for _, arg := range args {
+		i := strings.Index(arg, "@")
+		if i < 0 {
+			base.Errorf("go mod download: -purge %s: missing @version", arg)
+			continue
+		}
+		mod := module.Version{Path: arg[:i], Version: arg[i+1:]}
+		if err := module.Check(mod.Path, mod.Version); err != nil {
+			base.Errorf("go mod download: -purge %v", err)
+			continue
+		}
+		if err := modfetch.PurgeVersion(mod); err != nil {
+			base.Errorf("go mod download: %v", err)
+		}
+	}
+}
+
+// verifyZipFile implements -verify-zip: it checks that file is the zip
+// file for the module version named by args[0].
+func verifyZipFile(file string, args []string) {
+	if len(args) != 1 {
+		base.Fatalf("go mod download: -verify-zip requires a single path@version argument (see 'go help mod download')")
+	}
+	arg := args[0]
+	i := strings.Index(arg, "@")
+	if i < 0 {
+		base.Fatalf("go mod download: -verify-zip %s: missing @version", arg)
+	}
+	mod := module.Version{Path: arg[:i], Version: arg[i+1:]}
+	if err := module.Check(mod.Path, mod.Version); err != nil {
+		base.Fatalf("go mod download: -verify-zip %v", err)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		base.Fatalf("go mod download: invalid -verify-zip: %v", err)
+	}
+
+	// Initialize modload first, since it sets modfetch.GoSumFile.
+	modload.HasModRoot()
+	m := &moduleJSON{Path: mod.Path, Version: mod.Version, Zip: abs, SchemaVersion: jsonSchemaVersion}
+	m.Sum, err = modfetch.LookupSum(mod)
+	if err == nil {
+		err = modfetch.VerifyZip(abs, mod, m.Sum)
+	}
+	if err != nil {
+		setError(m, err)
+	}
+	if downloadJSON != "" {
+		var v interface{} = m
+		if downloadJSON == "array" {
+			v = []*moduleJSON{m}
+		}
+		b, err := marshalJSON(v)
+		if err != nil {
+			base.Fatalf("%v", err)
+		}
+		os.Stdout.Write(append(b, '\n'))
+		if m.Error != "" {
+			base.SetExitStatus(1)
+		}
+		return
+	}
+	if m.Error != "" {
+		base.Fatalf("go mod download: -verify-zip: %s", m.Error)
+	}
+}
+
+// listVersions implements -list: it prints the available versions of each
+// module path in args without downloading them.
+func listVersions(args []string) {
+	type moduleVersions struct {
+		Path     string
+		Versions []string `json:",omitempty"`
+		Error    string   `json:",omitempty"`
+	}
+	var list []*moduleVersions
+	for _, path := range args {
+		if strings.Contains(path, "@") {
+			base.Errorf("go mod download: -list %s: module path must not include a version", path)
+			continue
+		}
+		m := &moduleVersions{Path: path}
+		if err := module.CheckPath(path); err != nil {
+			m.Error = err.Error()
+		} else if m.Versions, err = modfetch.Versions(path); err != nil {
+			m.Error = err.Error()
+		}
+		list = append(list, m)
+	}
+	if downloadJSON == "" {
+		for _, m := range list {
+			if m.Error != "" {
+				base.Errorf("go mod download: %s: %s", m.Path, m.Error)
+				continue
+			}
+			fmt.Println(strings.Join(append([]string{m.Path}, m.Versions...), " "))
+		}
+		base.ExitIfErrors()
+		return
+	}
+	var values []interface{}
+	if downloadJSON == "array" {
+		values = append(values, list)
+	} else {
+		for _, m := range list {
+			values = append(values, m)
+		}
+	}
+	for _, v := range values {
+		b, err := marshalJSON(v)
+		if err != nil {
+			base.Fatalf("%v", err)
+		}
+		os.Stdout.Write(append(b, '\n'))
+	}
+	for _, m := range list {
+		if m.Error != "" {
+			base.SetExitStatus(1)
+		}
+	}
+	base.ExitIfErrors()
+}
+
+// expandArgFiles returns args with each argument of the form @file
+// replaced by the module queries listed in file, one per line.
+// Blank lines and lines beginning with # are ignored.
+func expandArgFiles(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+		data, err := ioutil.ReadFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			expanded = append(expanded, line)
+		}
+	}
+	return expanded, nil
+}
+
+// setResult records in m the outcome r of downloading m.
+func setResult(m *moduleJSON, r modfetch.DownloadResult) {
+	if m.Time == nil {
+		m.Time = r.Time
+	}
+	m.Info = r.Info
+	m.GoMod = r.GoMod
+	m.Zip = r.Zip
+	m.Dir = r.Dir
+	m.UncompressedSize = r.UncompressedSize
+	m.Sum = r.Sum
+	m.GoModSum = r.GoModSum
+	m.GoVersion = r.GoVersion
+	m.Retries = r.Retries
+	m.Verified = r.Verified
+	m.Cached = r.Cached
+	m.Origin = r.Origin
+	if o := r.Origin; o != nil && o.Proxy == "direct" && o.VCS != "mod" {
+		m.VCS = o.VCS
+		m.Revision = o.Revision
+	}
+	m.Insecure = r.Origin.Insecure()
+	if r.Err != nil {
+		setError(m, r.Err)
+	}
+}
+
+// setError records err, which occurred while downloading the module
+// described by m, in m.
+func setError(m *moduleJSON, err error) {
+	m.Error = downloadError(m, err)
+	m.ErrorKind = errorKind(err)
+	var merr *modfetch.MismatchError
+	if errors.As(err, &merr) {
+		m.Mismatch = &mismatchJSON{File: "zip", Got: merr.Got, Want: merr.Want, Source: merr.Source}
+		if strings.HasSuffix(merr.Mod.Version, "/go.mod") {
+			m.Mismatch.File = "go.mod"
+		}
+	}
+	m.Auth = authInfo(err)
+}
+
+// authInfo returns the Auth field describing err,
+// or nil if err is not a *modfetch.AuthError.
+func authInfo(err error) *authJSON {
+	var aerr *modfetch.AuthError
+	if !errors.As(err, &aerr) {
+		return nil
+	}
+	return &authJSON{Host: aerr.Host, Mechanism: aerr.Mechanism}
+}
+
+// checkCaseCollisions reports an error for each module in mods whose path
+// differs only in case from the path of another module in mods.
+func checkCaseCollisions(mods []*moduleJSON) {
+	// The module cache escapes each upper-case letter in a module path as
+	// '!' followed by the lower-case letter, and '!' cannot appear in a
+	// module path, so removing the '!'s from an escaped path folds its case.
+	paths := make(map[string][]string)
+	for _, m := range mods {
+		if m.Error != "" {
+			continue
+		}
+		enc, err := module.EscapePath(m.Path)
+		if err != nil {
+			continue
+		}
+		fold := strings.Replace(enc, "!", "", -1)
+		if !str.Contains(paths[fold], m.Path) {
+			paths[fold] = append(paths[fold], m.Path)
+		}
+	}
+	for _, m := range mods {
+		if m.Error != "" {
+			continue
+		}
+		enc, err := module.EscapePath(m.Path)
+		if err != nil {
+			continue
+		}
+		var others []string
+		for _, p := range paths[strings.Replace(enc, "!", "", -1)] {
+			if p != m.Path {
+				others = append(others, p)
+			}
+		}
+		if len(others) > 0 {
+			setError(m, fmt.Errorf("module path differs only in case from %s", strings.Join(others, ", ")))
+			m.ErrorKind = errorCaseCollision
+		}
+	}
+}
+
+// expandRanges returns args with each module query of the form path@<v,
+// path@<=v, path@>v, or path@>=v replaced by path@version for every
+// version of the module in that range, as listed by modload.QueryRange.
+// Queries that match no versions, or that cannot be resolved, are left in
+// place so that the module loader reports them.
+func expandRanges(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		i := strings.Index(arg, "@")
+		if i < 0 {
+			expanded = append(expanded, arg)
+			continue
+		}
+		path, query := arg[:i], arg[i+1:]
+		op := query[:len(query)-len(strings.TrimLeft(query, "<>=!~^"))]
+		switch op {
+		case "":
+			expanded = append(expanded, arg)
+			continue
+		case "<", "<=", ">", ">=":
+			// ok
+		default:
+			return nil, fmt.Errorf("%s: unsupported version query operator %q (use <, <=, >, or >=)", arg, op)
+		}
+
+		versions, err := modload.QueryRange(path, query, nil)
+		if err != nil {
+			// Leave the query for the module loader to report.
+			expanded = append(expanded, arg)
+			continue
+		}
+		for _, v := range versions {
+			expanded = append(expanded, path+"@"+v)
+		}
+	}
+	return expanded, nil
+}
+
+// indirectFunc returns a function reporting whether the module described by
+// info, as listed for the arguments to download, is an indirect dependency
+// of the main module: one in the build list (or in vendor/modules.txt, with
+// -vendor) that the main module's go.mod file does not require, or requires
+// only with an "// indirect" comment. Without a main module, no module is
+// indirect.
+func indirectFunc() func(info *modinfo.ModulePublic) bool {
+	none := func(*modinfo.ModulePublic) bool { return false }
+	if !modload.HasModRoot() {
+		return none
+	}
+	// With -vendor, the go.mod file has not been loaded, so read it here.
+	file := modload.ModFilePath()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return none
+	}
+	f, err := modfile.ParseLax(file, data, nil)
+	if err != nil {
+		return none
+	}
+	direct := make(map[string]bool)
+	for _, r := range f.Require {
+		if !r.Indirect {
+			direct[r.Mod.Path] = true
+		}
+	}
+	listed := make(map[module.Version]bool)
+	for _, m := range modload.BuildList() {
+		listed[m] = true
+	}
+	return func(info *modinfo.ModulePublic) bool {
+		if info.Main || direct[info.Path] {
+			return false
+		}
+		return *downloadVendor || listed[module.Version{Path: info.Path, Version: info.Version}]
+	}
+}
+
+// downloadError returns the text of err, which occurred while downloading
+// the module described by m, mentioning the module if err does not.
+func downloadError(m *moduleJSON, err error) string {
+	if msg := err.Error(); strings.Contains(msg, m.Path) {
+		return msg
+	}
+	return module.VersionError(module.Version{Path: m.Path, Version: m.Version}, err).Error()
+}
+
+// Values of the ErrorKind field of moduleJSON.
+const (
+	errorNotFound         = "not_found"
+	errorPseudoVersion    = "pseudo_version"
+	errorChecksumMismatch = "checksum_mismatch"
+	errorNetwork          = "network"
+	errorPermission       = "permission"
+	errorInvalidVersion   = "invalid_version"
+	errorSignature        = "signature"
+	errorCaseCollision    = "case_collision"
+	errorAuth             = "auth"
+	errorSizeBudget       = "size_budget"
+)
+
+// errorKind classifies err for the ErrorKind field of moduleJSON.
+// It returns the empty string if err does not fit any of the known kinds.
+func errorKind(err error) string {
+	var ive *module.InvalidVersionError
+	var uerr *url.Error
+	var nerr net.Error
+	var serr *modfetch.SignatureError
+	var aerr *modfetch.AuthError
+	switch {
+	case errors.As(err, &serr):
+		return errorSignature
+	case errors.As(err, &aerr):
+		return errorAuth
+	case errors.Is(err, modfetch.ErrSizeBudget):
+		return errorSizeBudget
+	case errors.Is(err, modfetch.ErrChecksumMismatch):
+		return errorChecksumMismatch
+	case errors.As(err, &ive):
+		return errorInvalidVersion
+	case errors.Is(err, os.ErrNotExist):
+		return errorNotFound
+	case errors.Is(err, os.ErrPermission):
+		return errorPermission
+	case modfetch.IsTransient(err), errors.As(err, &uerr), errors.As(err, &nerr):
+		return errorNetwork
+	}
+	return ""
+}
+
+// summarize returns a summary of the results recorded in mods.
+func summarize(mods []*moduleJSON, elapsed time.Duration) *downloadSummary {
+	s := &downloadSummary{Elapsed: elapsed.Seconds()}
+	for _, m := range mods {
+		switch {
+		case m.Error != "":
+			s.Failed++
+		case m.Cached:
+			s.Cached++
+		default:
+			s.Downloaded++
+			if fi, err := os.Stat(m.Zip); err == nil {
+				s.Bytes += fi.Size()
+			}
+		}
+	}
+	return s
+}
+
+// readReuse reads the results of a previous 'go mod download -json'
+// from file.
+func readReuse(file string) (map[module.Version]*moduleJSON, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var values []json.RawMessage
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		// Written by -json=array.
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", file, err)
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var v json.RawMessage
+			if err := dec.Decode(&v); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("reading %s: %v", file, err)
+			}
+			values = append(values, v)
+		}
+	}
+	reuse := make(map[module.Version]*moduleJSON)
+	for _, v := range values {
+		var id struct{ Path, Version string }
+		if err := json.Unmarshal(v, &id); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", file, err)
+		}
+		if id.Path == "" || id.Version == "" {
+			// Not a module; perhaps a -stats summary.
+			continue
+		}
+		m := new(moduleJSON)
+		if err := json.Unmarshal(v, m); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", file, err)
+		}
+		reuse[module.Version{Path: m.Path, Version: m.Version}] = m
+	}
+	return reuse, nil
+}
+
+// reusable reports whether r, the result of a previous download,
+// may be reported instead of downloading the module again.
+func reusable(r *moduleJSON) bool {
+	if r.Error != "" || r.Info == "" || r.GoMod == "" || r.GoModSum == "" {
+		return false
+	}
+	if !*downloadModOnly && (r.Zip == "" || r.Sum == "") {
+		return false
+	}
+	if !*downloadModOnly && !*downloadNoExtract && r.Dir == "" {
+		return false
+	}
+	for _, file := range []string{r.Info, r.GoMod, r.Zip, r.Dir} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return false
+		}
+	}
+
+	// The checksums must agree with those that a new download would report.
+	mod := module.Version{Path: r.Path, Version: r.Version}
+	if r.Sum != "" && r.Sum != modfetch.Sum(mod) {
+		return false
+	}
+	if modfetch.GoSumFile != "" && !modfetch.HaveSum(module.Version{Path: r.Path, Version: r.Version + "/go.mod"}, r.GoModSum) {
+		return false
+	}
+	return true
+}
+
+// isTerminal reports whether f appears to be a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// A progressBar displays a one-line summary of the module zip files
+// being downloaded, redrawing it in place as progress is reported.
+type progressBar struct {
+	mu     sync.Mutex
+	active map[module.Version]modfetch.ProgressEvent
+	done   int       // number of completed downloads
+	bytes  int64     // bytes received by completed downloads
+	drawn  time.Time // time the line was last drawn
+	width  int       // width of the line last drawn
+}
+
+func newProgressBar() *progressBar {
+	return &progressBar{active: make(map[module.Version]modfetch.ProgressEvent)}
+}
+
+// update records ev and redraws the progress line.
+// It is safe to call from multiple goroutines.
+func (p *progressBar) update(ev modfetch.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch ev.Kind {
+	case modfetch.ProgressStart:
+		p.active[ev.Mod] = ev
+	case modfetch.ProgressBytes:
+		p.active[ev.Mod] = ev
+		if time.Since(p.drawn) < 100*time.Millisecond {
+			return
+		}
+	case modfetch.ProgressDone:
+		delete(p.active, ev.Mod)
+		p.done++
+		p.bytes += ev.Bytes
+	}
+
+	received, total := p.bytes, p.bytes
+	for _, ev := range p.active {
+		received += ev.Bytes
+		if total >= 0 && ev.Total >= 0 {
+			total += ev.Total
+		} else {
+			total = -1
+		}
+	}
+	line := fmt.Sprintf("go: downloading %d modules (%d done): %s", len(p.active), p.done, formatSize(received))
+	if total > 0 && len(p.active) > 0 {
+		line += " of " + formatSize(total)
+	}
+	fmt.Fprintf(os.Stderr, "\r%-*s", p.width, line)
+	p.drawn = time.Now()
+	p.width = len(line)
+}
+
+// clear erases the progress line, if any.
+func (p *progressBar) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.width > 0 {
+		fmt.Fprintf(os.Stderr, "\r%*s\r", p.width, "")
+		p.width = 0
+	}
+}
+
+// formatSize formats a number of bytes for display.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f kB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// outputDir returns the directory holding the files for the module
+// with the given path within the proxy directory dir.
+func outputDir(dir, path string) (string, error) {
+	enc, err := module.EscapePath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, enc, "@v"), nil
+}
+
+// outputPath returns the path of the file with the given suffix
+// for mod within the proxy directory dir.
+func outputPath(dir string, mod module.Version, suffix string) (string, error) {
+	vdir, err := outputDir(dir, mod.Path)
+	if err != nil {
+		return "", err
+	}
+	encVer, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(vdir, encVer+"."+suffix), nil
+}
+
+// execOutputMu serializes the printing of the output of -exec commands.
+var execOutputMu sync.Mutex
+
+// runExec runs the -exec command cmdline for the module fetched in r.
+func runExec(cmdline []string, r *modfetch.DownloadResult) error {
+	args := append(cmdline[1:len(cmdline):len(cmdline)], r.Mod.Path, r.Mod.Version, r.Zip)
+	if cfg.BuildX {
+		fmt.Fprintf(os.Stderr, "%s %s\n", cmdline[0], strings.Join(args, " "))
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(cmdline[0], args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Env = cfg.OrigEnv
+	err := cmd.Run()
+	if out.Len() > 0 {
+		execOutputMu.Lock()
+		os.Stderr.Write(out.Bytes())
+		execOutputMu.Unlock()
+	}
+	if err != nil {
+		return fmt.Errorf("-exec %s: %v", cmdline[0], err)
+	}
+	return nil
+}
+
+// copyToOutput copies the .info, .mod, and .zip files for r
+// into the proxy directory dir and updates r to refer to the copies.
+func copyToOutput(dir string, r *modfetch.DownloadResult) error {
+	mod := r.Mod
+	files := []struct {
+		file   *string
+		suffix string
+		sum    string
+	}{
+		{&r.Info, "info", ""},
+		{&r.GoMod, "mod", r.GoModSum},
+		{&r.Zip, "zip", r.Sum},
+	}
+	for _, f := range files {
+		if *f.file == "" {
+			continue
+		}
+		dst, err := outputPath(dir, mod, f.suffix)
+		if err != nil {
+			return err
+		}
+		if *downloadDedup && f.sum != "" {
+			err = linkOrCopyFile(dst, *f.file, f.suffix+" "+f.sum)
+		} else {
+			err = copyFile(dst, *f.file)
+		}
+		if err != nil {
+			return err
+		}
+		*f.file = dst
+	}
+	return nil
+}
+
+// outputFiles records, for -dedup, the first file copied into the -output
+// directory with each checksum.
+var outputFiles struct {
+	sync.Mutex
+	m map[string]string // file kind and checksum → copied file
+}
+
+// linkOrCopyFile replaces dst with a hard link to a file already copied
+// into the -output directory with the same key (kind and checksum),
+// provided the two files are identical. Otherwise, it copies src to dst.
+func linkOrCopyFile(dst, src, key string) error {
+	outputFiles.Lock()
+	prev, ok := outputFiles.m[key]
+	outputFiles.Unlock()
+	if ok && prev != dst {
+		if same, err := modfetch.SameContents(prev, src); err == nil && same {
+			if err := linkFile(dst, prev); err == nil {
+				return nil
+			}
+			// Fall back to copying, for example if the file system
+			// does not support hard links.
+		}
+	}
+
+	if err := copyFile(dst, src); err != nil {
+		return err
+	}
+	outputFiles.Lock()
+	if outputFiles.m == nil {
+		outputFiles.m = make(map[string]string)
+	}
+	if _, ok := outputFiles.m[key]; !ok {
+		outputFiles.m[key] = dst
+	}
+	outputFiles.Unlock()
+	return nil
+}
+
+// linkFile atomically replaces dst with a hard link to target.
+func linkFile(dst, target string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(renameio.Pattern(dst)))
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	os.Remove(tmp)
+	if err := os.Link(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// copyFile atomically replaces the contents of dst with those of src.
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return err
+	}
+	return renameio.WriteToFile(dst, r, 0666)
+}
+
+// writeOutputLists adds the versions of the successfully downloaded
+// modules in mods to the version lists in the proxy directory dir.
+func writeOutputLists(dir string, mods []*moduleJSON) error {
+	versions := make(map[string][]string)
+	for _, m := range mods {
+		if m.Error == "" {
+			versions[m.Path] = append(versions[m.Path], m.Version)
+		}
+	}
+	for path, list := range versions {
+		vdir, err := outputDir(dir, path)
+		if err != nil {
+			return err
+		}
+		listFile := filepath.Join(vdir, "list")
+
+		have := make(map[string]bool)
+		if old, err := renameio.ReadFile(listFile); err == nil {
+			list = append(list, strings.Fields(string(old))...)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		var buf bytes.Buffer
+		modfetch.SortVersions(list)
+		for _, v := range list {
+			if !have[v] {
+				have[v] = true
+				buf.WriteString(v)
+				buf.WriteString("\n")
+			}
+		}
+		if err := renameio.WriteFile(listFile, buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An overlayManifest is the file written by -overlay-manifest.
+// Its format is documented in 'go help mod download' and must not change
+// incompatibly.
+type overlayManifest struct {
+	Modules []overlayModule
+}
+
+type overlayModule struct {
+	Path    string
+	Version string
+	Dir     string
+	Sum     string
+	Files   []overlayFile
+}
+
+type overlayFile struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// writeOverlayManifest writes to file a manifest of the extracted files of
+// the successfully downloaded modules in mods.
+func writeOverlayManifest(file string, mods []*moduleJSON) error {
+	manifest := overlayManifest{Modules: []overlayModule{}}
+	for _, m := range mods {
+		if m.Error != "" || m.Dir == "" {
+			continue
+		}
+		om := overlayModule{
+			Path:    m.Path,
+			Version: m.Version,
+			Dir:     m.Dir,
+			Sum:     m.Sum,
+			Files:   []overlayFile{},
+		}
+		names, err := dirhash.DirFiles(m.Dir, "")
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f, err := hashFile(filepath.Join(m.Dir, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+			f.Name = name
+			om.Files = append(om.Files, f)
+		}
+		manifest.Modules = append(manifest.Modules, om)
+	}
+	sort.Slice(manifest.Modules, func(i, j int) bool {
+		mi, mj := manifest.Modules[i], manifest.Modules[j]
+		if mi.Path != mj.Path {
+			return mi.Path < mj.Path
+		}
+		return semver.Compare(mi.Version, mj.Version) < 0
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(file, append(data, '\n'), 0666)
+}
+
+// writeDigest writes the -digest file for mods.
+func writeDigest(file string, mods []*moduleJSON) error {
+	var list []module.Version
+	sums := make(map[module.Version]string)
+	add := func(mod module.Version, sum string) {
+		if sum == "" {
+			return
+		}
+		if _, ok := sums[mod]; !ok {
+			list = append(list, mod)
+		}
+		sums[mod] = sum
+	}
+	for _, m := range mods {
+		if m.Error != "" {
+			return fmt.Errorf("not written: %s@%s failed", m.Path, m.Version)
+		}
+		add(module.Version{Path: m.Path, Version: m.Version}, m.Sum)
+		add(module.Version{Path: m.Path, Version: m.Version + "/go.mod"}, m.GoModSum)
+	}
+	module.Sort(list)
+
+	var buf bytes.Buffer
+	for _, mod := range list {
+		fmt.Fprintf(&buf, "%s %s %s\n", mod.Path, mod.Version, sums[mod])
+	}
+	h := sha256.Sum256(buf.Bytes())
+	fmt.Fprintf(&buf, "sha256 %x\n", h)
+	return renameio.WriteFile(file, buf.Bytes(), 0666)
+}
+
+// A snapshot is the file written by -snapshot and read by -from-snapshot.
+// Its format is documented in 'go help mod download' and must not change
+// incompatibly.
+type snapshot struct {
+	Modules []snapshotModule
+}
+
+type snapshotModule struct {
+	Path     string
+	Version  string
+	Sum      string
+	GoModSum string
+}
+
+// writeSnapshot writes the -snapshot file for mods.
+func writeSnapshot(file string, mods []*moduleJSON) error {
+	snap := snapshot{Modules: []snapshotModule{}}
+	for _, m := range mods {
+		if m.Error != "" {
+			return fmt.Errorf("not written: %s@%s failed", m.Path, m.Version)
+		}
+		snap.Modules = append(snap.Modules, snapshotModule{
+			Path:     m.Path,
+			Version:  m.Version,
+			Sum:      m.Sum,
+			GoModSum: m.GoModSum,
+		})
+	}
+	sort.Slice(snap.Modules, func(i, j int) bool {
+		mi, mj := snap.Modules[i], snap.Modules[j]
+		if mi.Path != mj.Path {
+			return mi.Path < mj.Path
+		}
+		return semver.Compare(mi.Version, mj.Version) < 0
+	})
+
+	data, err := json.MarshalIndent(snap, "", "\t")
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(file, append(data, '\n'), 0666)
+}
+
+// readSnapshot reads the -from-snapshot file, returning the modules to
+// download, in the order listed, and the checksums recorded for each.
+func readSnapshot(file string) ([]*modinfo.ModulePublic, map[module.Version]snapshotModule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %v", file, err)
+	}
+	var infos []*modinfo.ModulePublic
+	pinned := make(map[module.Version]snapshotModule)
+	for _, sm := range snap.Modules {
+		if err := module.Check(sm.Path, sm.Version); err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %v", file, err)
+		}
+		mod := module.Version{Path: sm.Path, Version: sm.Version}
+		if _, ok := pinned[mod]; ok {
+			continue
+		}
+		pinned[mod] = sm
+		infos = append(infos, &modinfo.ModulePublic{Path: sm.Path, Version: sm.Version})
+	}
+	return infos, pinned, nil
+}
+
+// checkSnapshot reports a checksum mismatch for m if its checksums differ
+// from those recorded for it in a snapshot, either the -from-snapshot file
+// or the -baseline file, as named by source.
+func checkSnapshot(m *moduleJSON, sm snapshotModule, source string) {
+	mod := module.Version{Path: m.Path, Version: m.Version}
+	check := func(mod module.Version, got, want string) bool {
+		if want == "" || got == "" || got == want {
+			return true
+		}
+		err := &modfetch.MismatchError{Mod: mod, Got: got, Want: want, Source: source}
+		setError(m, module.VersionError(mod, fmt.Errorf("%w\n\tdownloaded: %v\n\t%-11s %v"+snapshotMismatch, err, got, source+":", want)))
+		return false
+	}
+	if check(module.Version{Path: mod.Path, Version: mod.Version + "/go.mod"}, m.GoModSum, sm.GoModSum) {
+		check(mod, m.Sum, sm.Sum)
+	}
+}
+
+const snapshotMismatch = `
+
+SECURITY ERROR
+This download does NOT match an earlier download recorded in a snapshot.
+The bits may have been replaced on the origin server, or an attacker may
+have intercepted the download attempt.
+
+For more information, see 'go help module-auth'.`
+
+// hashFile returns the size and SHA-256 hash of the named file.
+func hashFile(name string) (overlayFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return overlayFile{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return overlayFile{}, err
 	}
+	return overlayFile{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
 }
@@ -6,6 +6,8 @@ package modfetch
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"cmd/go/internal/base"
 	"cmd/go/internal/cfg"
@@ -28,19 +31,73 @@ import (
 
 var PkgMod string // $GOPATH/pkg/mod; set by package modload
 
+// shardDir is the directory in the download cache that holds the sharded
+// layout enabled by GOMODCACHESHARD=on. Module paths cannot contain '@',
+// so it cannot be mistaken for the cache directory of a module.
+const shardDir = "@shard"
+
+// shardCache reports whether new download cache files are written
+// in the sharded layout.
+func shardCache() bool {
+	switch cfg.GOMODCACHESHARD {
+	case "", "off":
+		return false
+	case "on":
+		return true
+	}
+	base.Fatalf("go: invalid GOMODCACHESHARD value %q: must be on or off", cfg.GOMODCACHESHARD)
+	return false
+}
+
 func cacheDir(path string) (string, error) {
+	dirs, err := cacheDirs(path)
+	if err != nil {
+		return "", err
+	}
+	return dirs[0], nil
+}
+
+// cacheDirs returns the download cache directories that may hold files
+// for the module path, in the order they are searched.
+// New files are written to the first one.
+//
+// In the sharded layout, a module's files are kept in one of 256
+// subdirectories of the shard directory, chosen by the first byte of the
+// SHA-256 hash of the module path, so that no directory lists every
+// module on a host such as github.com. Files written before the sharded
+// layout was enabled stay where they are and are still read.
+func cacheDirs(path string) ([]string, error) {
 	if PkgMod == "" {
-		return "", fmt.Errorf("internal error: modfetch.PkgMod not set")
+		return nil, fmt.Errorf("internal error: modfetch.PkgMod not set")
 	}
 	enc, err := module.EscapePath(path)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return filepath.Join(PkgMod, "cache/download", enc, "/@v"), nil
+	legacy := filepath.Join(PkgMod, "cache/download", enc, "/@v")
+	if !shardCache() {
+		return []string{legacy}, nil
+	}
+	h := sha256.Sum256([]byte(path))
+	sharded := filepath.Join(PkgMod, "cache/download", shardDir, fmt.Sprintf("%02x", h[0]), enc, "@v")
+	return []string{sharded, legacy}, nil
 }
 
+// unshardName returns the slash-separated name, relative to the download
+// cache, that a file in the sharded layout would have in the unsharded one.
+func unshardName(name string) string {
+	if elem := strings.SplitN(name, "/", 3); len(elem) == 3 && elem[0] == shardDir {
+		return elem[2]
+	}
+	return name
+}
+
+// CachePath returns the name of the download cache file holding the
+// given suffix (such as "info", "mod", or "zip") for m. In the sharded
+// layout, that is the existing unsharded file if there is one and no
+// sharded file, and otherwise the sharded file.
 func CachePath(m module.Version, suffix string) (string, error) {
-	dir, err := cacheDir(m.Path)
+	dirs, err := cacheDirs(m.Path)
 	if err != nil {
 		return "", err
 	}
@@ -54,7 +111,19 @@ func CachePath(m module.Version, suffix string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(dir, encVer+"."+suffix), nil
+	name := encVer + "." + suffix
+	file := filepath.Join(dirs[0], name)
+	if len(dirs) > 1 && !fileExists(file) {
+		if legacy := filepath.Join(dirs[1], name); fileExists(legacy) {
+			return legacy, nil
+		}
+	}
+	return file, nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
 }
 
 // DownloadDir returns the directory to which m should have been downloaded.
@@ -115,15 +184,16 @@ func (e *DownloadDirPartialError) Is(err error) bool { return err == os.ErrNotEx
 
 // lockVersion locks a file within the module cache that guards the downloading
 // and extraction of the zipfile for the given module version.
-func lockVersion(mod module.Version) (unlock func(), err error) {
+func lockVersion(ctx context.Context, mod module.Version) (unlock func(), err error) {
 	path, err := CachePath(mod, "lock")
 	if err != nil {
 		return nil, err
 	}
-	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+	mode := options(ctx).CacheMode
+	if err := mkdirAll(filepath.Dir(path), mode); err != nil {
 		return nil, err
 	}
-	return lockedfile.MutexAt(path).Lock()
+	return lockCacheFile(path, mode)
 }
 
 // SideLock locks a file within the module cache that that previously guarded
@@ -136,11 +206,24 @@ func SideLock() (unlock func(), err error) {
 	}
 
 	path := filepath.Join(PkgMod, "cache", "lock")
-	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+	mode := options(context.Background()).CacheMode
+	if err := mkdirAll(filepath.Dir(path), mode); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	return lockedfile.MutexAt(path).Lock()
+	return lockCacheFile(path, mode)
+}
+
+// lockCacheFile locks the lock file at path, creating it if needed
+// with the permissions in mode (see setCacheMode).
+func lockCacheFile(path string, mode os.FileMode) (unlock func(), err error) {
+	unlock, err = lockedfile.MutexAt(path).Lock()
+	if err == nil {
+		// The file may have been created by another user, who alone can
+		// change its mode, so this is only a best-effort attempt.
+		setCacheMode(path, mode)
+	}
+	return unlock, err
 }
 
 // A cachingRepo is a cache around an underlying Repo,
@@ -187,13 +270,26 @@ type cachedInfo struct {
 }
 
 func (r *cachingRepo) Stat(rev string) (*RevInfo, error) {
+	return r.statContext(context.Background(), rev)
+}
+
+// statContext is like Stat, but the underlying Repo's request is canceled
+// if ctx is done. Because concurrent callers share the first caller's
+// request, a canceled request is not cached.
+func (r *cachingRepo) statContext(ctx context.Context, rev string) (*RevInfo, error) {
 	c := r.cache.Do("stat:"+rev, func() interface{} {
-		file, info, err := readDiskStat(r.path, rev)
+		file, info, err := readDiskStat(ctx, r.path, rev)
 		if err == nil {
 			return cachedInfo{info, nil}
 		}
 
-		info, err = r.r.Stat(rev)
+		start := time.Now()
+		info, err = statContext(ctx, r.r, rev)
+		fetched := module.Version{Path: r.path, Version: rev}
+		if err == nil {
+			fetched.Version = info.Version
+		}
+		observeFetch(FetchInfo, fetched, 0, start, err)
 		if err == nil {
 			// If we resolved, say, 1234abcde to v0.0.0-20180604122334-1234abcdef78,
 			// then save the information under the proper version, for future use.
@@ -204,7 +300,7 @@ func (r *cachingRepo) Stat(rev string) (*RevInfo, error) {
 				})
 			}
 
-			if err := writeDiskStat(file, info); err != nil {
+			if err := writeDiskStat(ctx, file, info); err != nil {
 				fmt.Fprintf(os.Stderr, "go: writing stat cache: %v\n", err)
 			}
 		}
@@ -212,6 +308,10 @@ func (r *cachingRepo) Stat(rev string) (*RevInfo, error) {
 	}).(cachedInfo)
 
 	if c.err != nil {
+		if IsTransient(c.err) || isContextErr(c.err) {
+			// Allow a later call to try again.
+			r.cache.Delete("stat:" + rev)
+		}
 		return nil, c.err
 	}
 	info := *c.info
@@ -219,6 +319,7 @@ func (r *cachingRepo) Stat(rev string) (*RevInfo, error) {
 }
 
 func (r *cachingRepo) Latest() (*RevInfo, error) {
+	ctx := context.Background()
 	c := r.cache.Do("latest:", func() interface{} {
 		info, err := r.r.Latest()
 
@@ -227,8 +328,8 @@ func (r *cachingRepo) Latest() (*RevInfo, error) {
 			r.cache.Do("stat:"+info.Version, func() interface{} {
 				return cachedInfo{info, err}
 			})
-			if file, _, err := readDiskStat(r.path, info.Version); err != nil {
-				writeDiskStat(file, info)
+			if file, _, err := readDiskStat(ctx, r.path, info.Version); err != nil {
+				writeDiskStat(ctx, file, info)
 			}
 		}
 
@@ -243,23 +344,31 @@ func (r *cachingRepo) Latest() (*RevInfo, error) {
 }
 
 func (r *cachingRepo) GoMod(version string) ([]byte, error) {
+	return r.goModContext(context.Background(), version)
+}
+
+// goModContext is like GoMod, but the underlying Repo's request is canceled
+// if ctx is done, as with statContext.
+func (r *cachingRepo) goModContext(ctx context.Context, version string) ([]byte, error) {
 	type cached struct {
 		text []byte
 		err  error
 	}
 	c := r.cache.Do("gomod:"+version, func() interface{} {
-		file, text, err := readDiskGoMod(r.path, version)
+		file, text, err := readDiskGoMod(ctx, r.path, version)
 		if err == nil {
 			// Note: readDiskGoMod already called checkGoMod.
 			return cached{text, nil}
 		}
 
-		text, err = r.r.GoMod(version)
+		start := time.Now()
+		text, err = goModContext(ctx, r.r, version)
+		observeFetch(FetchGoMod, module.Version{Path: r.path, Version: version}, int64(len(text)), start, err)
 		if err == nil {
-			if err := checkGoMod(r.path, version, text); err != nil {
+			if err := checkGoMod(ctx, r.path, version, text); err != nil {
 				return cached{text, err}
 			}
-			if err := writeDiskGoMod(file, text); err != nil {
+			if err := writeDiskGoMod(ctx, file, text); err != nil {
 				fmt.Fprintf(os.Stderr, "go: writing go.mod cache: %v\n", err)
 			}
 		}
@@ -267,20 +376,35 @@ func (r *cachingRepo) GoMod(version string) ([]byte, error) {
 	}).(cached)
 
 	if c.err != nil {
+		if IsTransient(c.err) || isContextErr(c.err) {
+			// Allow a later call to try again.
+			r.cache.Delete("gomod:" + version)
+		}
 		return nil, c.err
 	}
 	return append([]byte(nil), c.text...), nil
 }
 
-func (r *cachingRepo) Zip(dst io.Writer, version string) error {
-	return r.r.Zip(dst, version)
+func (r *cachingRepo) signature(version string) ([]byte, error) {
+	if s, ok := r.r.(signer); ok {
+		return s.signature(version)
+	}
+	return nil, errNoSignature
+}
+
+func (r *cachingRepo) resumeZip(ctx context.Context, dst io.Writer, version string, offset int64) (bool, error) {
+	return resumeZip(ctx, r.r, dst, version, offset)
+}
+
+func (r *cachingRepo) Zip(ctx context.Context, dst io.Writer, version string) error {
+	return r.r.Zip(ctx, dst, version)
 }
 
 // Stat is like Lookup(path).Stat(rev) but avoids the
 // repository path resolution in Lookup if the result is
 // already cached on local disk.
 func Stat(proxy, path, rev string) (*RevInfo, error) {
-	_, info, err := readDiskStat(path, rev)
+	_, info, err := readDiskStat(context.Background(), path, rev)
 	if err == nil {
 		return info, nil
 	}
@@ -293,19 +417,28 @@ func Stat(proxy, path, rev string) (*RevInfo, error) {
 
 // InfoFile is like Stat but returns the name of the file containing
 // the cached information.
+//
+// Deprecated: Use InfoFileWithContext instead.
 func InfoFile(path, version string) (string, error) {
+	return InfoFileWithContext(context.Background(), path, version)
+}
+
+// InfoFileWithContext is like InfoFile, but if the file must be fetched
+// from a module proxy and ctx is done before it has been, the request is
+// canceled and InfoFileWithContext returns an error that wraps ctx.Err().
+func InfoFileWithContext(ctx context.Context, path, version string) (string, error) {
 	if !semver.IsValid(version) {
 		return "", fmt.Errorf("invalid version %q", version)
 	}
 
-	if file, _, err := readDiskStat(path, version); err == nil {
+	if file, _, err := readDiskStat(ctx, path, version); err == nil {
 		return file, nil
 	}
 
 	err := TryProxies(func(proxy string) error {
-		repo, err := Lookup(proxy, path)
+		repo, err := lookupContext(ctx, proxy, path)
 		if err == nil {
-			_, err = repo.Stat(version)
+			_, err = statContext(ctx, repo, version)
 		}
 		return err
 	})
@@ -314,29 +447,65 @@ func InfoFile(path, version string) (string, error) {
 	}
 
 	// Stat should have populated the disk cache for us.
-	file, _, err := readDiskStat(path, version)
+	file, _, err := readDiskStat(ctx, path, version)
 	if err != nil {
 		return "", err
 	}
 	return file, nil
 }
 
+// DownloadInfo downloads the .info file for the specific module version,
+// if it is not already in the module cache, and returns its parsed
+// contents. If this process fetched any of the module's files, the
+// Origin field of the result reports where they came from, as FetchOrigin
+// does.
+func DownloadInfo(mod module.Version) (*RevInfo, error) {
+	_, info, err := downloadInfo(context.Background(), mod)
+	return info, err
+}
+
+// downloadInfo is like DownloadInfo but also returns the name of the
+// .info file. If the file must be fetched, the request is canceled if
+// ctx is done.
+func downloadInfo(ctx context.Context, mod module.Version) (string, *RevInfo, error) {
+	file, err := InfoFileWithContext(ctx, mod.Path, mod.Version)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", nil, module.VersionError(mod, err)
+	}
+	info := new(RevInfo)
+	if err := json.Unmarshal(data, info); err != nil {
+		return "", nil, module.VersionError(mod, fmt.Errorf("parsing %s: %v", file, err))
+	}
+	info.Origin = FetchOrigin(mod)
+	return file, info, nil
+}
+
 // GoMod is like Lookup(path).GoMod(rev) but avoids the
 // repository path resolution in Lookup if the result is
 // already cached on local disk.
 func GoMod(path, rev string) ([]byte, error) {
+	return goMod(context.Background(), path, rev)
+}
+
+// goMod is like GoMod, but if the go.mod file must be fetched,
+// the requests are canceled if ctx is done.
+func goMod(ctx context.Context, path, rev string) ([]byte, error) {
 	// Convert commit hash to pseudo-version
 	// to increase cache hit rate.
 	if !semver.IsValid(rev) {
-		if _, info, err := readDiskStat(path, rev); err == nil {
+		if _, info, err := readDiskStat(ctx, path, rev); err == nil {
 			rev = info.Version
 		} else {
 			err := TryProxies(func(proxy string) error {
-				repo, err := Lookup(proxy, path)
+				repo, err := lookupContext(ctx, proxy, path)
 				if err != nil {
 					return err
 				}
-				info, err := repo.Stat(rev)
+				info, err := statContext(ctx, repo, rev)
 				if err == nil {
 					rev = info.Version
 				}
@@ -348,15 +517,18 @@ func GoMod(path, rev string) ([]byte, error) {
 		}
 	}
 
-	_, data, err := readDiskGoMod(path, rev)
+	_, data, err := readDiskGoMod(ctx, path, rev)
 	if err == nil {
 		return data, nil
 	}
 
 	err = TryProxies(func(proxy string) error {
-		repo, err := Lookup(proxy, path)
+		repo, err := lookupContext(ctx, proxy, path)
+		if err == nil {
+			data, err = goModContext(ctx, repo, rev)
+		}
 		if err == nil {
-			data, err = repo.GoMod(rev)
+			recordOrigin(module.Version{Path: path, Version: rev}, proxy)
 		}
 		return err
 	})
@@ -366,20 +538,62 @@ func GoMod(path, rev string) ([]byte, error) {
 // GoModFile is like GoMod but returns the name of the file containing
 // the cached information.
 func GoModFile(path, version string) (string, error) {
+	return goModFile(context.Background(), path, version)
+}
+
+// goModFile is like GoModFile, but if the go.mod file must be fetched,
+// the requests are canceled if ctx is done.
+func goModFile(ctx context.Context, path, version string) (string, error) {
 	if !semver.IsValid(version) {
 		return "", fmt.Errorf("invalid version %q", version)
 	}
-	if _, err := GoMod(path, version); err != nil {
+	if _, err := goMod(ctx, path, version); err != nil {
 		return "", err
 	}
 	// GoMod should have populated the disk cache for us.
-	file, _, err := readDiskGoMod(path, version)
+	file, _, err := readDiskGoMod(ctx, path, version)
 	if err != nil {
 		return "", err
 	}
 	return file, nil
 }
 
+// GoModFileBytes is like GoMod but, if the go.mod file for the module
+// version is not already in the module cache, it fetches the file without
+// adding it to the cache. The contents are still checked against go.sum and
+// the checksum database, as with GoMod.
+func GoModFileBytes(path, version string) ([]byte, error) {
+	if !semver.IsValid(version) {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+	ctx := context.Background()
+	if _, data, err := readDiskGoMod(ctx, path, version); err == nil {
+		return data, nil
+	}
+
+	var data []byte
+	err := TryProxies(func(proxy string) error {
+		repo, err := Lookup(proxy, path)
+		if err != nil {
+			return err
+		}
+		if c, ok := repo.(*cachingRepo); ok {
+			// Bypass the cachingRepo, which would write the file to disk.
+			repo = c.r
+		}
+		data, err = repo.GoMod(version)
+		if err != nil {
+			return err
+		}
+		if err := checkGoMod(ctx, path, version, data); err != nil {
+			return err
+		}
+		recordOrigin(module.Version{Path: path, Version: version}, proxy)
+		return nil
+	})
+	return data, err
+}
+
 // GoModSum returns the go.sum entry for the module version's go.mod file.
 // (That is, it returns the entry listed in go.sum as "path version/go.mod".)
 func GoModSum(path, version string) (string, error) {
@@ -402,9 +616,9 @@ var errNotCached = fmt.Errorf("not in cache")
 // readDiskStat reads a cached stat result from disk,
 // returning the name of the cache file and the result.
 // If the read fails, the caller can use
-// writeDiskStat(file, info) to write a new cache entry.
-func readDiskStat(path, rev string) (file string, info *RevInfo, err error) {
-	file, data, err := readDiskCache(path, rev, "info")
+// writeDiskStat(ctx, file, info) to write a new cache entry.
+func readDiskStat(ctx context.Context, path, rev string) (file string, info *RevInfo, err error) {
+	file, data, err := readDiskCache(ctx, path, rev, "info")
 	if err != nil {
 		// If the cache already contains a pseudo-version with the given hash, we
 		// would previously return that pseudo-version without checking upstream.
@@ -426,7 +640,7 @@ func readDiskStat(path, rev string) (file string, info *RevInfo, err error) {
 		// Fall back to this resolution scheme only if the GOPROXY setting prohibits
 		// us from resolving upstream tags.
 		if cfg.GOPROXY == "off" {
-			if file, info, err := readDiskStatByHash(path, rev); err == nil {
+			if file, info, err := readDiskStatByHash(ctx, path, rev); err == nil {
 				return file, info, nil
 			}
 		}
@@ -441,7 +655,7 @@ func readDiskStat(path, rev string) (file string, info *RevInfo, err error) {
 	// Remarshal and update the cache file if needed.
 	data2, err := json.Marshal(info)
 	if err == nil && !bytes.Equal(data2, data) {
-		writeDiskCache(file, data)
+		writeDiskCache(ctx, file, data)
 	}
 	return file, info, nil
 }
@@ -455,7 +669,7 @@ func readDiskStat(path, rev string) (file string, info *RevInfo, err error) {
 // Without this check we'd be doing network I/O to the remote repo
 // just to find out about a commit we already know about
 // (and have cached under its pseudo-version).
-func readDiskStatByHash(path, rev string) (file string, info *RevInfo, err error) {
+func readDiskStatByHash(ctx context.Context, path, rev string) (file string, info *RevInfo, err error) {
 	if PkgMod == "" {
 		// Do not download to current directory.
 		return "", nil, errNotCached
@@ -465,18 +679,22 @@ func readDiskStatByHash(path, rev string) (file string, info *RevInfo, err error
 		return "", nil, errNotCached
 	}
 	rev = rev[:12]
-	cdir, err := cacheDir(path)
+	cdirs, err := cacheDirs(path)
 	if err != nil {
 		return "", nil, errNotCached
 	}
-	dir, err := os.Open(cdir)
-	if err != nil {
-		return "", nil, errNotCached
-	}
-	names, err := dir.Readdirnames(-1)
-	dir.Close()
-	if err != nil {
-		return "", nil, errNotCached
+	var names []string
+	for _, cdir := range cdirs {
+		dir, err := os.Open(cdir)
+		if err != nil {
+			continue
+		}
+		dirNames, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return "", nil, errNotCached
+		}
+		names = append(names, dirNames...)
 	}
 
 	// A given commit hash may map to more than one pseudo-version,
@@ -490,7 +708,7 @@ func readDiskStatByHash(path, rev string) (file string, info *RevInfo, err error
 			v := strings.TrimSuffix(name, ".info")
 			if IsPseudoVersion(v) && semver.Max(maxVersion, v) == v {
 				maxVersion = v
-				file, info, err = readDiskStat(path, strings.TrimSuffix(name, ".info"))
+				file, info, err = readDiskStat(ctx, path, strings.TrimSuffix(name, ".info"))
 			}
 		}
 	}
@@ -507,9 +725,10 @@ var oldVgoPrefix = []byte("//vgo 0.0.")
 // readDiskGoMod reads a cached go.mod file from disk,
 // returning the name of the cache file and the result.
 // If the read fails, the caller can use
-// writeDiskGoMod(file, data) to write a new cache entry.
-func readDiskGoMod(path, rev string) (file string, data []byte, err error) {
-	file, data, err = readDiskCache(path, rev, "mod")
+// writeDiskGoMod(ctx, file, data) to write a new cache entry.
+// The go.mod file is checked following the DownloadOptions in effect for ctx.
+func readDiskGoMod(ctx context.Context, path, rev string) (file string, data []byte, err error) {
+	file, data, err = readDiskCache(ctx, path, rev, "mod")
 
 	// If the file has an old auto-conversion prefix, pretend it's not there.
 	if bytes.HasPrefix(data, oldVgoPrefix) {
@@ -518,7 +737,7 @@ func readDiskGoMod(path, rev string) (file string, data []byte, err error) {
 	}
 
 	if err == nil {
-		if err := checkGoMod(path, rev, data); err != nil {
+		if err := checkGoMod(ctx, path, rev, data); err != nil {
 			return "", nil, err
 		}
 	}
@@ -530,13 +749,19 @@ func readDiskGoMod(path, rev string) (file string, data []byte, err error) {
 // It takes the revision and an identifying suffix for the kind of data being cached.
 // It returns the name of the cache file and the content of the file.
 // If the read fails, the caller can use
-// writeDiskCache(file, data) to write a new cache entry.
-func readDiskCache(path, rev, suffix string) (file string, data []byte, err error) {
+// writeDiskCache(ctx, file, data) to write a new cache entry.
+func readDiskCache(ctx context.Context, path, rev, suffix string) (file string, data []byte, err error) {
 	file, err = CachePath(module.Version{Path: path, Version: rev}, suffix)
 	if err != nil {
 		return "", nil, errNotCached
 	}
 	data, err = renameio.ReadFile(file)
+	if err != nil && fillFromStore(ctx, file) {
+		if suffix == "mod" {
+			rewriteVersionList(ctx, filepath.Dir(file))
+		}
+		data, err = renameio.ReadFile(file)
+	}
 	if err != nil {
 		return file, nil, errNotCached
 	}
@@ -545,7 +770,7 @@ func readDiskCache(path, rev, suffix string) (file string, data []byte, err erro
 
 // writeDiskStat writes a stat result cache entry.
 // The file name must have been returned by a previous call to readDiskStat.
-func writeDiskStat(file string, info *RevInfo) error {
+func writeDiskStat(ctx context.Context, file string, info *RevInfo) error {
 	if file == "" {
 		return nil
 	}
@@ -553,39 +778,45 @@ func writeDiskStat(file string, info *RevInfo) error {
 	if err != nil {
 		return err
 	}
-	return writeDiskCache(file, js)
+	return writeDiskCache(ctx, file, js)
 }
 
 // writeDiskGoMod writes a go.mod cache entry.
 // The file name must have been returned by a previous call to readDiskGoMod.
-func writeDiskGoMod(file string, text []byte) error {
-	return writeDiskCache(file, text)
+func writeDiskGoMod(ctx context.Context, file string, text []byte) error {
+	return writeDiskCache(ctx, file, text)
 }
 
 // writeDiskCache is the generic "write to a cache file" implementation.
 // The file must have been returned by a previous call to readDiskCache.
-func writeDiskCache(file string, data []byte) error {
+// The file is written following the DownloadOptions in effect for ctx.
+func writeDiskCache(ctx context.Context, file string, data []byte) error {
 	if file == "" {
 		return nil
 	}
 	// Make sure directory for file exists.
-	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+	mode := options(ctx).CacheMode
+	if err := mkdirAll(filepath.Dir(file), mode); err != nil {
 		return err
 	}
 
 	if err := renameio.WriteFile(file, data, 0666); err != nil {
 		return err
 	}
+	if err := setCacheMode(file, mode); err != nil {
+		return err
+	}
 
 	if strings.HasSuffix(file, ".mod") {
-		rewriteVersionList(filepath.Dir(file))
+		rewriteVersionList(ctx, filepath.Dir(file))
 	}
+	putStore(ctx, file)
 	return nil
 }
 
 // rewriteVersionList rewrites the version list in dir
 // after a new *.mod file has been written.
-func rewriteVersionList(dir string) {
+func rewriteVersionList(ctx context.Context, dir string) {
 	if filepath.Base(dir) != "@v" {
 		base.Fatalf("go: internal error: misuse of rewriteVersionList")
 	}
@@ -597,7 +828,8 @@ func rewriteVersionList(dir string) {
 	// a GOPROXY HTTP server, and if we crash midway through a rewrite (or if the
 	// HTTP server ignores our locking and serves the file midway through a
 	// rewrite) it's better to serve a stale list than a truncated one.
-	unlock, err := lockedfile.MutexAt(listFile + ".lock").Lock()
+	mode := options(ctx).CacheMode
+	unlock, err := lockCacheFile(listFile+".lock", mode)
 	if err != nil {
 		base.Fatalf("go: can't lock version list lockfile: %v", err)
 	}
@@ -638,4 +870,57 @@ func rewriteVersionList(dir string) {
 	if err := renameio.WriteFile(listFile, buf.Bytes(), 0666); err != nil {
 		base.Fatalf("go: failed to write version list: %v", err)
 	}
+	if err := setCacheMode(listFile, mode); err != nil {
+		base.Fatalf("go: failed to write version list: %v", err)
+	}
+}
+
+// SameContents reports whether the files a and b have identical contents.
+// Checksums identify files with the same contents only with high probability;
+// SameContents confirms it before one file is substituted for another.
+func SameContents(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ia, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	ib, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(ia, ib) {
+		return true, nil
+	}
+	if ia.Size() != ib.Size() {
+		return false, nil
+	}
+
+	bufa := make([]byte, 32*1024)
+	bufb := make([]byte, len(bufa))
+	for {
+		na, erra := io.ReadFull(fa, bufa)
+		nb, errb := io.ReadFull(fb, bufb)
+		if !bytes.Equal(bufa[:na], bufb[:nb]) {
+			return false, nil
+		}
+		if erra == io.EOF || erra == io.ErrUnexpectedEOF {
+			return errb == erra, nil
+		}
+		if erra != nil {
+			return false, erra
+		}
+		if errb != nil {
+			return false, errb
+		}
+	}
 }
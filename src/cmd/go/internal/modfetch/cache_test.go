@@ -5,10 +5,15 @@
 package modfetch
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/mod/module"
 )
 
 func TestWriteDiskCache(t *testing.T) {
@@ -18,8 +23,87 @@ func TestWriteDiskCache(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 
-	err = writeDiskCache(filepath.Join(tmpdir, "file"), []byte("data"))
+	err = writeDiskCache(context.Background(), filepath.Join(tmpdir, "file"), []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSameContents(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "go-sameContents-test-")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(tmpdir)
+
+	long := strings.Repeat("x", 100000)
+	files := map[string]string{
+		"a":     "hello",
+		"b":     "hello",
+		"c":     "hellp",
+		"d":     "hello, world",
+		"long1": long + "a",
+		"long2": long + "a",
+		"long3": long + "b",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"a", "a", true},
+		{"a", "b", true},
+		{"a", "c", false},
+		{"a", "d", false},
+		{"long1", "long2", true},
+		{"long1", "long3", false},
+	} {
+		same, err := SameContents(filepath.Join(tmpdir, tt.a), filepath.Join(tmpdir, tt.b))
+		if err != nil {
+			t.Errorf("SameContents(%s, %s): %v", tt.a, tt.b, err)
+		} else if same != tt.want {
+			t.Errorf("SameContents(%s, %s) = %v, want %v", tt.a, tt.b, same, tt.want)
+		}
+	}
+
+	if _, err := SameContents(filepath.Join(tmpdir, "a"), filepath.Join(tmpdir, "missing")); err == nil {
+		t.Errorf("SameContents with missing file: no error")
+	}
+}
+
+func TestDownloadInfo(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "go-downloadInfo-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	defer func(pkgMod string) { PkgMod = pkgMod }(PkgMod)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+
+	mod := module.Version{Path: "example.com/m", Version: "v1.0.0"}
+	file, err := CachePath(mod, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDiskCache(context.Background(), file, []byte(`{"Version":"v1.0.0","Time":"2020-01-02T15:04:05Z"}`)); err != nil {
+		t.Fatal(err)
+	}
+	o := &Origin{Proxy: "https://proxy.example.com"}
+	origins.Store(mod, o)
+	defer origins.Delete(mod)
+
+	info, err := DownloadInfo(mod)
+	if err != nil {
+		t.Fatalf("DownloadInfo(%v): %v", mod, err)
+	}
+	want := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	if info.Version != mod.Version || !info.Time.Equal(want) || info.Origin != o {
+		t.Errorf("DownloadInfo(%v) = %+v, want Version %s, Time %v, Origin %+v", mod, info, mod.Version, want, o)
+	}
 }
@@ -252,11 +252,26 @@ func Run(dir string, cmdline ...interface{}) ([]byte, error) {
 	return RunWithStdin(dir, nil, cmdline...)
 }
 
+// traceRun reports the command line cmd, run in dir since start, to cfg.Trace.
+func traceRun(cmd, dir string, start time.Time, err error) {
+	e := cfg.TraceEntry{Command: cmd, Dir: dir, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		e.ExitStatus = -1
+		e.Error = err.Error()
+		if re, ok := err.(*RunError); ok {
+			if ee, ok := re.Err.(*exec.ExitError); ok {
+				e.ExitStatus = ee.ExitCode()
+			}
+		}
+	}
+	cfg.Trace(e)
+}
+
 // bashQuoter escapes characters that have special meaning in double-quoted strings in the bash shell.
 // See https://www.gnu.org/software/bash/manual/html_node/Double-Quotes.html.
 var bashQuoter = strings.NewReplacer(`"`, `\"`, `$`, `\$`, "`", "\\`", `\`, `\\`)
 
-func RunWithStdin(dir string, stdin io.Reader, cmdline ...interface{}) ([]byte, error) {
+func RunWithStdin(dir string, stdin io.Reader, cmdline ...interface{}) (_ []byte, err error) {
 	if dir != "" {
 		muIface, ok := dirLock.Load(dir)
 		if !ok {
@@ -268,13 +283,8 @@ func RunWithStdin(dir string, stdin io.Reader, cmdline ...interface{}) ([]byte,
 	}
 
 	cmd := str.StringList(cmdline...)
-	if cfg.BuildX {
+	if cfg.BuildX || cfg.Trace != nil {
 		text := new(strings.Builder)
-		if dir != "" {
-			text.WriteString("cd ")
-			text.WriteString(dir)
-			text.WriteString("; ")
-		}
 		for i, arg := range cmd {
 			if i > 0 {
 				text.WriteByte(' ')
@@ -294,10 +304,21 @@ func RunWithStdin(dir string, stdin io.Reader, cmdline ...interface{}) ([]byte,
 				text.WriteString(arg)
 			}
 		}
-		fmt.Fprintf(os.Stderr, "%s\n", text)
+		cmdText := text.String()
+		if dir != "" {
+			cmdText = "cd " + dir + "; " + cmdText
+		}
+		if cfg.BuildX {
+			fmt.Fprintf(os.Stderr, "%s\n", cmdText)
+		}
 		start := time.Now()
 		defer func() {
-			fmt.Fprintf(os.Stderr, "%.3fs # %s\n", time.Since(start).Seconds(), text)
+			if cfg.BuildX {
+				fmt.Fprintf(os.Stderr, "%.3fs # %s\n", time.Since(start).Seconds(), cmdText)
+			}
+			if cfg.Trace != nil {
+				traceRun(text.String(), dir, start, err)
+			}
 		}()
 	}
 	// TODO: Impose limits on command output size.
@@ -309,7 +330,7 @@ func RunWithStdin(dir string, stdin io.Reader, cmdline ...interface{}) ([]byte,
 	c.Stdin = stdin
 	c.Stderr = &stderr
 	c.Stdout = &stdout
-	err := c.Run()
+	err = c.Run()
 	if err != nil {
 		err = &RunError{Cmd: strings.Join(cmd, " ") + " in " + dir, Stderr: stderr.Bytes(), Err: err}
 	}
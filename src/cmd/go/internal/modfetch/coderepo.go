@@ -7,6 +7,7 @@ package modfetch
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -841,6 +842,7 @@ func (r *codeRepo) GoMod(version string) (data []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
+	r.recordRevision(version, rev)
 	if gomod != nil {
 		return gomod, nil
 	}
@@ -854,6 +856,14 @@ func (r *codeRepo) GoMod(version string) (data []byte, err error) {
 	return data, nil
 }
 
+// recordRevision records the full ID of the revision rev, from which the
+// files for version are being read, for reporting in the module's Origin.
+func (r *codeRepo) recordRevision(version, rev string) {
+	if info, err := r.code.Stat(rev); err == nil {
+		directRevisions.Store(module.Version{Path: r.modPath, Version: version}, info.Name)
+	}
+}
+
 func (r *codeRepo) legacyGoMod(rev, dir string) []byte {
 	// We used to try to build a go.mod reflecting pre-existing
 	// package management metadata files, but the conversion
@@ -869,7 +879,9 @@ func (r *codeRepo) modPrefix(rev string) string {
 	return r.modPath + "@" + rev
 }
 
-func (r *codeRepo) Zip(dst io.Writer, version string) error {
+// Zip writes the zip file for version to dst.
+// The underlying version control commands are not canceled if ctx is done.
+func (r *codeRepo) Zip(ctx context.Context, dst io.Writer, version string) error {
 	if version != module.CanonicalVersion(version) {
 		return fmt.Errorf("version %s is not canonical", version)
 	}
@@ -893,6 +905,7 @@ func (r *codeRepo) Zip(dst io.Writer, version string) error {
 	if err != nil {
 		return err
 	}
+	r.recordRevision(version, rev)
 	defer dl.Close()
 	subdir = strings.Trim(subdir, "/")
 
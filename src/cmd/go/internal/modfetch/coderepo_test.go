@@ -6,6 +6,7 @@ package modfetch
 
 import (
 	"archive/zip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"hash"
@@ -519,7 +520,7 @@ func TestCodeRepo(t *testing.T) {
 						} else {
 							w = f
 						}
-						err = repo.Zip(w, tt.version)
+						err = repo.Zip(context.Background(), w, tt.version)
 						f.Close()
 						if err != nil {
 							if tt.zipErr != "" {
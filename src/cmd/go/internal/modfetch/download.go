@@ -0,0 +1,737 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"cmd/go/internal/par"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// DownloadOptions controls the behavior of DownloadModules.
+// The zero value downloads each module's .info, .mod, and .zip files,
+// one module at a time, and extracts the zip files into the module cache.
+type DownloadOptions struct {
+	// Concurrency is the maximum number of modules to fetch at once.
+	// Values less than 1 mean 1.
+	Concurrency int
+
+	// Retry is the number of times to retry a module that fails to download
+	// with a transient error (see IsTransient). The first retry is made after
+	// one second, and the delay doubles before each subsequent retry.
+	Retry int
+
+	// Timeout, if positive, limits the time spent fetching the files for
+	// each module, including any retries. Extracting a downloaded zip file
+	// is not subject to the timeout.
+	Timeout time.Duration
+
+	// ModOnly causes DownloadModules to fetch only the .info and .mod files
+	// for each module, skipping the zip file and the extracted directory.
+	ModOnly bool
+
+	// NoExtract causes DownloadModules to fetch the zip file for each module
+	// without extracting it, leaving Dir empty. The zip file is extracted
+	// later, by Download, if a go command needs the module's source code.
+	NoExtract bool
+
+	// SumOnly causes DownloadModules to check that go.sum lists checksums
+	// for each module and its go.mod file instead of downloading the zip file.
+	SumOnly bool
+
+	// CheckSums causes DownloadModules to require that go.sum already lists
+	// checksums for each module it downloads (only for the go.mod file,
+	// with ModOnly).
+	CheckSums bool
+
+	// Verify causes DownloadModules to check that modules already present
+	// in the module cache still match their recorded checksums.
+	Verify bool
+
+	// Missing causes DownloadModules to fetch only the modules that are not
+	// already in the module cache, as reported by IsCached. The results for
+	// the other modules are read from the module cache without taking its
+	// locks or using the network. Missing is ignored with ModOnly, SumOnly,
+	// or Verify.
+	Missing bool
+
+	// MaxTotalSize, if positive, limits the total size of the zip files
+	// DownloadModules downloads. Once the zip files downloaded so far (not
+	// counting those already in the module cache) total MaxTotalSize bytes
+	// or more, each module whose zip file is not in the module cache fails
+	// with ErrSizeBudget instead of being downloaded. Downloads already in
+	// progress are finished, so the total may exceed MaxTotalSize.
+	MaxTotalSize int64
+
+	// KeepGoing causes DownloadModules to recover from a panic while
+	// downloading a module, reporting it as that module's error.
+	KeepGoing bool
+
+	// Signature, if non-nil, causes DownloadModules to require a signature
+	// by this verifier for each module, listing the module's checksums
+	// (see CheckSignature). A module that fails the check is not extracted.
+	Signature note.Verifier
+
+	// ProxyOnly causes lookups of modules that would be fetched directly
+	// from a version control repository, whether because of a "direct" entry
+	// in GOPROXY or because of GONOPROXY, to fail with an error naming the
+	// repository. Modules served by a proxy found through a go-get=1 meta tag
+	// are still allowed.
+	ProxyOnly bool
+
+	// ReportMismatches causes a mismatch between a downloaded module and
+	// its checksum in go.sum to be returned as an error wrapping a
+	// *MismatchError, like a mismatch with the checksum database, instead
+	// of stopping the go command.
+	ReportMismatches bool
+
+	// Conditional causes the .info files for revisions that are not
+	// canonical versions, such as branch names, to be fetched from module
+	// proxies with conditional requests. Those files are not kept in the
+	// module cache, so every go command that resolves such a revision
+	// fetches its .info file again. With Conditional set, the entity tag
+	// (ETag) that the proxy sends with the file is saved in the module cache
+	// together with a copy of the file, and if the proxy later reports that
+	// the file has not changed, the copy is used instead. Proxies that send
+	// no entity tags are unaffected.
+	Conditional bool
+
+	// ExtractBuffer, if positive, causes module zip files to be extracted
+	// by copying each file in the zip file to disk through a buffer of
+	// ExtractBuffer bytes, so that the memory used to extract a module does
+	// not depend on the size of its files. The zip file is first checked as
+	// golang.org/x/mod/zip.Unzip checks it, and the extracted directory is
+	// then hashed and checked against the checksum of the zip file before it
+	// is put in place. If ExtractBuffer is zero, zip files are extracted
+	// with golang.org/x/mod/zip.Unzip.
+	ExtractBuffer int
+
+	// CacheMode, if nonzero, holds the permission bits given to each file
+	// written in the module cache, including lock files and the files
+	// extracted from module zip files, regardless of the umask. Directories
+	// created there get the same bits, plus search permission for each class
+	// of user granted read permission, so that with CacheMode = 0664 the
+	// users in a group can all add modules to a shared module cache.
+	// Extracted directories are then not made read-only.
+	//
+	// Files and directories created by other users, or without CacheMode,
+	// are left alone; CacheMode must grant the owner read and write
+	// permission.
+	CacheMode os.FileMode
+
+	// TraceHeaders lists the names of the response headers, such as
+	// Cache-Control, Age, or X-Cache, to record for each file fetched from a
+	// module proxy, for debugging the proxy's caching (see ProxyHeaders).
+	// Because headers may hold sensitive values, only the headers named are
+	// recorded, and none are recorded by default.
+	TraceHeaders []string
+
+	// Store, if non-nil, shares cached module files through a CacheStore:
+	// files missing from the local module cache are looked for in Store
+	// before being fetched from a proxy, and files added to the local module
+	// cache are also stored in Store. Store is not used while Offline is
+	// set, since a CacheStore may be remote.
+	Store CacheStore
+
+	// Fetched, if non-nil, is called for each module once its files have been
+	// fetched, before its zip file is extracted. Fetched may update the file
+	// names recorded in r. An error returned by Fetched becomes the module's
+	// error. Fetched may be called concurrently for different modules.
+	Fetched func(r *DownloadResult) error
+
+	// Done, if non-nil, is called for each module once DownloadModules has
+	// finished with it, with the module's index in mods and its final result.
+	// Done may be called concurrently for different modules, in any order,
+	// but r is not modified after Done is called.
+	Done func(i int, r *DownloadResult)
+}
+
+// optionsKey is the context key under which DownloadModules records its
+// options for the code that fetches and extracts each module.
+type optionsKey struct{}
+
+var defaultOptions struct {
+	mu   sync.Mutex
+	opts *DownloadOptions
+}
+
+// SetDefaultOptions sets the options used to fetch and extract modules
+// outside calls to DownloadModules, such as the lookups made by the module
+// loader to resolve module queries. Only the fields describing how files are
+// fetched, checked, and stored in the module cache apply: the fields that
+// control a call to DownloadModules as a whole, such as Concurrency and Done,
+// are ignored. DownloadModules itself uses only the options passed to it.
+func SetDefaultOptions(opts DownloadOptions) {
+	defaultOptions.mu.Lock()
+	defaultOptions.opts = &opts
+	defaultOptions.mu.Unlock()
+}
+
+// options returns the options in effect for work done with ctx: those passed
+// to DownloadModules, if ctx comes from a call to it, or else those set by
+// SetDefaultOptions. The result must not be modified.
+func options(ctx context.Context) *DownloadOptions {
+	if opts, ok := ctx.Value(optionsKey{}).(*DownloadOptions); ok {
+		return opts
+	}
+	defaultOptions.mu.Lock()
+	defer defaultOptions.mu.Unlock()
+	if defaultOptions.opts == nil {
+		return new(DownloadOptions)
+	}
+	return defaultOptions.opts
+}
+
+// A DownloadResult describes the outcome of downloading a module.
+type DownloadResult struct {
+	Mod              module.Version
+	Time             *time.Time // time recorded in the .info file, if any
+	Info             string     // cached .info file
+	GoMod            string     // cached .mod file
+	Zip              string     // cached .zip file
+	Dir              string     // extracted directory
+	UncompressedSize int64      // total size of the extracted files
+	Sum              string     // checksum for the zip file
+	GoModSum         string     // checksum for the go.mod file
+	GoVersion        string     // go directive from the go.mod file
+	Retries          int        // number of retries made
+	Verified         bool       // cached module verified (with Verify)
+	Cached           bool       // zip file was already in the module cache
+	Origin           *Origin    // where the files came from
+	Err              error      // error downloading the module, if any
+}
+
+// ErrSizeBudget is wrapped by the errors DownloadModules returns for modules
+// skipped because the downloads exhausted DownloadOptions.MaxTotalSize.
+var ErrSizeBudget = errors.New("skipped: download size budget exhausted")
+
+// retryBackoff is the delay before the first retry of a module download
+// that failed with a transient error. The delay doubles after each retry.
+// It is a variable so that tests can change it.
+var retryBackoff = 1 * time.Second
+
+// DownloadModules downloads the given module versions into the module cache,
+// returning one result per module, in the same order as mods.
+//
+// DownloadModules takes its download settings (ProxyOnly, Conditional,
+// CacheMode, Store and the rest) from opts, which it passes down to every
+// fetch and extraction it makes. The defaults set by SetDefaultOptions apply
+// only to state shared with other callers: the loading of the go.sum file
+// and the checksum database's cache files. Like the rest of this package,
+// DownloadModules still consults the environment (GOPROXY, GOSUMDB, and so
+// on), PkgMod and GoSumFile, the Offline and Resume settings, and the hooks
+// and limits installed by SetMismatchHandler, SetProgressFunc,
+// SetMetricsObserver and SetZipRateLimit. If ctx is done, modules not yet
+// fetched fail with ctx's error and requests in flight are canceled.
+//
+// Checksums added to go.sum for the downloaded modules are not written to
+// the go.sum file until the caller calls WriteGoSum.
+func DownloadModules(ctx context.Context, mods []module.Version, opts DownloadOptions) []DownloadResult {
+	d := &downloader{opts: opts}
+	ctx = context.WithValue(ctx, optionsKey{}, &d.opts)
+	if opts.SumOnly || opts.CheckSums {
+		d.sums = Sums(mods)
+	}
+	results := make([]DownloadResult, len(mods))
+	var work par.Work
+	var cached []int
+	for i, mod := range mods {
+		results[i].Mod = mod
+		if opts.Missing && !opts.ModOnly && !opts.SumOnly && !opts.Verify && IsCached(mod) {
+			results[i].Cached = true
+			cached = append(cached, i)
+			continue
+		}
+		work.Add(i)
+	}
+
+	// Extracting zip files is CPU-bound, while downloading them is limited
+	// by the network, so the two are done by separate groups of workers:
+	// the index of each module whose zip file has been downloaded
+	// (and checked) is sent to extract.
+	extract := make(chan int)
+	var extractWG sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		extractWG.Add(1)
+		go func() {
+			defer extractWG.Done()
+			for i := range extract {
+				d.extract(&results[i])
+				d.done(i, &results[i])
+			}
+		}()
+	}
+
+	// The modules found in the module cache need only be read from it,
+	// so they are not sent to the workers fetching files.
+	for _, i := range cached {
+		if d.fetch(ctx, &results[i]) && !opts.NoExtract {
+			extract <- i
+			continue
+		}
+		d.done(i, &results[i])
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	work.Do(concurrency, func(item interface{}) {
+		i := item.(int)
+		if d.fetch(ctx, &results[i]) && !opts.NoExtract {
+			extract <- i
+			return
+		}
+		d.done(i, &results[i])
+	})
+	close(extract)
+	extractWG.Wait()
+	return results
+}
+
+// A WarmProgress reports the progress of a call to WarmCache.
+type WarmProgress struct {
+	Done   int             // number of modules finished so far, including this one
+	Total  int             // number of modules to be warmed
+	Result *DownloadResult // result for the module just finished
+}
+
+// WarmCache downloads, checks, and extracts the given module versions into
+// the module cache, fetching up to concurrency modules at once, and returns
+// one result per module, in the same order as mods. It is meant for
+// programs such as editors that prefetch a project's dependencies in the
+// background and may abandon the work at any time.
+//
+// If onProgress is non-nil, it is called once for each module as it is
+// finished, in the order the modules finish. Calls to onProgress are not
+// concurrent, and Done increases by one with each call.
+//
+// Canceling ctx stops WarmCache promptly: zip files being downloaded are
+// abandoned, no further files are fetched, and modules not yet started
+// fail with ctx's error.
+// A module whose zip file was already being extracted is still extracted,
+// so that the module cache is not left with a partial directory.
+// A panic while warming one module is reported as that module's error.
+func WarmCache(ctx context.Context, mods []module.Version, concurrency int, onProgress func(WarmProgress)) []DownloadResult {
+	opts := DownloadOptions{
+		Concurrency: concurrency,
+		KeepGoing:   true,
+	}
+	if onProgress != nil {
+		var mu sync.Mutex
+		done := 0
+		opts.Done = func(i int, r *DownloadResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			onProgress(WarmProgress{Done: done, Total: len(mods), Result: r})
+		}
+	}
+	return DownloadModules(ctx, mods, opts)
+}
+
+// A downloader holds the state of a call to DownloadModules.
+type downloader struct {
+	opts DownloadOptions
+
+	// sums holds the checksums listed in go.sum for the modules to be
+	// downloaded, looked up before starting the workers so that they
+	// need not contend for the go.sum lock.
+	sums map[module.Version]SumResult
+
+	mu    sync.Mutex
+	total int64 // total size of the zip files downloaded so far
+}
+
+// spent reports whether the zip files downloaded so far have exhausted
+// d.opts.MaxTotalSize.
+func (d *downloader) spent() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.total >= d.opts.MaxTotalSize
+}
+
+// spend adds the size of the downloaded zip file to d.total.
+func (d *downloader) spend(zipfile string) {
+	fi, err := os.Stat(zipfile)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	d.total += fi.Size()
+	d.mu.Unlock()
+}
+
+// download fetches the files for the module described by r,
+// recording their locations in r.
+// If ctx is done, download abandons the file being fetched from a module
+// proxy and fetches no further files.
+func (d *downloader) download(ctx context.Context, r *DownloadResult) error {
+	mod := r.Mod
+	var err error
+	var info *RevInfo
+	r.Info, info, err = downloadInfo(ctx, mod)
+	if err != nil {
+		return err
+	}
+	if !info.Time.IsZero() {
+		r.Time = &info.Time
+	}
+	r.GoMod, err = goModFile(ctx, mod.Path, mod.Version)
+	if err != nil {
+		return err
+	}
+	r.GoModSum, err = GoModSum(mod.Path, mod.Version)
+	if err != nil {
+		return err
+	}
+	if f, err := parseGoMod(r.GoMod); err == nil && f.Go != nil {
+		r.GoVersion = f.Go.Version
+	}
+	if err := ctx.Err(); err != nil {
+		return module.VersionError(mod, err)
+	}
+	if d.opts.SumOnly {
+		if err := d.checkSums(r); err != nil {
+			return err
+		}
+	} else if !d.opts.ModOnly {
+		if Offline {
+			if _, ok := CachedDir(mod); !ok {
+				return module.VersionError(mod, ErrNotInCache)
+			}
+		}
+		if r.Cached && d.opts.Missing {
+			// Found by IsCached: the zip file need not be locked.
+			r.Zip, err = CachePath(mod, "zip")
+		} else {
+			if d.opts.MaxTotalSize > 0 && d.spent() {
+				if zipfile, err := CachePath(mod, "zip"); err != nil || !fileExists(zipfile) {
+					return module.VersionError(mod, ErrSizeBudget)
+				}
+			}
+			r.Zip, r.Cached, err = DownloadZipCached(ctx, mod)
+		}
+		if err != nil {
+			return err
+		}
+		if d.opts.MaxTotalSize > 0 && !r.Cached {
+			d.spend(r.Zip)
+		}
+		r.Sum = Sum(mod)
+	}
+	if d.opts.CheckSums && !d.opts.SumOnly {
+		var err error
+		if d.opts.ModOnly {
+			err = d.checkGoModSum(mod)
+		} else {
+			err = d.checkSums(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if d.opts.Signature != nil {
+		if err := CheckSignature(mod, d.opts.Signature, r.Sum, r.GoModSum); err != nil {
+			return err
+		}
+	}
+	r.Origin = FetchOrigin(mod)
+	if d.opts.Fetched != nil {
+		if err := d.opts.Fetched(r); err != nil {
+			return module.VersionError(mod, err)
+		}
+	}
+	return nil
+}
+
+// listedSums returns the checksums listed in go.sum for mod and its go.mod
+// file, from d.sums if possible.
+func (d *downloader) listedSums(mod module.Version) SumResult {
+	if r, ok := d.sums[mod]; ok {
+		return r
+	}
+	return SumResult{
+		Listed:      ListedSums(mod),
+		ListedGoMod: ListedSums(module.Version{Path: mod.Path, Version: mod.Version + "/go.mod"}),
+	}
+}
+
+// checkGoModSum checks that go.sum lists a checksum for the go.mod file of mod.
+func (d *downloader) checkGoModSum(mod module.Version) error {
+	if len(d.listedSums(mod).ListedGoMod) == 0 {
+		return module.VersionError(mod, errors.New("missing go.sum entry for go.mod file"))
+	}
+	return nil
+}
+
+// checkSums checks that go.sum lists checksums for r.Mod and its go.mod file,
+// recording the checksum of the cached zip file, if any, in r.
+func (d *downloader) checkSums(r *DownloadResult) error {
+	mod := r.Mod
+	if err := d.checkGoModSum(mod); err != nil {
+		return err
+	}
+	sums := d.listedSums(mod).Listed
+	if len(sums) == 0 {
+		return module.VersionError(mod, errors.New("missing go.sum entry"))
+	}
+	known := false
+	for _, h := range sums {
+		if strings.HasPrefix(h, "h1:") {
+			known = true
+		}
+	}
+	if !known {
+		return module.VersionError(mod, fmt.Errorf("cannot verify go.sum entry: unknown hashes %s", strings.Join(sums, ", ")))
+	}
+	r.Sum = Sum(mod)
+	if r.Sum == "" {
+		// Not in the module cache; the zip file will be checked
+		// against go.sum when it is downloaded.
+		return nil
+	}
+	for _, h := range sums {
+		if h == r.Sum {
+			return nil
+		}
+	}
+	for _, h := range sums {
+		if strings.HasPrefix(h, "h1:") {
+			reportMismatch(mod, r.Sum, h)
+			break
+		}
+	}
+	return module.VersionError(mod, fmt.Errorf("checksum mismatch\n\tmodule cache: %v\n\tgo.sum:       %v", r.Sum, strings.Join(sums, ", ")))
+}
+
+// fetch downloads the files for r.Mod, retrying transient errors as
+// configured, and reports whether the zip file is ready to be extracted.
+func (d *downloader) fetch(ctx context.Context, r *DownloadResult) (ok bool) {
+	if d.opts.KeepGoing {
+		defer recoverDownload(r)
+	}
+	if err := ctx.Err(); err != nil {
+		r.Err = module.VersionError(r.Mod, err)
+		return false
+	}
+	if d.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.opts.Timeout)
+		defer cancel()
+	}
+	backoff := retryBackoff
+	for {
+		err := d.download(ctx, r)
+		if err == nil {
+			return r.Zip != ""
+		}
+		if ctx.Err() == context.DeadlineExceeded && d.opts.Timeout > 0 {
+			r.Err = fmt.Errorf("timed out after %v: %w", d.opts.Timeout, err)
+			return false
+		}
+		if r.Retries >= d.opts.Retry || !IsTransient(err) {
+			r.Err = err
+			return false
+		}
+		r.Retries++
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err := ctx.Err()
+			if err == context.DeadlineExceeded && d.opts.Timeout > 0 {
+				err = fmt.Errorf("timed out after %v: %w", d.opts.Timeout, err)
+			}
+			r.Err = module.VersionError(r.Mod, err)
+			return false
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// done reports the final result for the i'th module to the Done callback.
+func (d *downloader) done(i int, r *DownloadResult) {
+	if d.opts.Done != nil {
+		d.opts.Done(i, r)
+	}
+}
+
+// extract extracts the zip file downloaded for r into the module cache,
+// recording the location of the extracted directory in r.
+// The zip file has already been checked against go.sum by download.
+func (d *downloader) extract(r *DownloadResult) {
+	if d.opts.KeepGoing {
+		defer recoverDownload(r)
+	}
+	// The zip file has already been fetched, so there is nothing to cancel,
+	// but the extraction still follows d.opts.
+	ctx := context.WithValue(context.Background(), optionsKey{}, &d.opts)
+	var err error
+	r.Dir, err = DownloadWithContext(ctx, r.Mod)
+	if err != nil {
+		r.Err = err
+		return
+	}
+	if size, err := zipUncompressedSize(r.Zip); err == nil {
+		r.UncompressedSize = size
+	}
+	if d.opts.Verify && r.Cached {
+		if err := verifyCached(r); err != nil {
+			r.Err = module.VersionError(r.Mod, err)
+			return
+		}
+		r.Verified = true
+	}
+}
+
+// recoverDownload records a panic during the download of r as an error in r.
+// It must be called directly by a deferred function call.
+func recoverDownload(r *DownloadResult) {
+	if x := recover(); x != nil {
+		r.Err = fmt.Errorf("internal error: %v", x)
+	}
+}
+
+// parseGoMod parses the cached go.mod file for a downloaded module.
+func parseGoMod(file string) (*modfile.File, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return modfile.ParseLax(file, data, nil)
+}
+
+// zipUncompressedSize returns the total uncompressed size of the files
+// in the named zip file, which is the size of the files extracted from it.
+// It reads only the zip file's directory, not the file contents.
+func zipUncompressedSize(file string) (int64, error) {
+	z, err := zip.OpenReader(file)
+	if err != nil {
+		return 0, err
+	}
+	defer z.Close()
+	var size int64
+	for _, f := range z.File {
+		size += int64(f.UncompressedSize64)
+	}
+	return size, nil
+}
+
+// IsCached reports whether the module cache holds all of the files
+// downloaded for mod: its .info, .mod, and .zip files, and the directory
+// extracted from the zip file, with a checksum matching go.sum, if go.sum
+// lists one. Like CachedDir, IsCached examines only the local file system,
+// and it does not take the module cache's locks.
+func IsCached(mod module.Version) bool {
+	for _, suffix := range []string{"info", "mod", "zip"} {
+		file, err := CachePath(mod, suffix)
+		if err != nil || !fileExists(file) {
+			return false
+		}
+	}
+	_, ok := CachedDir(mod)
+	return ok
+}
+
+// VerifyCached checks that the zip file for mod in the module cache, and
+// the directory extracted from it, if any, still match the checksum
+// recorded when the zip file was downloaded, and that the checksum matches
+// the one go.sum lists for mod, if any. Because it hashes the files on disk
+// again, VerifyCached detects files corrupted after they were checked
+// during the download.
+func VerifyCached(mod module.Version) error {
+	zipfile, err := CachePath(mod, "zip")
+	if err != nil {
+		return module.VersionError(mod, err)
+	}
+	if _, err := os.Stat(zipfile); err != nil {
+		return module.VersionError(mod, err)
+	}
+	r := &DownloadResult{Mod: mod, Zip: zipfile, Sum: Sum(mod)}
+	if dir, err := DownloadDir(mod); err == nil {
+		r.Dir = dir
+	}
+	if err := verifyCached(r); err != nil {
+		return module.VersionError(mod, err)
+	}
+
+	goSum.mu.Lock()
+	defer goSum.mu.Unlock()
+	if inited, err := initGoSum(); err != nil {
+		return module.VersionError(mod, err)
+	} else if inited {
+		var want string
+		for _, h := range goSum.m[mod] {
+			if h == r.Sum {
+				return nil
+			}
+			if strings.HasPrefix(h, "h1:") && want == "" {
+				want = h
+			}
+		}
+		if want != "" {
+			return module.VersionError(mod, fmt.Errorf("%w\n\tmodule cache: %v\n\tgo.sum:       %v", ErrChecksumMismatch, r.Sum, want))
+		}
+	}
+	return nil
+}
+
+// verifyCached checks that the cached zip file and source directory for r
+// still match the checksum recorded when the module was downloaded.
+// If r.Dir is empty, only the zip file is checked.
+func verifyCached(r *DownloadResult) error {
+	if r.Sum == "" {
+		return fmt.Errorf("missing ziphash")
+	}
+	h, err := dirhash.HashZip(r.Zip, dirhash.DefaultHash)
+	if err != nil {
+		return err
+	}
+	if h != r.Sum {
+		return &modifiedError{"zip", r.Zip}
+	}
+	if r.Dir == "" {
+		return nil
+	}
+	h, err = dirhash.HashDir(r.Dir, r.Mod.Path+"@"+r.Mod.Version, dirhash.DefaultHash)
+	if err != nil {
+		return err
+	}
+	if h != r.Sum {
+		return &modifiedError{"dir", r.Dir}
+	}
+	return nil
+}
+
+// A modifiedError reports that a file in the module cache
+// no longer matches its recorded checksum.
+type modifiedError struct {
+	kind string // "zip" or "dir"
+	file string
+}
+
+func (e *modifiedError) Error() string {
+	return fmt.Sprintf("%s has been modified (%v)", e.kind, e.file)
+}
+
+func (e *modifiedError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
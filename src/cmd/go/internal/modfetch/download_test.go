@@ -0,0 +1,394 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+func TestDownloadModulesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mods := []module.Version{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: "v1.1.0"},
+		{Path: "example.com/c", Version: "v1.2.0"},
+	}
+	var mu sync.Mutex
+	done := make(map[int]int)
+	results := DownloadModules(ctx, mods, DownloadOptions{
+		Concurrency: 2,
+		Done: func(i int, r *DownloadResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			done[i]++
+			if r.Mod != mods[i] {
+				t.Errorf("Done(%d, %v), want module %v", i, r.Mod, mods[i])
+			}
+		},
+	})
+	if len(results) != len(mods) {
+		t.Fatalf("DownloadModules returned %d results, want %d", len(results), len(mods))
+	}
+	for i := range mods {
+		if done[i] != 1 {
+			t.Errorf("Done called %d times for module %d, want 1", done[i], i)
+		}
+	}
+	for i, r := range results {
+		if r.Mod != mods[i] {
+			t.Errorf("results[%d].Mod = %v, want %v", i, r.Mod, mods[i])
+		}
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("results[%d].Err = %v, want %v", i, r.Err, context.Canceled)
+		}
+		if r.Info != "" || r.Zip != "" {
+			t.Errorf("results[%d] fetched files after cancellation: %+v", i, r)
+		}
+	}
+}
+
+func TestWarmCacheProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mods := []module.Version{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: "v1.1.0"},
+		{Path: "example.com/c", Version: "v1.2.0"},
+	}
+	var progress []int
+	results := WarmCache(ctx, mods, 3, func(p WarmProgress) {
+		if p.Total != len(mods) {
+			t.Errorf("progress Total = %d, want %d", p.Total, len(mods))
+		}
+		if !errors.Is(p.Result.Err, context.Canceled) {
+			t.Errorf("progress for %v: Err = %v, want %v", p.Result.Mod, p.Result.Err, context.Canceled)
+		}
+		progress = append(progress, p.Done)
+	})
+	if len(results) != len(mods) {
+		t.Fatalf("WarmCache returned %d results, want %d", len(results), len(mods))
+	}
+	if want := []int{1, 2, 3}; fmt.Sprint(progress) != fmt.Sprint(want) {
+		t.Errorf("progress Done values = %v, want %v", progress, want)
+	}
+}
+
+func TestMismatchHandler(t *testing.T) {
+	mods := setupSums(t, 2)
+	const bad = "h1:BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB="
+	for _, mod := range mods {
+		ziphash, err := CachePath(mod, "ziphash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(ziphash), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(ziphash, []byte(bad+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	calls := make(map[module.Version][]string)
+	SetMismatchHandler(func(mod module.Version, got, want string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[mod] = append(calls[mod], got+" "+want)
+	})
+	defer SetMismatchHandler(nil)
+
+	// Check each module several times, concurrently.
+	d := &downloader{opts: DownloadOptions{SumOnly: true}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, mod := range mods {
+			wg.Add(1)
+			go func(mod module.Version) {
+				defer wg.Done()
+				if err := d.checkSums(&DownloadResult{Mod: mod}); err == nil {
+					t.Errorf("checkSums(%v) = nil, want checksum mismatch", mod)
+				}
+			}(mod)
+		}
+	}
+	wg.Wait()
+
+	for i, mod := range mods {
+		want := []string{fmt.Sprintf("%s h1:%043d=", bad, i)}
+		if got := calls[mod]; len(got) != 1 || got[0] != want[0] {
+			t.Errorf("handler calls for %v = %q, want %q", mod, got, want)
+		}
+	}
+}
+
+func TestDownloadModulesOptions(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "modfetch-options-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveAll(tmpdir)
+	defer func(old string) { PkgMod = old }(PkgMod)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/@v/v1.0.0.info"):
+			fmt.Fprintf(w, `{"Version": "v1.0.0", "Time": "2020-01-01T00:00:00Z"}`)
+		case strings.HasSuffix(req.URL.Path, "/@v/v1.0.0.mod"):
+			fmt.Fprintf(w, "module %s\n", strings.TrimSuffix(req.URL.Path[1:], "/@v/v1.0.0.mod"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	proxyURLs()
+	defer func(list []string, err error) {
+		proxyOnce.list, proxyOnce.err = list, err
+	}(proxyOnce.list, proxyOnce.err)
+	proxyOnce.list, proxyOnce.err = []string{srv.URL}, nil
+
+	// Each call uses its own options, not the defaults or another call's.
+	defer SetDefaultOptions(DownloadOptions{})
+	SetDefaultOptions(DownloadOptions{TraceHeaders: []string{"X-Cache"}})
+	plain := module.Version{Path: "example.com/options/plain", Version: "v1.0.0"}
+	traced := module.Version{Path: "example.com/options/traced", Version: "v1.0.0"}
+	var wg sync.WaitGroup
+	for _, mod := range []module.Version{plain, traced} {
+		opts := DownloadOptions{ModOnly: true}
+		if mod == traced {
+			opts.TraceHeaders = []string{"X-Cache"}
+		}
+		wg.Add(1)
+		go func(mod module.Version, opts DownloadOptions) {
+			defer wg.Done()
+			if r := DownloadModules(context.Background(), []module.Version{mod}, opts); r[0].Err != nil {
+				t.Errorf("DownloadModules(%v): %v", mod, r[0].Err)
+			}
+		}(mod, opts)
+	}
+	wg.Wait()
+
+	if got := ProxyHeaders(plain); got != nil {
+		t.Errorf("ProxyHeaders(%v) = %v, want nil", plain, got)
+	}
+	got := ProxyHeaders(traced)
+	for _, suffix := range []string{"info", "mod"} {
+		if got[suffix]["X-Cache"] != "HIT" {
+			t.Errorf("ProxyHeaders(%v) = %v, want X-Cache: HIT for %s", traced, got, suffix)
+		}
+	}
+}
+
+func TestStatContextCanceled(t *testing.T) {
+	requested := make(chan bool)
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		held := false
+		once.Do(func() { held = true })
+		if held {
+			// Hold the first request until the client gives up on it.
+			close(requested)
+			<-req.Context().Done()
+			return
+		}
+		fmt.Fprintf(w, `{"Version": "v1.0.0", "Time": "2020-01-01T00:00:00Z"}`)
+	}))
+	defer srv.Close()
+
+	p, err := newProxyRepo(srv.URL, "example.com/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newCachingRepo(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requested
+		cancel()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := statContext(ctx, repo, "v1.0.0")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("statContext after cancellation: %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("statContext did not return after its context was canceled")
+	}
+
+	// The canceled request is not cached, so a later Stat fetches the file again.
+	info, err := repo.Stat("v1.0.0")
+	if err != nil {
+		t.Fatalf("Stat after canceled request: %v", err)
+	}
+	if info.Version != "v1.0.0" {
+		t.Errorf("Stat: Version = %q, want %q", info.Version, "v1.0.0")
+	}
+}
+
+// withBlockingProxy configures modfetch to use a module proxy that serves
+// the files of example.com/blocked@v1.0.0 but holds each request for a file
+// ending in hold until the client cancels it. The returned channel is closed
+// when the first such request arrives.
+func withBlockingProxy(t *testing.T, hold string) (requested <-chan bool, cleanup func()) {
+	tmpdir, err := ioutil.TempDir("", "modfetch-blocking-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPkgMod := PkgMod
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+
+	c := make(chan bool)
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, hold) {
+			once.Do(func() { close(c) })
+			<-req.Context().Done()
+			return
+		}
+		switch req.URL.Path {
+		case "/example.com/blocked/@v/v1.0.0.info":
+			fmt.Fprintf(w, `{"Version": "v1.0.0", "Time": "2020-01-01T00:00:00Z"}`)
+		case "/example.com/blocked/@v/v1.0.0.mod":
+			fmt.Fprintf(w, "module example.com/blocked\n")
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+
+	// Make sure proxyURLs has run, then substitute the test proxy.
+	proxyURLs()
+	oldList, oldErr := proxyOnce.list, proxyOnce.err
+	proxyOnce.list, proxyOnce.err = []string{srv.URL}, nil
+
+	return c, func() {
+		proxyOnce.list, proxyOnce.err = oldList, oldErr
+		srv.Close()
+		PkgMod = oldPkgMod
+		RemoveAll(tmpdir)
+	}
+}
+
+// cancelWhenRequested calls f with a context that is canceled
+// once requested is closed, and returns f's error.
+func cancelWhenRequested(t *testing.T, requested <-chan bool, f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-requested
+		cancel()
+	}()
+	done := make(chan error, 1)
+	go func() { done <- f(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("fetch did not return after its context was canceled")
+		return nil
+	}
+}
+
+func TestDownloadZipWithContextCanceled(t *testing.T) {
+	requested, cleanup := withBlockingProxy(t, ".zip")
+	defer cleanup()
+
+	mod := module.Version{Path: "example.com/blocked", Version: "v1.0.0"}
+	err := cancelWhenRequested(t, requested, func(ctx context.Context) error {
+		_, err := DownloadZipWithContext(ctx, mod)
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DownloadZipWithContext after cancellation: %v, want %v", err, context.Canceled)
+	}
+	if zipfile, _ := CachePath(mod, "zip"); fileExists(zipfile) {
+		t.Errorf("DownloadZipWithContext left %s in the module cache after cancellation", zipfile)
+	}
+}
+
+func TestInfoFileWithContextCanceled(t *testing.T) {
+	requested, cleanup := withBlockingProxy(t, ".info")
+	defer cleanup()
+
+	err := cancelWhenRequested(t, requested, func(ctx context.Context) error {
+		_, err := InfoFileWithContext(ctx, "example.com/blocked", "v1.0.0")
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("InfoFileWithContext after cancellation: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDownloadModulesCanceledDuringBackoff(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "modfetch-backoff-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveAll(tmpdir)
+	defer func(old string) { PkgMod = old }(PkgMod)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+
+	// Fail every request with a transient error, and make the backoff
+	// long enough that the test times out unless cancellation cuts it short.
+	requested := make(chan bool)
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		once.Do(func() { close(requested) })
+	}))
+	defer srv.Close()
+
+	proxyURLs()
+	defer func(list []string, err error) {
+		proxyOnce.list, proxyOnce.err = list, err
+	}(proxyOnce.list, proxyOnce.err)
+	proxyOnce.list, proxyOnce.err = []string{srv.URL}, nil
+
+	defer func(old time.Duration) { retryBackoff = old }(retryBackoff)
+	retryBackoff = time.Hour
+
+	// Cancel once the client has had time to read the failed response
+	// and start waiting to retry.
+	answered := make(chan bool)
+	go func() {
+		<-requested
+		time.Sleep(100 * time.Millisecond)
+		close(answered)
+	}()
+	mod := module.Version{Path: "example.com/unavailable", Version: "v1.0.0"}
+	var results []DownloadResult
+	err = cancelWhenRequested(t, answered, func(ctx context.Context) error {
+		results = DownloadModules(ctx, []module.Version{mod}, DownloadOptions{Retry: 3})
+		return results[0].Err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DownloadModules after cancellation: %v, want %v", err, context.Canceled)
+	}
+	if got := results[0].Retries; got != 1 {
+		t.Errorf("Retries = %d, want 1", got)
+	}
+}
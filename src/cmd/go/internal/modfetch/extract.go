@@ -0,0 +1,231 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cmd/go/internal/str"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+	modzip "golang.org/x/mod/zip"
+)
+
+// unzip extracts the zip file for mod into dir, which must not exist
+// or be empty. If bufSize is positive, the files are copied through a buffer
+// of that size by unzipBuffered (see DownloadOptions.ExtractBuffer);
+// otherwise zipfile is extracted with golang.org/x/mod/zip.Unzip.
+func unzip(dir string, mod module.Version, zipfile string, bufSize int) error {
+	if bufSize <= 0 {
+		return modzip.Unzip(dir, mod, zipfile)
+	}
+	if err := unzipBuffered(dir, mod, zipfile, bufSize); err != nil {
+		return fmt.Errorf("unzip %s: %v", zipfile, err)
+	}
+	return nil
+}
+
+// unzipBuffered is like modzip.Unzip, but it copies each file through a
+// buffer of size bytes, and it checks the hash of the extracted files
+// against the hash recorded for zipfile.
+func unzipBuffered(dir string, mod module.Version, zipfile string, size int) error {
+	if files, _ := ioutil.ReadDir(dir); len(files) > 0 {
+		return fmt.Errorf("target directory %v exists and is not empty", dir)
+	}
+	want, err := zipHash(mod, zipfile)
+	if err != nil {
+		return err
+	}
+
+	z, err := zip.OpenReader(zipfile)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	if err := checkUnzip(mod, zipfile, &z.Reader); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	prefix := mod.Path + "@" + mod.Version + "/"
+	buf := make([]byte, size)
+	for _, zf := range z.File {
+		name := zf.Name[len(prefix):]
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		if err := extractFile(filepath.Join(dir, name), zf, buf); err != nil {
+			return err
+		}
+	}
+
+	got, err := dirhash.HashDir(dir, mod.Path+"@"+mod.Version, dirhash.DefaultHash)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("extracted files do not match zip file checksum\n\textracted: %v\n\tzip:       %v", got, want)
+	}
+	return nil
+}
+
+// checkUnzip checks z, the zip file for mod read from zipfile, as
+// modzip.Unzip does before writing any files, reporting the same errors:
+// the version must be canonical, the zip file and its contents must be
+// within the size limits, and the file names must be valid, must not
+// collide when case is ignored, and must include go.mod only at the
+// module root.
+func checkUnzip(mod module.Version, zipfile string, z *zip.Reader) error {
+	if vers := module.CanonicalVersion(mod.Version); vers != mod.Version {
+		return fmt.Errorf("version %q is not canonical (should be %q)", mod.Version, vers)
+	}
+	if err := module.Check(mod.Path, mod.Version); err != nil {
+		return err
+	}
+	fi, err := os.Stat(zipfile)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > modzip.MaxZipFile {
+		return fmt.Errorf("module zip file is too large (%d bytes; limit is %d bytes)", fi.Size(), modzip.MaxZipFile)
+	}
+
+	collisions := make(collisionChecker)
+	prefix := mod.Path + "@" + mod.Version + "/"
+	var total int64
+	for _, zf := range z.File {
+		if !strings.HasPrefix(zf.Name, prefix) {
+			return fmt.Errorf("unexpected file name %s", zf.Name)
+		}
+		name := zf.Name[len(prefix):]
+		if name == "" {
+			continue
+		}
+		isDir := strings.HasSuffix(name, "/")
+		if isDir {
+			name = name[:len(name)-1]
+		}
+		if path.Clean(name) != name {
+			return fmt.Errorf("invalid file name %s", zf.Name)
+		}
+		if err := module.CheckFilePath(name); err != nil {
+			return err
+		}
+		if err := collisions.check(name, isDir); err != nil {
+			return err
+		}
+		if isDir {
+			continue
+		}
+		if base := path.Base(name); strings.EqualFold(base, "go.mod") {
+			if base != name {
+				return fmt.Errorf("found go.mod file not in module root directory (%s)", zf.Name)
+			} else if name != "go.mod" {
+				return fmt.Errorf("found file named %s, want all lower-case go.mod", zf.Name)
+			}
+		}
+		s := int64(zf.UncompressedSize64)
+		if s < 0 || modzip.MaxZipFile-total < s {
+			return fmt.Errorf("total uncompressed size of module contents too large (max size is %d bytes)", modzip.MaxZipFile)
+		}
+		total += s
+		if name == "go.mod" && s > modzip.MaxGoMod {
+			return fmt.Errorf("go.mod file too large (max size is %d bytes)", modzip.MaxGoMod)
+		}
+		if name == "LICENSE" && s > modzip.MaxLICENSE {
+			return fmt.Errorf("LICENSE file too large (max size is %d bytes)", modzip.MaxLICENSE)
+		}
+	}
+	return nil
+}
+
+// A collisionChecker finds case-insensitive name collisions and paths
+// that are listed as both files and directories, as in modzip.Unzip.
+// It maps the str.ToFold form of each path to the path and whether it
+// is a directory.
+type collisionChecker map[string]collisionEntry
+
+type collisionEntry struct {
+	path  string
+	isDir bool
+}
+
+func (cc collisionChecker) check(p string, isDir bool) error {
+	fold := str.ToFold(p)
+	if other, ok := cc[fold]; ok {
+		if p != other.path {
+			return fmt.Errorf("case-insensitive file name collision: %q and %q", other.path, p)
+		}
+		if isDir != other.isDir {
+			return fmt.Errorf("entry %q is both a file and a directory", p)
+		}
+		if !isDir {
+			return fmt.Errorf("multiple entries for file %q", p)
+		}
+		// A directory is checked again for each file in it.
+	} else {
+		cc[fold] = collisionEntry{path: p, isDir: isDir}
+	}
+
+	if parent := path.Dir(p); parent != "." {
+		return cc.check(parent, true)
+	}
+	return nil
+}
+
+// extractFile copies the file zf in a zip file to dst through buf.
+func extractFile(dst string, zf *zip.File, buf []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return err
+	}
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	r, err := zf.Open()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	lr := &io.LimitedReader{R: r, N: int64(zf.UncompressedSize64) + 1}
+	// Hide any ReadFrom method of w, so that the copy uses buf.
+	_, err = io.CopyBuffer(struct{ io.Writer }{w}, lr, buf)
+	r.Close()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if lr.N <= 0 {
+		return fmt.Errorf("uncompressed size of file %s is larger than declared size (%d bytes)", zf.Name, zf.UncompressedSize64)
+	}
+	return nil
+}
+
+// zipHash returns the hash of the zip file for mod, as recorded in the
+// module cache when zipfile was downloaded, or computed from zipfile if
+// none was recorded.
+func zipHash(mod module.Version, zipfile string) (string, error) {
+	if file, err := CachePath(mod, "ziphash"); err == nil {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			if h := strings.TrimSpace(string(data)); h != "" {
+				return h, nil
+			}
+		}
+	}
+	return dirhash.HashZip(zipfile, dirhash.DefaultHash)
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+// writeTestZip writes a zip file holding the named files to file.
+func writeTestZip(t *testing.T, file string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnzipBuffered(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "go-unzipBuffered-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveAll(tmpdir)
+	defer func(pkgMod string) { PkgMod = pkgMod }(PkgMod)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+
+	mod := module.Version{Path: "example.com/m", Version: "v1.0.0"}
+	prefix := mod.String() + "/"
+	files := map[string]string{
+		"go.mod":      "module example.com/m\n",
+		"p/p.go":      "package p\n",
+		"p/large.bin": strings.Repeat("0123456789", 10000),
+	}
+	zipped := make(map[string]string)
+	for name, data := range files {
+		zipped[prefix+name] = data
+	}
+	zipfile := filepath.Join(tmpdir, "m.zip")
+	writeTestZip(t, zipfile, zipped)
+
+	dir := filepath.Join(tmpdir, "dir")
+	if err := unzipBuffered(dir, mod, zipfile, 7); err != nil {
+		t.Fatalf("unzipBuffered: %v", err)
+	}
+	for name, want := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("reading %s: %v", name, err)
+		} else if string(data) != want {
+			t.Errorf("%s has %d bytes of wrong content, want %d bytes", name, len(data), len(want))
+		}
+	}
+
+	// The extracted files must match the hash recorded for the zip file.
+	ziphash, err := CachePath(mod, "ziphash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(ziphash), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(ziphash, []byte("h1:BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB=\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dir = filepath.Join(tmpdir, "dir2")
+	err = unzipBuffered(dir, mod, zipfile, 7)
+	if err == nil || !strings.Contains(err.Error(), "do not match") {
+		t.Errorf("unzipBuffered with wrong ziphash: %v, want checksum error", err)
+	}
+
+	// Files outside the module directory are rejected before anything is written.
+	bad := filepath.Join(tmpdir, "bad.zip")
+	writeTestZip(t, bad, map[string]string{prefix + "../escape.go": "package escape\n"})
+	dir = filepath.Join(tmpdir, "dir3")
+	if err := unzipBuffered(dir, mod, bad, 7); err == nil {
+		t.Errorf("unzipBuffered of zip file with ../escape.go succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "escape.go")); err == nil {
+		t.Errorf("unzipBuffered wrote file outside target directory")
+	}
+}
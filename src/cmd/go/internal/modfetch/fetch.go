@@ -7,6 +7,7 @@ package modfetch
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"cmd/go/internal/base"
 	"cmd/go/internal/cfg"
@@ -26,7 +28,7 @@ import (
 
 	"golang.org/x/mod/module"
 	"golang.org/x/mod/sumdb/dirhash"
-	modzip "golang.org/x/mod/zip"
+	"golang.org/x/mod/sumdb/tlog"
 )
 
 var downloadCache par.Cache
@@ -34,7 +36,22 @@ var downloadCache par.Cache
 // Download downloads the specific module version to the
 // local download cache and returns the name of the directory
 // corresponding to the root of the module's file tree.
+//
+// The directory is extracted to a temporary location and renamed into
+// place, so concurrent readers, including other processes sharing the
+// module cache, never see a partially extracted directory as complete
+// (see DownloadDir).
+//
+// Deprecated: Use DownloadWithContext instead.
 func Download(mod module.Version) (dir string, err error) {
+	return DownloadWithContext(context.Background(), mod)
+}
+
+// DownloadWithContext is like Download, but if the module's zip file must be
+// fetched and ctx is done before it has been, the fetch is abandoned and
+// DownloadWithContext returns an error that wraps ctx.Err(). Once the zip
+// file has been fetched, its extraction is not canceled.
+func DownloadWithContext(ctx context.Context, mod module.Version) (dir string, err error) {
 	if PkgMod == "" {
 		// Do not download to current directory.
 		return "", fmt.Errorf("missing modfetch.PkgMod")
@@ -46,17 +63,64 @@ func Download(mod module.Version) (dir string, err error) {
 		err error
 	}
 	c := downloadCache.Do(mod, func() interface{} {
-		dir, err := download(mod)
+		dir, err := download(ctx, mod)
 		if err != nil {
 			return cached{"", err}
 		}
-		checkMod(mod)
+		checkMod(ctx, mod)
 		return cached{dir, nil}
 	}).(cached)
+	if IsTransient(c.err) || isContextErr(c.err) {
+		// Allow a later call to try again.
+		downloadCache.Delete(mod)
+	}
 	return c.dir, c.err
 }
 
-func download(mod module.Version) (dir string, err error) {
+// CachedDir returns the directory in the module cache holding the
+// extracted file tree of the specific module version, and reports whether
+// that directory is present and completely extracted. Unlike Download,
+// CachedDir never fetches a missing module: it examines only the local
+// file system.
+//
+// If go.sum lists a checksum for the module, CachedDir also reports false
+// when the checksum recorded in the module cache does not match it.
+// Because it must not use the network, CachedDir does not consult the
+// checksum database.
+func CachedDir(mod module.Version) (dir string, ok bool) {
+	if PkgMod == "" {
+		return "", false
+	}
+	dir, err := DownloadDir(mod)
+	if dir == "" {
+		return "", false
+	}
+	if err != nil {
+		return dir, false
+	}
+	h := Sum(mod)
+	if !strings.HasPrefix(h, "h1:") {
+		return dir, false
+	}
+
+	goSum.mu.Lock()
+	defer goSum.mu.Unlock()
+	if inited, err := initGoSum(); err != nil {
+		return dir, false
+	} else if inited {
+		for _, vh := range goSum.m[mod] {
+			if vh == h {
+				return dir, true
+			}
+			if strings.HasPrefix(vh, "h1:") {
+				return dir, false
+			}
+		}
+	}
+	return dir, true
+}
+
+func download(ctx context.Context, mod module.Version) (dir string, err error) {
 	// If the directory exists, and no .partial file exists, the module has
 	// already been completely extracted. .partial files may be created when a
 	// module zip directory is extracted in place instead of being extracted to a
@@ -71,12 +135,12 @@ func download(mod module.Version) (dir string, err error) {
 	// To avoid cluttering the cache with extraneous files,
 	// DownloadZip uses the same lockfile as Download.
 	// Invoke DownloadZip before locking the file.
-	zipfile, err := DownloadZip(mod)
+	zipfile, err := DownloadZipWithContext(ctx, mod)
 	if err != nil {
 		return "", err
 	}
 
-	unlock, err := lockVersion(mod)
+	unlock, err := lockVersion(ctx, mod)
 	if err != nil {
 		return "", err
 	}
@@ -137,15 +201,22 @@ func download(mod module.Version) (dir string, err error) {
 	// assume a partially extracted directory is complete.
 	// TODO(golang.org/issue/36568): when these older versions are no longer
 	// supported, remove the old default behavior and the unzipInPlace flag.
-	if err := os.MkdirAll(parentDir, 0777); err != nil {
+	mode := options(ctx).CacheMode
+	if err := mkdirAll(parentDir, mode); err != nil {
 		return "", err
 	}
 
+	start := time.Now()
 	if unzipInPlace {
+		// In the sharded layout, the .partial file may be the first file
+		// in its directory: the .zip may be in the unsharded layout.
+		if err := mkdirAll(filepath.Dir(partialPath), mode); err != nil {
+			return "", err
+		}
 		if err := ioutil.WriteFile(partialPath, nil, 0666); err != nil {
 			return "", err
 		}
-		if err := modzip.Unzip(dir, mod, zipfile); err != nil {
+		if err := unzip(dir, mod, zipfile, options(ctx).ExtractBuffer); err != nil {
 			fmt.Fprintf(os.Stderr, "-> %s\n", err)
 			if rmErr := RemoveAll(dir); rmErr == nil {
 				os.Remove(partialPath)
@@ -160,18 +231,30 @@ func download(mod module.Version) (dir string, err error) {
 		if err != nil {
 			return "", err
 		}
-		if err := modzip.Unzip(tmpDir, mod, zipfile); err != nil {
+		if err := unzip(tmpDir, mod, zipfile, options(ctx).ExtractBuffer); err != nil {
 			fmt.Fprintf(os.Stderr, "-> %s\n", err)
 			RemoveAll(tmpDir)
 			return "", err
 		}
 		if err := robustio.Rename(tmpDir, dir); err != nil {
 			RemoveAll(tmpDir)
+			// The lock file does not exclude every writer: file locks may not
+			// work on a network file system, and older versions of cmd/go may
+			// share the cache. If another writer finished the directory first,
+			// its copy is as good as ours.
+			if _, dirErr := DownloadDir(mod); dirErr == nil {
+				return dir, nil
+			}
 			return "", err
 		}
 	}
+	tracePhase(mod, "extract", start)
 
-	if !cfg.ModCacheRW {
+	if mode != 0 {
+		if err := setTreeCacheMode(dir, mode); err != nil {
+			return "", err
+		}
+	} else if !cfg.ModCacheRW {
 		// Make dir read-only only *after* renaming it.
 		// os.Rename was observed to fail for read-only directories on macOS.
 		makeDirsReadOnly(dir)
@@ -194,57 +277,133 @@ var downloadZipCache par.Cache
 
 // DownloadZip downloads the specific module version to the
 // local zip cache and returns the name of the zip file.
+//
+// Deprecated: Use DownloadZipWithContext instead.
 func DownloadZip(mod module.Version) (zipfile string, err error) {
+	return DownloadZipWithContext(context.Background(), mod)
+}
+
+// DownloadZipWithContext is like DownloadZip, but if ctx is done before the
+// zip file has been fetched, the fetch is abandoned and DownloadZipWithContext
+// returns an error that wraps ctx.Err(), as with DownloadZipCached.
+func DownloadZipWithContext(ctx context.Context, mod module.Version) (zipfile string, err error) {
+	zipfile, _, err = DownloadZipCached(ctx, mod)
+	return zipfile, err
+}
+
+// DownloadZipCached is like DownloadZipWithContext, but it also reports whether
+// the zip file was already present in the module cache, as opposed to
+// being fetched by this call. Concurrent calls for the same module share
+// a single fetch and report the same result. If another process fetches
+// the zip file while DownloadZipCached waits for it, the zip file is
+// reported as cached.
+//
+// If ctx is done before the zip file has been fetched, the fetch is
+// abandoned and DownloadZipCached returns an error that wraps ctx.Err().
+// Because concurrent calls share the fetch, such an error may be reported
+// to them as well; a later call starts a new fetch.
+func DownloadZipCached(ctx context.Context, mod module.Version) (zipfile string, cached bool, err error) {
 	// The par.Cache here avoids duplicate work.
-	type cached struct {
+	type cachedZip struct {
 		zipfile string
+		cached  bool
 		err     error
 	}
 	c := downloadZipCache.Do(mod, func() interface{} {
 		zipfile, err := CachePath(mod, "zip")
 		if err != nil {
-			return cached{"", err}
+			return cachedZip{"", false, err}
 		}
 
 		// Skip locking if the zipfile already exists.
 		if _, err := os.Stat(zipfile); err == nil {
-			return cached{zipfile, nil}
+			return cachedZip{zipfile, true, nil}
 		}
 
 		// The zip file does not exist. Acquire the lock and create it.
 		if cfg.CmdName != "mod download" {
 			fmt.Fprintf(os.Stderr, "go: downloading %s %s\n", mod.Path, mod.Version)
 		}
-		unlock, err := lockVersion(mod)
+		unlock, err := lockVersion(ctx, mod)
 		if err != nil {
-			return cached{"", err}
+			return cachedZip{"", false, err}
 		}
 		defer unlock()
 
 		// Double-check that the zipfile was not created while we were waiting for
 		// the lock.
 		if _, err := os.Stat(zipfile); err == nil {
-			return cached{zipfile, nil}
+			return cachedZip{zipfile, true, nil}
 		}
-		if err := os.MkdirAll(filepath.Dir(zipfile), 0777); err != nil {
-			return cached{"", err}
+		if err := mkdirAll(filepath.Dir(zipfile), options(ctx).CacheMode); err != nil {
+			return cachedZip{"", false, err}
 		}
-		if err := downloadZip(mod, zipfile); err != nil {
-			return cached{"", err}
+		if err := downloadZip(ctx, mod, zipfile); err != nil {
+			return cachedZip{"", false, err}
 		}
-		return cached{zipfile, nil}
-	}).(cached)
-	return c.zipfile, c.err
+		return cachedZip{zipfile, false, nil}
+	}).(cachedZip)
+	if IsTransient(c.err) {
+		// Allow a later call to try again.
+		downloadZipCache.Delete(mod)
+	}
+	return c.zipfile, c.cached, c.err
+}
+
+// DownloadZipTo downloads the specific module version, if necessary,
+// and copies its zip file to w, returning the checksum of the zip file
+// (as in go.sum).
+//
+// The zip file is hashed and checked against go.sum (and, if appropriate,
+// the checksum database) before any of it is written to w, even if it was
+// already present in the module cache.
+func DownloadZipTo(mod module.Version, w io.Writer) (sum string, err error) {
+	zipfile, err := DownloadZipWithContext(context.Background(), mod)
+	if err != nil {
+		return "", err
+	}
+	sum, err = dirhash.HashZip(zipfile, dirhash.DefaultHash)
+	if err != nil {
+		return "", module.VersionError(mod, err)
+	}
+	if err := checkModSum(context.Background(), mod, sum); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(zipfile)
+	if err != nil {
+		return "", module.VersionError(mod, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return "", module.VersionError(mod, err)
+	}
+	return sum, nil
 }
 
-func downloadZip(mod module.Version, zipfile string) (err error) {
+// Resume, if set, causes downloadZip to keep the temporary file left behind
+// by an interrupted download and to ask the proxy for only the rest of the
+// zip file, rather than starting over.
+//
+// Resumed data is validated against go.sum and the checksum database like
+// any other download; if it turns out to be corrupt, the zip file is fetched
+// again from scratch.
+var Resume bool
+
+func downloadZip(ctx context.Context, mod module.Version, zipfile string) (err error) {
 	// Clean up any remaining tempfiles from previous runs.
 	// This is only safe to do because the lock file ensures that their
 	// writers are no longer active.
+	var partial string
+	if Resume {
+		partial = resumableZip(zipfile)
+	}
 	for _, base := range []string{zipfile, zipfile + "hash"} {
 		if old, err := filepath.Glob(renameio.Pattern(base)); err == nil {
 			for _, path := range old {
-				os.Remove(path) // best effort
+				if path != partial {
+					os.Remove(path) // best effort
+				}
 			}
 		}
 	}
@@ -254,27 +413,102 @@ func downloadZip(mod module.Version, zipfile string) (err error) {
 	// contents of the file (by hashing it) before we commit it. Because the file
 	// is zip-compressed, we need an actual file — or at least an io.ReaderAt — to
 	// validate it: we can't just tee the stream as we write it.
-	f, err := ioutil.TempFile(filepath.Dir(zipfile), filepath.Base(renameio.Pattern(zipfile)))
-	if err != nil {
-		return err
+	var f *os.File
+	var offset int64
+	if partial != "" {
+		f, offset, err = openPartialZip(partial)
+		if err != nil {
+			os.Remove(partial) // best effort
+		}
+	}
+	if f == nil {
+		f, err = ioutil.TempFile(filepath.Dir(zipfile), filepath.Base(renameio.Pattern(zipfile)))
+		if err != nil {
+			return err
+		}
 	}
+	keep := false
 	defer func() {
 		if err != nil {
 			f.Close()
-			os.Remove(f.Name())
+			if !keep {
+				os.Remove(f.Name())
+			}
 		}
 	}()
 
-	err = TryProxies(func(proxy string) error {
-		repo, err := Lookup(proxy, mod.Path)
+	reportProgress(ProgressEvent{Kind: ProgressStart, Mod: mod, Total: -1})
+	start := time.Now()
+	pw := &progressWriter{w: f, mod: mod, n: offset, total: -1}
+	var served string
+	resumed := false
+	stored := false
+	if pw.n == 0 {
+		stored, err = readStore(ctx, zipfile, pw)
 		if err != nil {
-			return err
+			// Fall back to the proxies.
+			stored = false
+			err = truncateZip(f, pw)
 		}
-		return repo.Zip(f, mod.Version)
-	})
+	}
+	if err == nil && !stored {
+		err = TryProxies(func(proxy string) error {
+			repo, err := lookupContext(ctx, proxy, mod.Path)
+			if err != nil {
+				return err
+			}
+			if Resume && pw.n > 0 {
+				ok, err := resumeZip(ctx, repo, pw, mod.Version, pw.n)
+				resumed = resumed || ok
+				if err != nil {
+					return err
+				}
+				if ok {
+					served = proxy
+					return nil
+				}
+				if err := truncateZip(f, pw); err != nil {
+					return err
+				}
+			}
+			if err := repo.Zip(ctx, pw, mod.Version); err != nil {
+				return err
+			}
+			served = proxy
+			return nil
+		})
+	}
+	if err != nil && Resume && pw.n > 0 {
+		// Keep what we have so that a later run can pick up where we left off.
+		keep = true
+	}
+	if err == nil && resumed {
+		// The start of the file came from an earlier run. If it does not
+		// match the checksum we expect, it was corrupt: start over.
+		if !resumedZipOK(mod, f) {
+			if err = truncateZip(f, pw); err == nil {
+				err = TryProxies(func(proxy string) error {
+					repo, err := lookupContext(ctx, proxy, mod.Path)
+					if err != nil {
+						return err
+					}
+					if err := repo.Zip(ctx, pw, mod.Version); err != nil {
+						return err
+					}
+					served = proxy
+					return nil
+				})
+			}
+		}
+	}
+	reportProgress(ProgressEvent{Kind: ProgressDone, Mod: mod, Bytes: pw.n, Total: pw.total, Err: err})
+	if !stored {
+		observeFetch(FetchZip, mod, pw.received, start, err)
+	}
 	if err != nil {
 		return err
 	}
+	keep = false
 
 	// Double-check that the paths within the zip file are well-formed.
 	//
@@ -287,11 +521,8 @@ func downloadZip(mod module.Version, zipfile string) (err error) {
 	if err != nil {
 		return err
 	}
-	prefix := mod.Path + "@" + mod.Version + "/"
-	for _, f := range z.File {
-		if !strings.HasPrefix(f.Name, prefix) {
-			return fmt.Errorf("zip for %s has unexpected file %s", prefix[:len(prefix)-1], f.Name)
-		}
+	if err := checkZipPaths(mod, z); err != nil {
+		return err
 	}
 
 	// Sync the file before renaming it: otherwise, after a crash the reader may
@@ -305,26 +536,295 @@ func downloadZip(mod module.Version, zipfile string) (err error) {
 	}
 
 	// Hash the zip file and check the sum before renaming to the final location.
+	verifyStart := time.Now()
 	hash, err := dirhash.HashZip(f.Name(), dirhash.DefaultHash)
 	if err != nil {
 		return err
 	}
-	if err := checkModSum(mod, hash); err != nil {
+	if err := checkModSum(ctx, mod, hash); err != nil {
 		return err
 	}
+	tracePhase(mod, "verify", verifyStart)
 
 	if err := renameio.WriteFile(zipfile+"hash", []byte(hash), 0666); err != nil {
 		return err
 	}
+	mode := options(ctx).CacheMode
+	if err := setCacheMode(zipfile+"hash", mode); err != nil {
+		return err
+	}
+	if err := setCacheMode(f.Name(), mode); err != nil {
+		return err
+	}
 	if err := os.Rename(f.Name(), zipfile); err != nil {
 		return err
 	}
+	if stored {
+		// The zip file came from the cache store, not from a proxy.
+		return nil
+	}
+	recordOrigin(mod, served)
+	putStore(ctx, zipfile)
 
 	// TODO(bcmills): Should we make the .zip and .ziphash files read-only to discourage tampering?
 
 	return nil
 }
 
+// checkZipPaths checks that every file in z is within the directory
+// for mod.
+func checkZipPaths(mod module.Version, z *zip.Reader) error {
+	prefix := mod.Path + "@" + mod.Version + "/"
+	for _, f := range z.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			return fmt.Errorf("zip for %s has unexpected file %s", prefix[:len(prefix)-1], f.Name)
+		}
+	}
+	return nil
+}
+
+// VerifyZip checks that the file at path is a zip file for mod
+// with the checksum wantSum (as in go.sum), without adding it to
+// the module cache. It is useful for checking a zip file obtained
+// some other way before trusting it.
+func VerifyZip(path string, mod module.Version, wantSum string) error {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return module.VersionError(mod, err)
+	}
+	err = checkZipPaths(mod, &z.Reader)
+	z.Close()
+	if err != nil {
+		return module.VersionError(mod, err)
+	}
+	h, err := dirhash.HashZip(path, dirhash.DefaultHash)
+	if err != nil {
+		return module.VersionError(mod, err)
+	}
+	if h != wantSum {
+		return module.VersionError(mod, fmt.Errorf("%w\n\tzip file: %v\n\twant:     %v", ErrChecksumMismatch, h, wantSum))
+	}
+	return nil
+}
+
+// LookupSum returns the checksum expected for the zip file of mod:
+// the one listed in go.sum, if any, or else the one in the checksum
+// database, if it is in use for mod.
+func LookupSum(mod module.Version) (string, error) {
+	goSum.mu.Lock()
+	inited, err := initGoSum()
+	if err == nil && inited {
+		for _, h := range goSum.m[mod] {
+			if strings.HasPrefix(h, "h1:") {
+				goSum.mu.Unlock()
+				return h, nil
+			}
+		}
+	}
+	goSum.mu.Unlock()
+
+	if !useSumDB(mod) {
+		return "", module.VersionError(mod, errors.New("no checksum listed in go.sum, and the checksum database is not in use"))
+	}
+	h, err := sumDBHash(mod)
+	if err != nil {
+		return "", module.VersionError(mod, fmt.Errorf("verifying module: %v", err))
+	}
+	if h == "" {
+		return "", module.VersionError(mod, errors.New("no checksum found in checksum database"))
+	}
+	return h, nil
+}
+
+// CacheSumDB looks up mod in the Go checksum database, whether or not go.sum
+// lists its checksums, so that the lookup result and the tiles proving it
+// are stored in the module cache. A later go command can then check mod
+// against the checksum database without network access, for example with
+// GOPROXY=off. CacheSumDB returns the name of the database and the cached
+// lookup file.
+func CacheSumDB(mod module.Version) (dbname, file string, err error) {
+	if !useSumDB(mod) {
+		return "", "", module.VersionError(mod, errors.New("the checksum database is not in use"))
+	}
+	dbname, _, err = lookupSumDB(mod)
+	if err != nil {
+		return "", "", module.VersionError(mod, fmt.Errorf("verifying module: %v", err))
+	}
+	epath, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return "", "", err
+	}
+	evers, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return "", "", err
+	}
+	file = filepath.Join(PkgMod, "cache/download/sumdb", dbname, "lookup", epath+"@"+evers)
+	return dbname, file, nil
+}
+
+// A SumDBTreeHead is a signed tree head of a Go checksum database: the size
+// and hash of the database's transparency log at some point in time, signed
+// by the database.
+type SumDBTreeHead struct {
+	Name string // database name, such as "sum.golang.org"
+	Size int64  // number of records in the log
+	Hash string // base64-encoded hash of the log
+	Note string // signed note, as served by the database
+}
+
+var treeHeads struct {
+	mu sync.Mutex
+	m  map[string]SumDBTreeHead
+}
+
+// recordTreeHead records the signed tree head note data read or written
+// for the named checksum database. Notes that are not well-formed tree
+// heads are ignored.
+func recordTreeHead(name string, data []byte) {
+	tree, err := tlog.ParseTree(data)
+	if err != nil {
+		return
+	}
+	treeHeads.mu.Lock()
+	defer treeHeads.mu.Unlock()
+	if old, ok := treeHeads.m[name]; ok && old.Size > tree.N {
+		return
+	}
+	if treeHeads.m == nil {
+		treeHeads.m = make(map[string]SumDBTreeHead)
+	}
+	treeHeads.m[name] = SumDBTreeHead{Name: name, Size: tree.N, Hash: tree.Hash.String(), Note: string(data)}
+}
+
+// SumDBTreeHeads returns the latest signed tree head the go command has
+// seen for each checksum database it consulted, sorted by name. Checksums
+// verified against a database are proven to be in the log described by
+// its tree head.
+func SumDBTreeHeads() []SumDBTreeHead {
+	treeHeads.mu.Lock()
+	defer treeHeads.mu.Unlock()
+	list := []SumDBTreeHead{}
+	for _, th := range treeHeads.m {
+		list = append(list, th)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// sumDBHash returns the h1: checksum that the checksum database lists
+// for mod, or "" if it lists none.
+func sumDBHash(mod module.Version) (string, error) {
+	_, lines, err := lookupSumDB(mod)
+	if err != nil {
+		return "", err
+	}
+	prefix := mod.Path + " " + mod.Version + " h1:"
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			return line[len(prefix)-len("h1:"):], nil
+		}
+	}
+	return "", nil
+}
+
+// resumableZip returns the largest temporary file left behind by an earlier
+// attempt to download zipfile, or "" if there is none.
+func resumableZip(zipfile string) string {
+	old, err := filepath.Glob(renameio.Pattern(zipfile) + "*")
+	if err != nil {
+		return ""
+	}
+	var best string
+	var bestSize int64
+	for _, path := range old {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if fi.Size() > bestSize {
+			best, bestSize = path, fi.Size()
+		}
+	}
+	for _, path := range old {
+		if path != best {
+			os.Remove(path) // best effort
+		}
+	}
+	return best
+}
+
+// openPartialZip opens the partial zip file at path for appending
+// and returns its current size.
+func openPartialZip(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, offset, nil
+}
+
+// truncateZip discards everything written to f so far,
+// so that the download can start over.
+func truncateZip(f *os.File, pw *progressWriter) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	pw.n = 0
+	pw.total = -1
+	return nil
+}
+
+// resumedZipOK reports whether the resumed zip file f looks intact:
+// it must be a well-formed zip file, and its hash must not conflict with
+// go.sum or the checksum database.
+//
+// Unlike checkModSum, resumedZipOK never calls base.Fatalf:
+// a mismatch only means that the partial file was corrupt.
+func resumedZipOK(mod module.Version, f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	if _, err := zip.NewReader(f, fi.Size()); err != nil {
+		return false
+	}
+	hash, err := dirhash.HashZip(f.Name(), dirhash.DefaultHash)
+	if err != nil {
+		return false
+	}
+
+	goSum.mu.Lock()
+	inited, err := initGoSum()
+	if err == nil && inited {
+		for _, vh := range goSum.m[mod] {
+			if vh == hash {
+				goSum.mu.Unlock()
+				return true
+			}
+			if strings.HasPrefix(vh, "h1:") {
+				goSum.mu.Unlock()
+				return false
+			}
+		}
+	}
+	goSum.mu.Unlock()
+
+	if useSumDB(mod) {
+		if want, err := sumDBHash(mod); err == nil && want != "" && want != hash {
+			return false
+		}
+	}
+	return true
+}
+
 // makeDirsReadOnly makes a best-effort attempt to remove write permissions for dir
 // and its transitive contents.
 func makeDirsReadOnly(dir string) {
@@ -364,6 +864,193 @@ func RemoveAll(dir string) error {
 	return robustio.RemoveAll(dir)
 }
 
+// PurgeVersion removes the specific module version from the module cache:
+// its extracted directory and its .zip, .ziphash, .mod, and .info files.
+// Other versions of the same module are left intact.
+//
+// PurgeVersion holds the same lock as Download and DownloadZip, so it does
+// not race with a concurrent download of the version. It removes the files
+// in the reverse of the order in which they are written, moving the directory
+// aside before removing it, so if PurgeVersion fails partway the cache is
+// left as though the version were only partly downloaded, and calling
+// PurgeVersion again completes the removal.
+func PurgeVersion(mod module.Version) error {
+	if PkgMod == "" {
+		return fmt.Errorf("missing modfetch.PkgMod")
+	}
+	dir, err := DownloadDir(mod)
+	if dir == "" {
+		return module.VersionError(mod, err)
+	}
+
+	unlock, err := lockVersion(context.Background(), mod)
+	if err != nil {
+		return module.VersionError(mod, err)
+	}
+	defer unlock()
+
+	// Rename the directory before removing its contents, so that it is never
+	// observed in a partially removed state. download removes leftover
+	// directories with this prefix if the removal fails.
+	if _, err := os.Stat(dir); err == nil {
+		tmp := dir + ".tmp-purge"
+		if err := RemoveAll(tmp); err != nil {
+			return module.VersionError(mod, err)
+		}
+		if err := robustio.Rename(dir, tmp); err != nil {
+			return module.VersionError(mod, err)
+		}
+		if err := RemoveAll(tmp); err != nil {
+			return module.VersionError(mod, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return module.VersionError(mod, err)
+	}
+
+	for _, suffix := range []string{"partial", "zip", "ziphash", "mod", "info"} {
+		file, err := CachePath(mod, suffix)
+		if err != nil {
+			return module.VersionError(mod, err)
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return module.VersionError(mod, err)
+		}
+	}
+
+	// Allow a later call in this process to download the version again.
+	downloadCache.Delete(mod)
+	downloadZipCache.Delete(mod)
+	return nil
+}
+
+// CleanTemp removes the temporary files and directories that interrupted
+// downloads of mod may have left in the module cache, and returns the names
+// of those it removed. These are files that were being written but were never
+// renamed into place, including partial zip files kept by Resume; directories
+// left behind by an interrupted extraction or PurgeVersion; and a partially
+// extracted directory, along with its .partial file. CleanTemp never removes
+// a complete cached file or directory.
+//
+// Lock files are left in place. They are empty, and removing one while
+// another go command waits on it would let two commands write the same
+// version at once.
+//
+// Like PurgeVersion, CleanTemp holds the same lock as Download and
+// DownloadZip, so it does not remove the files of a download in progress.
+func CleanTemp(mod module.Version) (removed []string, err error) {
+	if PkgMod == "" {
+		return nil, fmt.Errorf("missing modfetch.PkgMod")
+	}
+	dir, err := DownloadDir(mod)
+	if dir == "" {
+		return nil, module.VersionError(mod, err)
+	}
+	dirs, err := cacheDirs(mod.Path)
+	if err != nil {
+		return nil, module.VersionError(mod, err)
+	}
+	evers, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return nil, module.VersionError(mod, err)
+	}
+
+	unlock, err := lockVersion(context.Background(), mod)
+	if err != nil {
+		return nil, module.VersionError(mod, err)
+	}
+	defer unlock()
+
+	remove := func(path string, isDir bool) error {
+		var err error
+		if isDir {
+			err = RemoveAll(path)
+		} else {
+			err = os.Remove(path)
+		}
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return module.VersionError(mod, err)
+		}
+		removed = append(removed, path)
+		return nil
+	}
+
+	old, _ := filepath.Glob(filepath.Join(filepath.Dir(dir), filepath.Base(dir)+".tmp-*"))
+	for _, path := range old {
+		if err := remove(path, true); err != nil {
+			return removed, err
+		}
+	}
+	// Remove a partially extracted directory before the .partial file
+	// that marks it as partial, as download does.
+	if _, err := DownloadDir(mod); err != nil {
+		if _, ok := err.(*DownloadDirPartialError); ok {
+			if err := remove(dir, true); err != nil {
+				return removed, err
+			}
+		}
+	}
+	for _, d := range dirs {
+		files, err := ioutil.ReadDir(d)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, module.VersionError(mod, err)
+		}
+		for _, f := range files {
+			if name := f.Name(); name == evers+".partial" || isTempName(name, evers) {
+				if err := remove(filepath.Join(d, name), false); err != nil {
+					return removed, err
+				}
+			}
+		}
+	}
+
+	// Allow a later call in this process to download the version again.
+	downloadCache.Delete(mod)
+	downloadZipCache.Delete(mod)
+	return removed, nil
+}
+
+// isTempName reports whether name is that of a temporary file written
+// for the cache file of the version with escaped name evers: either
+// v1.0.0.zip.tmp123, created by downloadZip, or v1.0.0.info123.tmp,
+// created by renameio.
+func isTempName(name, evers string) bool {
+	rest := strings.TrimPrefix(name, evers+".")
+	if rest == name {
+		return false
+	}
+	for _, suffix := range []string{"info", "mod", "zip", "ziphash"} {
+		if !strings.HasPrefix(rest, suffix) {
+			continue
+		}
+		tmp := rest[len(suffix):]
+		if strings.HasPrefix(tmp, ".tmp") && isDigits(tmp[len(".tmp"):]) {
+			return true
+		}
+		if strings.HasSuffix(tmp, ".tmp") && isDigits(tmp[:len(tmp)-len(".tmp")]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 var GoSumFile string // path to go.sum; set by package modload
 
 type modSum struct {
@@ -375,6 +1062,7 @@ var goSum struct {
 	mu        sync.Mutex
 	m         map[module.Version][]string // content of go.sum file (+ go.modverify if present)
 	checked   map[modSum]bool             // sums actually checked during execution
+	listed    map[module.Version][]string // content of go.sum file when it was first read
 	dirty     bool                        // whether we added any new sums to m
 	overwrite bool                        // if true, overwrite go.sum without incorporating its contents
 	enabled   bool                        // whether to use go.sum at all
@@ -401,6 +1089,10 @@ func initGoSum() (bool, error) {
 	}
 	goSum.enabled = true
 	readGoSum(goSum.m, GoSumFile, data)
+	goSum.listed = make(map[module.Version][]string, len(goSum.m))
+	for mod, sums := range goSum.m {
+		goSum.listed[mod] = append([]string(nil), sums...)
+	}
 
 	// Add old go.modverify file.
 	// We'll delete go.modverify in WriteGoSum.
@@ -410,7 +1102,7 @@ func initGoSum() (bool, error) {
 		readGoSum(migrate, alt, data)
 		for mod, sums := range migrate {
 			for _, sum := range sums {
-				addModSumLocked(mod, sum)
+				addModSumLocked(mod, sum, options(context.Background()).ReportMismatches)
 			}
 		}
 		goSum.modverify = alt
@@ -455,7 +1147,7 @@ func readGoSum(dst map[module.Version][]string, file string, data []byte) error
 }
 
 // checkMod checks the given module's checksum.
-func checkMod(mod module.Version) {
+func checkMod(ctx context.Context, mod module.Version) {
 	if PkgMod == "" {
 		// Do not use current directory.
 		return
@@ -479,7 +1171,7 @@ func checkMod(mod module.Version) {
 		base.Fatalf("verifying %v", module.VersionError(mod, fmt.Errorf("unexpected ziphash: %q", h)))
 	}
 
-	if err := checkModSum(mod, h); err != nil {
+	if err := checkModSum(ctx, mod, h); err != nil {
 		base.Fatalf("%s", err)
 	}
 }
@@ -493,17 +1185,18 @@ func goModSum(data []byte) (string, error) {
 
 // checkGoMod checks the given module's go.mod checksum;
 // data is the go.mod content.
-func checkGoMod(path, version string, data []byte) error {
+func checkGoMod(ctx context.Context, path, version string, data []byte) error {
 	h, err := goModSum(data)
 	if err != nil {
 		return &module.ModuleError{Path: path, Version: version, Err: fmt.Errorf("verifying go.mod: %v", err)}
 	}
 
-	return checkModSum(module.Version{Path: path, Version: version + "/go.mod"}, h)
+	return checkModSum(ctx, module.Version{Path: path, Version: version + "/go.mod"}, h)
 }
 
-// checkModSum checks that the recorded checksum for mod is h.
-func checkModSum(mod module.Version, h string) error {
+// checkModSum checks that the recorded checksum for mod is h,
+// following the DownloadOptions in effect for ctx.
+func checkModSum(ctx context.Context, mod module.Version, h string) error {
 	// We lock goSum when manipulating it,
 	// but we arrange to release the lock when calling checkSumDB,
 	// so that parallel calls to checkModHash can execute parallel calls
@@ -515,9 +1208,16 @@ func checkModSum(mod module.Version, h string) error {
 	if err != nil {
 		return err
 	}
-	done := inited && haveModSumLocked(mod, h)
+	report := options(ctx).ReportMismatches
+	done := false
+	if inited {
+		done, err = haveModSumLocked(mod, h, report)
+	}
 	goSum.mu.Unlock()
 
+	if err != nil {
+		return err
+	}
 	if done {
 		return nil
 	}
@@ -534,41 +1234,176 @@ func checkModSum(mod module.Version, h string) error {
 	// Add mod+h to go.sum, if it hasn't appeared already.
 	if inited {
 		goSum.mu.Lock()
-		addModSumLocked(mod, h)
+		addModSumLocked(mod, h, report)
 		goSum.mu.Unlock()
 	}
 	return nil
 }
 
 // haveModSumLocked reports whether the pair mod,h is already listed in go.sum.
-// If it finds a conflicting pair instead, it calls base.Fatalf.
+// If it finds a conflicting pair instead, it calls base.Fatalf, or, if
+// report is set, returns an error wrapping a *MismatchError.
 // goSum.mu must be locked.
-func haveModSumLocked(mod module.Version, h string) bool {
-	goSum.checked[modSum{mod, h}] = true
+//
+// Only a pair that is listed is marked as checked: a pair that is not listed
+// is marked when addModSumLocked adds it, so that WriteGoSum never writes
+// a checksum that failed verification, such as one rejected by the checksum
+// database after go mod download recovered from the error.
+func haveModSumLocked(mod module.Version, h string, report bool) (bool, error) {
 	for _, vh := range goSum.m[mod] {
 		if h == vh {
-			return true
+			goSum.checked[modSum{mod, h}] = true
+			return true, nil
 		}
 		if strings.HasPrefix(vh, "h1:") {
+			reportMismatch(mod, h, vh)
+			if report {
+				return false, fmt.Errorf("verifying %s@%s: %w\n\tdownloaded: %v\n\tgo.sum:     %v"+goSumMismatch, mod.Path, mod.Version, &MismatchError{Mod: mod, Got: h, Want: vh, Source: "go.sum"}, h, vh)
+			}
 			base.Fatalf("verifying %s@%s: checksum mismatch\n\tdownloaded: %v\n\tgo.sum:     %v"+goSumMismatch, mod.Path, mod.Version, h, vh)
 		}
 	}
+	return false, nil
+}
+
+// HaveSum reports whether go.sum lists h as a checksum for mod.
+// It returns false if go.sum is not in use.
+func HaveSum(mod module.Version, h string) bool {
+	goSum.mu.Lock()
+	defer goSum.mu.Unlock()
+	inited, err := initGoSum()
+	if err != nil || !inited {
+		return false
+	}
+	for _, vh := range goSum.m[mod] {
+		if h == vh {
+			return true
+		}
+	}
 	return false
 }
 
+// ListedSums returns the checksums that the go.sum file listed for mod
+// when it was first read, before any checksums were added to it.
+// It returns nil if go.sum is not in use.
+func ListedSums(mod module.Version) []string {
+	goSum.mu.Lock()
+	defer goSum.mu.Unlock()
+	inited, err := initGoSum()
+	if err != nil || !inited {
+		return nil
+	}
+	return goSum.listed[mod]
+}
+
+// A SumResult holds the checksums known for a module version.
+type SumResult struct {
+	Sum         string   // checksum of the zip file in the module cache, as returned by Sum
+	Listed      []string // checksums listed in go.sum for the module, as returned by ListedSums
+	ListedGoMod []string // checksums listed in go.sum for the module's go.mod file
+}
+
+// Sums returns the checksums known for each of mods. It is equivalent to
+// calling Sum and ListedSums for each module and its go.mod file, but it
+// locks the go.sum state only once, so it is cheaper than those calls when
+// many modules are checked concurrently.
+func Sums(mods []module.Version) map[module.Version]SumResult {
+	results := make(map[module.Version]SumResult, len(mods))
+	for _, mod := range mods {
+		results[mod] = SumResult{Sum: Sum(mod)}
+	}
+
+	goSum.mu.Lock()
+	defer goSum.mu.Unlock()
+	inited, err := initGoSum()
+	if err != nil || !inited {
+		return results
+	}
+	for _, mod := range mods {
+		r := results[mod]
+		r.Listed = goSum.listed[mod]
+		r.ListedGoMod = goSum.listed[module.Version{Path: mod.Path, Version: mod.Version + "/go.mod"}]
+		results[mod] = r
+	}
+	return results
+}
+
 // addModSumLocked adds the pair mod,h to go.sum.
+// A conflicting pair is handled as by haveModSumLocked.
 // goSum.mu must be locked.
-func addModSumLocked(mod module.Version, h string) {
-	if haveModSumLocked(mod, h) {
+func addModSumLocked(mod module.Version, h string, report bool) {
+	if ok, err := haveModSumLocked(mod, h, report); ok || err != nil {
 		return
 	}
 	if len(goSum.m[mod]) > 0 {
 		fmt.Fprintf(os.Stderr, "warning: verifying %s@%s: unknown hashes in go.sum: %v; adding %v"+hashVersionMismatch, mod.Path, mod.Version, strings.Join(goSum.m[mod], ", "), h)
 	}
 	goSum.m[mod] = append(goSum.m[mod], h)
+	goSum.checked[modSum{mod, h}] = true
 	goSum.dirty = true
 }
 
+var mismatch struct {
+	mu      sync.Mutex
+	handler func(mod module.Version, got, want string)
+	seen    map[module.Version]bool // module versions already reported
+}
+
+// SetMismatchHandler sets a function to be called when a module downloaded
+// by this process does not match the checksum listed for it in go.sum or
+// the checksum database. The handler is called before the mismatch is
+// reported as an error (or before the go command exits, for a mismatch
+// against go.sum), with the downloaded checksum got and the listed checksum
+// want. For a mismatch in a go.mod file, mod.Version has the suffix
+// "/go.mod", as in go.sum.
+//
+// The handler is called at most once per module version, even if both the
+// zip file and the go.mod file mismatch, and never concurrently with itself.
+// It must not call back into this package. Passing nil removes the handler.
+func SetMismatchHandler(f func(mod module.Version, got, want string)) {
+	mismatch.mu.Lock()
+	defer mismatch.mu.Unlock()
+	mismatch.handler = f
+	mismatch.seen = nil
+}
+
+// reportMismatch calls the handler set by SetMismatchHandler, if any,
+// for the checksum mismatch of mod.
+func reportMismatch(mod module.Version, got, want string) {
+	mismatch.mu.Lock()
+	defer mismatch.mu.Unlock()
+	if mismatch.handler == nil {
+		return
+	}
+	key := module.Version{Path: mod.Path, Version: strings.TrimSuffix(mod.Version, "/go.mod")}
+	if mismatch.seen[key] {
+		return
+	}
+	if mismatch.seen == nil {
+		mismatch.seen = make(map[module.Version]bool)
+	}
+	mismatch.seen[key] = true
+	mismatch.handler(mod, got, want)
+}
+
+// ErrChecksumMismatch is wrapped by the errors returned when a downloaded
+// module does not match the checksum recorded for it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// A MismatchError describes a downloaded file that does not match the
+// checksum recorded for it. Its Error method returns only "checksum mismatch":
+// the errors that wrap it give the details.
+type MismatchError struct {
+	Mod    module.Version // module version, with a "/go.mod" suffix for a go.mod file
+	Got    string         // checksum of the downloaded file
+	Want   string         // checksum recorded for it
+	Source string         // where Want was recorded: "go.sum", "snapshot", "baseline", or the checksum database name
+}
+
+func (e *MismatchError) Error() string { return ErrChecksumMismatch.Error() }
+
+func (e *MismatchError) Is(target error) bool { return target == ErrChecksumMismatch }
+
 // checkSumDB checks the mod, h pair against the Go checksum database.
 // It calls base.Fatalf if the hash is to be rejected.
 func checkSumDB(mod module.Version, h string) error {
@@ -584,7 +1419,10 @@ func checkSumDB(mod module.Version, h string) error {
 			return nil
 		}
 		if strings.HasPrefix(line, prefix) {
-			return module.VersionError(mod, fmt.Errorf("verifying module: checksum mismatch\n\tdownloaded: %v\n\t%s: %v"+sumdbMismatch, h, db, line[len(prefix)-len("h1:"):]))
+			want := line[len(prefix)-len("h1:"):]
+			reportMismatch(mod, h, want)
+			merr := &MismatchError{Mod: mod, Got: h, Want: want, Source: db}
+			return module.VersionError(mod, fmt.Errorf("verifying module: %w\n\tdownloaded: %v\n\t%s: %v"+sumdbMismatch, merr, h, db, want))
 		}
 	}
 	return nil
@@ -610,6 +1448,13 @@ func Sum(mod module.Version) string {
 }
 
 // WriteGoSum writes the go.sum file if it needs to be updated.
+//
+// Checksums verified concurrently, as by DownloadModules, are only added to
+// the in-memory copy of go.sum; WriteGoSum writes them all at once, holding
+// both the goSum lock and a file lock on go.sum, and merges in any checksums
+// added to the file by other go commands in the meantime. Callers that
+// download many modules should call it (usually through modload.WriteGoMod)
+// once the downloads are done rather than after each one.
 func WriteGoSum() {
 	goSum.mu.Lock()
 	defer goSum.mu.Unlock()
@@ -643,7 +1488,7 @@ func WriteGoSum() {
 			goSum.m = make(map[module.Version][]string, len(goSum.m))
 			readGoSum(goSum.m, GoSumFile, data)
 			for ms := range goSum.checked {
-				addModSumLocked(ms.mod, ms.sum)
+				addModSumLocked(ms.mod, ms.sum, options(context.Background()).ReportMismatches)
 				goSum.dirty = true
 			}
 		}
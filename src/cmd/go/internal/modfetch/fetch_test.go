@@ -0,0 +1,581 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+func TestCachedDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "go-cachedDir-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	defer func(pkgMod, goSumFile string) {
+		PkgMod, GoSumFile = pkgMod, goSumFile
+		goSum.m = nil
+	}(PkgMod, GoSumFile)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+	GoSumFile = filepath.Join(tmpdir, "go.sum")
+	goSum.m = nil
+
+	mod := module.Version{Path: "example.com/m", Version: "v1.0.0"}
+	const h = "h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	check := func(want bool) {
+		t.Helper()
+		dir, ok := CachedDir(mod)
+		if wantDir := filepath.Join(PkgMod, "example.com", "m@v1.0.0"); dir != wantDir {
+			t.Errorf("CachedDir(%v) = %q, want %q", mod, dir, wantDir)
+		}
+		if ok != want {
+			t.Errorf("CachedDir(%v) = _, %v, want %v", mod, ok, want)
+		}
+	}
+
+	// Missing from the cache.
+	check(false)
+
+	// Extracted, but without a recorded checksum.
+	if err := os.MkdirAll(filepath.Join(PkgMod, "example.com", "m@v1.0.0"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	check(false)
+
+	// Extracted, with a checksum not listed in go.sum.
+	ziphash, err := CachePath(mod, "ziphash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(ziphash), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(ziphash, []byte(h+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	check(true)
+
+	// Partially extracted.
+	partial, err := CachePath(mod, "partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(partial, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	check(false)
+	if err := os.Remove(partial); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listed in go.sum with the same checksum.
+	if err := ioutil.WriteFile(GoSumFile, []byte("example.com/m v1.0.0 "+h+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	goSum.m = nil
+	check(true)
+
+	// Listed in go.sum with a different checksum.
+	if err := ioutil.WriteFile(GoSumFile, []byte("example.com/m v1.0.0 h1:BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB=\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	goSum.m = nil
+	check(false)
+}
+
+func TestPurgeVersion(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "go-purgeVersion-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveAll(tmpdir)
+
+	defer func(pkgMod string) { PkgMod = pkgMod }(PkgMod)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+
+	suffixes := []string{"info", "mod", "zip", "ziphash"}
+	populate := func(mod module.Version) {
+		t.Helper()
+		dir, _ := DownloadDir(mod)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+mod.Path+"\n"), 0444); err != nil {
+			t.Fatal(err)
+		}
+		makeDirsReadOnly(dir)
+		for _, suffix := range suffixes {
+			file, err := CachePath(mod, suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(file, nil, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	exists := func(mod module.Version) (n int) {
+		t.Helper()
+		dir, _ := DownloadDir(mod)
+		if _, err := os.Stat(dir); err == nil {
+			n++
+		}
+		for _, suffix := range suffixes {
+			file, err := CachePath(mod, suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(file); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	purged := module.Version{Path: "example.com/m", Version: "v1.0.0"}
+	kept := module.Version{Path: "example.com/m", Version: "v1.1.0"}
+	populate(purged)
+	populate(kept)
+
+	if err := PurgeVersion(purged); err != nil {
+		t.Fatalf("PurgeVersion(%v): %v", purged, err)
+	}
+	if n := exists(purged); n != 0 {
+		t.Errorf("after PurgeVersion(%v), %d of its files remain", purged, n)
+	}
+	if n := exists(kept); n != len(suffixes)+1 {
+		t.Errorf("after PurgeVersion(%v), %d of %d files remain for %v", purged, n, len(suffixes)+1, kept)
+	}
+
+	// Purging a version that is not in the cache is not an error.
+	if err := PurgeVersion(purged); err != nil {
+		t.Errorf("PurgeVersion(%v) again: %v", purged, err)
+	}
+}
+
+func TestCleanTemp(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "go-cleanTemp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveAll(tmpdir)
+
+	defer func(pkgMod string) { PkgMod = pkgMod }(PkgMod)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+
+	write := func(name string) {
+		t.Helper()
+		name = filepath.Join(PkgMod, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte("x"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := []string{
+		"cache/download/example.com/m/@v/v1.0.0.info",
+		"cache/download/example.com/m/@v/v1.0.0.mod",
+		"cache/download/example.com/m/@v/v1.0.0.zip",
+		"cache/download/example.com/m/@v/v1.0.0.ziphash",
+		"cache/download/example.com/m/@v/v1.0.0.lock",
+		"cache/download/example.com/m/@v/v1.0.0-pre.zip.tmp123",
+		"cache/download/example.com/m/@v/v1.1.0.partial",
+		"cache/download/example.com/m/@v/list",
+		"example.com/m@v1.0.0/go.mod",
+		"example.com/m@v1.1.0/go.mod",
+	}
+	temp := []string{
+		"cache/download/example.com/m/@v/v1.0.0.zip.tmp123",
+		"cache/download/example.com/m/@v/v1.0.0.info456.tmp",
+		"cache/download/example.com/m/@v/v1.0.0.mod789.tmp",
+		"example.com/m@v1.0.0.tmp-123/go.mod",
+		"example.com/m@v1.0.0.tmp-purge/go.mod",
+	}
+	for _, name := range append(kept, temp...) {
+		write(name)
+	}
+
+	mod := module.Version{Path: "example.com/m", Version: "v1.0.0"}
+	removed, err := CleanTemp(mod)
+	if err != nil {
+		t.Fatalf("CleanTemp(%v): %v", mod, err)
+	}
+	if len(removed) != len(temp) {
+		t.Errorf("CleanTemp(%v) removed %q, want %d files", mod, removed, len(temp))
+	}
+	for _, name := range temp {
+		if _, err := os.Stat(filepath.Join(PkgMod, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("after CleanTemp(%v), %s remains", mod, name)
+		}
+	}
+	for _, name := range kept {
+		if _, err := os.Stat(filepath.Join(PkgMod, filepath.FromSlash(name))); err != nil {
+			t.Errorf("after CleanTemp(%v), %s is missing", mod, name)
+		}
+	}
+
+	// A partially extracted directory is removed with its .partial file.
+	partial := module.Version{Path: "example.com/m", Version: "v1.1.0"}
+	if _, err := CleanTemp(partial); err != nil {
+		t.Fatalf("CleanTemp(%v): %v", partial, err)
+	}
+	for _, name := range []string{"cache/download/example.com/m/@v/v1.1.0.partial", "example.com/m@v1.1.0"} {
+		if _, err := os.Stat(filepath.Join(PkgMod, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("after CleanTemp(%v), %s remains", partial, name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(PkgMod, "example.com/m@v1.0.0/go.mod")); err != nil {
+		t.Errorf("after CleanTemp(%v), complete directory of %v is missing", partial, mod)
+	}
+}
+
+func TestDownloadConcurrentRead(t *testing.T) {
+	for _, inPlace := range []bool{false, true} {
+		t.Run(fmt.Sprintf("unzipInPlace=%v", inPlace), func(t *testing.T) {
+			testDownloadConcurrentRead(t, inPlace)
+		})
+	}
+}
+
+// testDownloadConcurrentRead races the extraction of module versions
+// against readers of the same versions, which must never find a
+// directory that DownloadDir reports as complete to be missing files.
+func testDownloadConcurrentRead(t *testing.T, inPlace bool) {
+	tmpdir, err := ioutil.TempDir("", "go-downloadConcurrentRead-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveAll(tmpdir)
+
+	defer func(pkgMod string, unzip bool) { PkgMod, unzipInPlace = pkgMod, unzip }(PkgMod, unzipInPlace)
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+	unzipInPlace = inPlace
+
+	const nfiles = 100
+	var files []string
+	for i := 0; i < nfiles; i++ {
+		files = append(files, fmt.Sprintf("p%d/f%d.go", i%10, i))
+	}
+	for v := 0; v < 5; v++ {
+		mod := module.Version{Path: "example.com/m", Version: fmt.Sprintf("v1.0.%d", v)}
+		// DownloadZip remembers zip files for the life of the process.
+		downloadZipCache.Delete(mod)
+		defer downloadZipCache.Delete(mod)
+		zipfile, err := CachePath(mod, "zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(zipfile), 0777); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(zipfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		for _, name := range files {
+			w, err := zw.Create(mod.String() + "/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(w, "package p // %s\n", name)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					dir, err := DownloadDir(mod)
+					if err != nil {
+						continue
+					}
+					for _, name := range files {
+						data, err := ioutil.ReadFile(filepath.Join(dir, name))
+						if want := fmt.Sprintf("package p // %s\n", name); err != nil || string(data) != want {
+							t.Errorf("%v: reading %s from complete directory: %q, %v; want %q", mod, name, data, err, want)
+							return
+						}
+					}
+				}
+			}()
+		}
+
+		var dwg sync.WaitGroup
+		for w := 0; w < 2; w++ {
+			dwg.Add(1)
+			go func() {
+				defer dwg.Done()
+				if _, err := download(context.Background(), mod); err != nil {
+					t.Errorf("download(%v): %v", mod, err)
+				}
+			}()
+		}
+		dwg.Wait()
+		close(done)
+		wg.Wait()
+
+		if _, err := DownloadDir(mod); err != nil {
+			t.Errorf("DownloadDir(%v) after download: %v", mod, err)
+		}
+	}
+}
+
+func TestVerifyZip(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "go-verifyZip-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	mod := module.Version{Path: "example.com/m", Version: "v1.0.0"}
+	file := filepath.Join(tmpdir, "m.zip")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"go.mod", "m.go"} {
+		w, err := zw.Create("example.com/m@v1.0.0/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, "contents of %s\n", name)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := dirhash.HashZip(file, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyZip(file, mod, sum); err != nil {
+		t.Errorf("VerifyZip(%v, %s) = %v, want nil", mod, sum, err)
+	}
+	const wrong = "h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	if err := VerifyZip(file, mod, wrong); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("VerifyZip(%v, %s) = %v, want %v", mod, wrong, err, ErrChecksumMismatch)
+	}
+	other := module.Version{Path: "example.com/m", Version: "v1.0.1"}
+	if err := VerifyZip(file, other, sum); err == nil || !strings.Contains(err.Error(), "unexpected file") {
+		t.Errorf("VerifyZip(%v, %s) = %v, want unexpected file error", other, sum, err)
+	}
+	if err := VerifyZip(filepath.Join(tmpdir, "missing.zip"), mod, sum); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("VerifyZip of missing file = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+// setupSums points GoSumFile at a go.sum file listing checksums for n
+// versions of a module and their go.mod files, and returns the versions.
+func setupSums(tb testing.TB, n int) []module.Version {
+	tmpdir, err := ioutil.TempDir("", "go-sums-test-")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pkgMod, goSumFile := PkgMod, GoSumFile
+	tb.Cleanup(func() {
+		os.RemoveAll(tmpdir)
+		PkgMod, GoSumFile = pkgMod, goSumFile
+		goSum.m = nil
+	})
+	PkgMod = filepath.Join(tmpdir, "pkg", "mod")
+	GoSumFile = filepath.Join(tmpdir, "go.sum")
+	goSum.m = nil
+
+	var mods []module.Version
+	var buf strings.Builder
+	for i := 0; i < n; i++ {
+		mod := module.Version{Path: "example.com/m", Version: fmt.Sprintf("v1.0.%d", i)}
+		mods = append(mods, mod)
+		fmt.Fprintf(&buf, "%s %s h1:%043d=\n", mod.Path, mod.Version, i)
+		fmt.Fprintf(&buf, "%s %s/go.mod h1:%043d=\n", mod.Path, mod.Version, n+i)
+	}
+	if err := ioutil.WriteFile(GoSumFile, []byte(buf.String()), 0666); err != nil {
+		tb.Fatal(err)
+	}
+	return mods
+}
+
+func TestSums(t *testing.T) {
+	mods := setupSums(t, 3)
+	sums := Sums(mods)
+	if len(sums) != len(mods) {
+		t.Fatalf("Sums returned %d results; want %d", len(sums), len(mods))
+	}
+	for _, mod := range mods {
+		want := SumResult{
+			Sum:         Sum(mod),
+			Listed:      ListedSums(mod),
+			ListedGoMod: ListedSums(module.Version{Path: mod.Path, Version: mod.Version + "/go.mod"}),
+		}
+		if got := sums[mod]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Sums()[%v] = %+v; want %+v", mod, got, want)
+		}
+	}
+}
+
+func TestWriteGoSumConcurrent(t *testing.T) {
+	mods := setupSums(t, 3)
+
+	// Add checksums for many new modules from many goroutines,
+	// as the workers of go mod download -concurrency=50 do,
+	// while go.sum is written concurrently.
+	const n, workers = 500, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				mod := module.Version{Path: fmt.Sprintf("example.com/new%d", i), Version: "v1.0.0"}
+				goSum.mu.Lock()
+				if _, err := initGoSum(); err != nil {
+					t.Error(err)
+				}
+				addModSumLocked(mod, fmt.Sprintf("h1:%043d=", i), false)
+				goSum.mu.Unlock()
+				if i%100 == 0 {
+					WriteGoSum()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	WriteGoSum()
+
+	data, err := ioutil.ReadFile(GoSumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if want := n + 2*len(mods); len(lines) != want {
+		t.Errorf("go.sum has %d lines, want %d", len(lines), want)
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) != 3 || !strings.HasPrefix(f[2], "h1:") {
+			t.Errorf("malformed go.sum line %q", line)
+		}
+		if seen[line] {
+			t.Errorf("duplicate go.sum line %q", line)
+		}
+		seen[line] = true
+	}
+	for i := 0; i < n; i++ {
+		if line := fmt.Sprintf("example.com/new%d v1.0.0 h1:%043d=", i, i); !seen[line] {
+			t.Errorf("go.sum is missing %q", line)
+		}
+	}
+}
+
+// The benchmarks below look up the checksums of 500 modules from 50
+// goroutines, as go mod download -concurrency=50 does, either with
+// individual calls in each goroutine or with a single call to Sums
+// made before starting the goroutines.
+
+const benchSumsMods, benchSumsWorkers = 500, 50
+
+func BenchmarkSumsIndividual(b *testing.B) {
+	mods := setupSums(b, benchSumsMods)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for w := 0; w < benchSumsWorkers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for j := w; j < len(mods); j += benchSumsWorkers {
+					mod := mods[j]
+					Sum(mod)
+					ListedSums(mod)
+					ListedSums(module.Version{Path: mod.Path, Version: mod.Version + "/go.mod"})
+				}
+			}(w)
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkSumsBatch(b *testing.B) {
+	mods := setupSums(b, benchSumsMods)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sums := Sums(mods)
+		var wg sync.WaitGroup
+		for w := 0; w < benchSumsWorkers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for j := w; j < len(mods); j += benchSumsWorkers {
+					_ = sums[mods[j]]
+				}
+			}(w)
+		}
+		wg.Wait()
+	}
+}
+
+func TestRecordTreeHead(t *testing.T) {
+	defer func(m map[string]SumDBTreeHead) { treeHeads.m = m }(treeHeads.m)
+	treeHeads.m = nil
+
+	if got := SumDBTreeHeads(); len(got) != 0 {
+		t.Fatalf("SumDBTreeHeads() = %v, want none", got)
+	}
+	var hash tlog.Hash
+	hash[0] = 1
+	note := func(n int64) []byte {
+		return append(tlog.FormatTree(tlog.Tree{N: n, Hash: hash}), "\n— example.com/db sig\n"...)
+	}
+	recordTreeHead("z.example.com", note(5))
+	recordTreeHead("a.example.com", note(10))
+	recordTreeHead("a.example.com", note(7)) // older: ignored
+	recordTreeHead("a.example.com", []byte("not a tree"))
+
+	got := SumDBTreeHeads()
+	if len(got) != 2 || got[0].Name != "a.example.com" || got[1].Name != "z.example.com" {
+		t.Fatalf("SumDBTreeHeads() = %+v, want a.example.com and z.example.com", got)
+	}
+	if got[0].Size != 10 || got[0].Hash != hash.String() || got[0].Note != string(note(10)) {
+		t.Errorf("SumDBTreeHeads()[0] = %+v, want the tree head of size 10", got[0])
+	}
+}
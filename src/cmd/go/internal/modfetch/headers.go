@@ -0,0 +1,66 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+)
+
+var proxyHeaders struct {
+	mu sync.Mutex
+	m  map[string]map[string]string // module path + "/" + proxy file path → header → value
+}
+
+// recordHeaders records the headers named by the TraceHeaders option in
+// effect for ctx that are found in h, the header of the response to a request
+// for file (such as "@v/v1.0.0.zip") of the module with the given path.
+func recordHeaders(ctx context.Context, path, file string, h map[string][]string) {
+	names := options(ctx).TraceHeaders
+	if len(names) == 0 {
+		return
+	}
+	found := make(map[string]string)
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if v, ok := h[name]; ok {
+			found[name] = strings.Join(v, ", ")
+		}
+	}
+	proxyHeaders.mu.Lock()
+	if proxyHeaders.m == nil {
+		proxyHeaders.m = make(map[string]map[string]string)
+	}
+	proxyHeaders.m[path+"/"+file] = found
+	proxyHeaders.mu.Unlock()
+}
+
+// ProxyHeaders returns the headers recorded (see DownloadOptions.TraceHeaders)
+// for the .info, .mod, and .zip files of mod, keyed by "info", "mod", or "zip"
+// and then by canonical header name. Files that were not fetched from a module
+// proxy, such as those found in the module cache, have no entry. If no
+// headers were recorded for mod, ProxyHeaders returns nil.
+func ProxyHeaders(mod module.Version) map[string]map[string]string {
+	enc, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return nil
+	}
+	proxyHeaders.mu.Lock()
+	defer proxyHeaders.mu.Unlock()
+	var headers map[string]map[string]string
+	for _, suffix := range []string{"info", "mod", "zip"} {
+		if h, ok := proxyHeaders.m[mod.Path+"/@v/"+enc+"."+suffix]; ok {
+			if headers == nil {
+				headers = make(map[string]map[string]string)
+			}
+			headers[suffix] = h
+		}
+	}
+	return headers
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	opts := &DownloadOptions{TraceHeaders: []string{"cache-control", "X-Cache"}}
+	ctx := context.WithValue(context.Background(), optionsKey{}, opts)
+
+	h := map[string][]string{
+		"Cache-Control": {"max-age=60"},
+		"X-Cache":       {"HIT", "MISS"},
+		"Set-Cookie":    {"secret"},
+	}
+	recordHeaders(ctx, "example.com/Headers", "@v/v1.0.0.zip", h)
+	recordHeaders(ctx, "example.com/Headers", "@v/v1.0.0.info", map[string][]string{"Age": {"3"}})
+	recordHeaders(ctx, "example.com/Headers", "@v/list", h)
+
+	got := ProxyHeaders(module.Version{Path: "example.com/Headers", Version: "v1.0.0"})
+	want := map[string]map[string]string{
+		"info": {},
+		"zip":  {"Cache-Control": "max-age=60", "X-Cache": "HIT, MISS"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProxyHeaders = %v, want %v", got, want)
+	}
+	if got := ProxyHeaders(module.Version{Path: "example.com/Headers", Version: "v1.1.0"}); got != nil {
+		t.Errorf("ProxyHeaders for unfetched version = %v, want nil", got)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// A FetchKind identifies the kind of file fetched in a FetchEvent.
+type FetchKind int
+
+const (
+	FetchInfo  FetchKind = iota // .info file, from Repo.Stat
+	FetchGoMod                  // .mod file, from Repo.GoMod
+	FetchZip                    // .zip file, from Repo.Zip
+)
+
+func (k FetchKind) String() string {
+	switch k {
+	case FetchInfo:
+		return "info"
+	case FetchGoMod:
+		return "mod"
+	case FetchZip:
+		return "zip"
+	}
+	return "unknown"
+}
+
+// A FetchEvent describes one attempt to fetch a file of a module from
+// a module proxy or version control repository. Files found in the module
+// cache are not fetched and not reported.
+type FetchEvent struct {
+	Kind     FetchKind
+	Mod      module.Version // for FetchInfo, Version is the queried revision if the fetch failed
+	Bytes    int64          // bytes received, for FetchGoMod and FetchZip
+	Duration time.Duration  // time spent fetching
+	Err      error          // error that ended the fetch, if any
+}
+
+// A MetricsObserver is notified of the fetches made by modfetch, so that
+// programs embedding module downloads can count attempts, failures,
+// bytes transferred, and latency without parsing the output of the go
+// command.
+//
+// ObserveFetch may be called concurrently from multiple goroutines and
+// should return quickly: it is called by the goroutine doing the fetch.
+type MetricsObserver interface {
+	ObserveFetch(ev FetchEvent)
+}
+
+var metrics struct {
+	mu  sync.RWMutex
+	obs MetricsObserver
+}
+
+// SetMetricsObserver arranges for obs to be notified of each fetch.
+// If obs is nil, fetches are not reported, which is the default.
+func SetMetricsObserver(obs MetricsObserver) {
+	metrics.mu.Lock()
+	metrics.obs = obs
+	metrics.mu.Unlock()
+}
+
+// observeFetch reports a fetch of kind for mod that began at start.
+func observeFetch(kind FetchKind, mod module.Version, bytes int64, start time.Time, err error) {
+	metrics.mu.RLock()
+	obs := metrics.obs
+	metrics.mu.RUnlock()
+	if obs != nil {
+		obs.ObserveFetch(FetchEvent{Kind: kind, Mod: mod, Bytes: bytes, Duration: time.Since(start), Err: err})
+	}
+}
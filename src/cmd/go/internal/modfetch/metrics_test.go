@@ -0,0 +1,111 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+// A metricsRepo is a Repo that serves one version of a module.
+type metricsRepo struct {
+	path string
+}
+
+func (r *metricsRepo) ModulePath() string                { return r.path }
+func (r *metricsRepo) Versions(string) ([]string, error) { return []string{"v1.0.0"}, nil }
+func (r *metricsRepo) Latest() (*RevInfo, error)         { return r.Stat("v1.0.0") }
+
+func (r *metricsRepo) Stat(rev string) (*RevInfo, error) {
+	if rev != "v1.0.0" && rev != "latest" {
+		return nil, os.ErrNotExist
+	}
+	return &RevInfo{Version: "v1.0.0"}, nil
+}
+
+func (r *metricsRepo) GoMod(version string) ([]byte, error) {
+	return []byte("module " + r.path + "\n"), nil
+}
+
+func (r *metricsRepo) Zip(ctx context.Context, dst io.Writer, version string) error {
+	return errors.New("not implemented")
+}
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []FetchEvent
+}
+
+func (o *recordingObserver) ObserveFetch(ev FetchEvent) {
+	o.mu.Lock()
+	o.events = append(o.events, ev)
+	o.mu.Unlock()
+}
+
+func TestMetricsObserver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modfetch-metrics-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { PkgMod = old }(PkgMod)
+	PkgMod = dir
+
+	obs := new(recordingObserver)
+	SetMetricsObserver(obs)
+	defer SetMetricsObserver(nil)
+
+	const path = "example.com/metrics"
+	r := newCachingRepo(&metricsRepo{path: path})
+	if _, err := r.Stat("latest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stat("v2.0.0"); err == nil {
+		t.Fatal("Stat(v2.0.0) succeeded unexpectedly")
+	}
+	gomod, err := r.GoMod("v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files now in the module cache are not fetched again.
+	r = newCachingRepo(&metricsRepo{path: path})
+	if _, err := r.Stat("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GoMod("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kind  FetchKind
+		mod   module.Version
+		bytes int64
+		err   bool
+	}{
+		{FetchInfo, module.Version{Path: path, Version: "v1.0.0"}, 0, false},
+		{FetchInfo, module.Version{Path: path, Version: "v2.0.0"}, 0, true},
+		{FetchGoMod, module.Version{Path: path, Version: "v1.0.0"}, int64(len(gomod)), false},
+	}
+	if len(obs.events) != len(want) {
+		t.Fatalf("observed %d events, want %d: %+v", len(obs.events), len(want), obs.events)
+	}
+	for i, w := range want {
+		ev := obs.events[i]
+		if ev.Kind != w.kind || ev.Mod != w.mod || ev.Bytes != w.bytes || (ev.Err != nil) != w.err {
+			t.Errorf("event %d = %+v, want kind %v, module %v, %d bytes, error %v", i, ev, w.kind, w.mod, w.bytes, w.err)
+		}
+		if ev.Duration < 0 {
+			t.Errorf("event %d: negative duration %v", i, ev.Duration)
+		}
+	}
+}
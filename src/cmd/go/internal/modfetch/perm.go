@@ -0,0 +1,65 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// cacheDirMode returns the permission bits for directories created in the
+// module cache when files are given the permission bits in mode
+// (see DownloadOptions.CacheMode).
+func cacheDirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// mkdirAll is like os.MkdirAll(dir, 0777), but if mode is nonzero,
+// it gives each directory it creates the permissions of cacheDirMode(mode).
+func mkdirAll(dir string, mode os.FileMode) error {
+	if mode == 0 {
+		return os.MkdirAll(dir, 0777)
+	}
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAll(parent, mode); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0777); err != nil {
+		if fi, statErr := os.Stat(dir); statErr == nil && fi.IsDir() {
+			// Created by someone else in the meantime.
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, cacheDirMode(mode))
+}
+
+// setCacheMode gives file, which must have just been written, the
+// permissions in mode, if it is nonzero.
+func setCacheMode(file string, mode os.FileMode) error {
+	if mode == 0 {
+		return nil
+	}
+	return os.Chmod(file, mode)
+}
+
+// setTreeCacheMode gives the files and directories in the tree rooted at
+// dir, which must have just been extracted, the permissions in mode and
+// cacheDirMode(mode).
+func setTreeCacheMode(dir string, mode os.FileMode) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.Chmod(path, cacheDirMode(mode))
+		}
+		return os.Chmod(path, mode)
+	})
+}
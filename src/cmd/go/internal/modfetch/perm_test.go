@@ -0,0 +1,68 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCacheMode(t *testing.T) {
+	switch runtime.GOOS {
+	case "windows", "plan9":
+		t.Skipf("%s does not have Unix-style file permissions", runtime.GOOS)
+	}
+	tmpdir, err := ioutil.TempDir("", "modfetch-perm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	const mode = 0660
+	ctx := context.WithValue(context.Background(), optionsKey{}, &DownloadOptions{CacheMode: mode})
+
+	dir := filepath.Join(tmpdir, "a", "b")
+	if err := mkdirAll(dir, mode); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "f")
+	if err := writeDiskCache(ctx, file, []byte("data\n")); err != nil {
+		t.Fatal(err)
+	}
+	checkPerm := func(name string, want os.FileMode) {
+		t.Helper()
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("%s has mode %#o, want %#o", name, got, want)
+		}
+	}
+	checkPerm(filepath.Join(tmpdir, "a"), 0770)
+	checkPerm(dir, 0770)
+	checkPerm(file, 0660)
+
+	// Directories that already exist are left alone.
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := mkdirAll(dir, mode); err != nil {
+		t.Fatal(err)
+	}
+	checkPerm(dir, 0700)
+
+	if err := os.Chmod(file, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if err := setTreeCacheMode(filepath.Join(tmpdir, "a"), mode); err != nil {
+		t.Fatal(err)
+	}
+	checkPerm(dir, 0770)
+	checkPerm(file, 0660)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/mod/module"
+)
+
+// A ProgressKind identifies the kind of a ProgressEvent.
+type ProgressKind int
+
+const (
+	ProgressStart ProgressKind = iota // download of a module zip started
+	ProgressBytes                     // bytes of a module zip were received
+	ProgressDone                      // download of a module zip finished
+)
+
+// A ProgressEvent reports the progress of downloading a module zip file.
+type ProgressEvent struct {
+	Kind  ProgressKind
+	Mod   module.Version
+	Bytes int64 // number of bytes received so far
+	Total int64 // expected size of the zip file, or -1 if unknown
+	Err   error // for ProgressDone, the error that ended the download, if any
+}
+
+var progress struct {
+	mu sync.RWMutex
+	f  func(ProgressEvent)
+}
+
+// SetProgressFunc arranges for f to be called to report the progress of
+// each module zip file downloaded by DownloadZip (and hence Download).
+// Zip files already present in the module cache are not reported.
+// Calls to f may be made concurrently from multiple goroutines.
+// If f is nil, progress is not reported.
+func SetProgressFunc(f func(ProgressEvent)) {
+	progress.mu.Lock()
+	progress.f = f
+	progress.mu.Unlock()
+}
+
+func reportProgress(ev ProgressEvent) {
+	progress.mu.RLock()
+	f := progress.f
+	progress.mu.RUnlock()
+	if f != nil {
+		f(ev)
+	}
+}
+
+// A progressWriter is an io.Writer that reports the bytes written
+// to it as the zip file for mod.
+// A Repo's Zip method may set total if it knows the size of the zip file.
+type progressWriter struct {
+	w        io.Writer
+	mod      module.Version
+	n        int64
+	total    int64
+	received int64 // bytes written, including any later truncated
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.n += int64(n)
+	pw.received += int64(n)
+	if n > 0 {
+		reportProgress(ProgressEvent{Kind: ProgressBytes, Mod: pw.mod, Bytes: pw.n, Total: pw.total})
+	}
+	return n, err
+}
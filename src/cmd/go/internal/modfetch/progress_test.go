@@ -0,0 +1,43 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var events []ProgressEvent
+	SetProgressFunc(func(ev ProgressEvent) {
+		events = append(events, ev)
+	})
+	defer SetProgressFunc(nil)
+
+	mod := module.Version{Path: "example.com/m", Version: "v1.0.0"}
+	var buf bytes.Buffer
+	pw := &progressWriter{w: &buf, mod: mod, total: 5}
+	pw.Write([]byte("abc"))
+	pw.Write(nil)
+	pw.Write([]byte("de"))
+
+	if buf.String() != "abcde" {
+		t.Errorf("wrote %q; want %q", buf.String(), "abcde")
+	}
+	want := []ProgressEvent{
+		{Kind: ProgressBytes, Mod: mod, Bytes: 3, Total: 5},
+		{Kind: ProgressBytes, Mod: mod, Bytes: 5, Total: 5},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events; want %d", len(events), len(want))
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v; want %+v", i, events[i], want[i])
+		}
+	}
+}
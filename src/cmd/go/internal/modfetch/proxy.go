@@ -5,11 +5,13 @@
 package modfetch
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -19,9 +21,11 @@ import (
 	"sync"
 	"time"
 
+	"cmd/go/internal/auth"
 	"cmd/go/internal/base"
 	"cmd/go/internal/cfg"
 	"cmd/go/internal/modfetch/codehost"
+	"cmd/go/internal/renameio"
 	"cmd/go/internal/web"
 
 	"golang.org/x/mod/module"
@@ -190,6 +194,55 @@ func TryProxies(f func(proxy string) error) error {
 	return lastAttemptErr
 }
 
+// A ProbeResult describes the answer of a module proxy
+// to a request made by ProbeInfo.
+type ProbeResult struct {
+	Proxy   string        // the proxy that answered, with any password redacted
+	Status  int           // HTTP status code of the response, or 0 if none
+	Latency time.Duration // time taken by the request
+	Err     error         // error returned by the proxy, if any
+}
+
+// ProbeInfo requests the .info file for mod from the module proxies listed
+// in GOPROXY, trying them in order as TryProxies does, and reports how the
+// last proxy tried answered. Unlike InfoFile, ProbeInfo neither consults
+// nor adds to the module cache, so each call makes a request. The entries
+// "direct" and "noproxy" are skipped, since they name no proxy to probe.
+func ProbeInfo(ctx context.Context, mod module.Version) ProbeResult {
+	proxies, err := proxyURLs()
+	if err != nil {
+		return ProbeResult{Err: err}
+	}
+	res := ProbeResult{Err: fmt.Errorf("GOPROXY=%s lists no module proxy", cfg.GOPROXY)}
+	for _, proxy := range proxies {
+		if proxy == "direct" || proxy == "noproxy" || proxy == "off" {
+			continue
+		}
+		res = ProbeResult{Proxy: proxy}
+		if u, err := url.Parse(proxy); err == nil {
+			res.Proxy = web.Redacted(u)
+		}
+		r, err := newProxyRepo(proxy, mod.Path)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		start := time.Now()
+		_, res.Err = r.(*proxyRepo).statContext(ctx, mod.Version)
+		res.Latency = time.Since(start)
+		var herr *web.HTTPError
+		if errors.As(res.Err, &herr) {
+			res.Status = herr.StatusCode
+		} else if res.Err == nil && strings.HasPrefix(proxy, "http") {
+			res.Status = http.StatusOK
+		}
+		if !errors.Is(res.Err, os.ErrNotExist) {
+			break
+		}
+	}
+	return res
+}
+
 type proxyRepo struct {
 	url  *url.URL
 	path string
@@ -247,35 +300,155 @@ func (p *proxyRepo) versionError(version string, err error) error {
 	}
 }
 
-func (p *proxyRepo) getBytes(path string) ([]byte, error) {
-	body, err := p.getBody(path)
+// getBytes fetches path relative to the proxy's base URL and returns its
+// contents. The request is canceled if ctx is done before the body has been read.
+func (p *proxyRepo) getBytes(ctx context.Context, path string) ([]byte, error) {
+	resp, err := p.getResponse(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// getResponse fetches path relative to the proxy's base URL.
+// If the request succeeds, the caller must close the response body.
+// The request is canceled if ctx is done before the body has been read.
+func (p *proxyRepo) getResponse(ctx context.Context, path string) (*web.Response, error) {
+	return p.getRangeResponse(ctx, path, 0)
+}
+
+// getRangeResponse is like getResponse, but asks for only the bytes of the
+// file from offset onward (see web.GetRange).
+func (p *proxyRepo) getRangeResponse(ctx context.Context, path string, offset int64) (*web.Response, error) {
+	target := p.fileURL(path)
+	resp, err := web.GetRange(web.WithModule(ctx, p.path), web.DefaultSecurity, target, offset)
 	if err != nil {
 		return nil, err
 	}
-	defer body.Close()
-	return ioutil.ReadAll(body)
+	recordHeaders(ctx, p.path, path, resp.Header)
+	if offset > 0 && (resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+		// Let the caller decide what to do with (or without) the partial content.
+		return resp, nil
+	}
+	if err := resp.Err(); err != nil {
+		resp.Body.Close()
+		return nil, authError(err)
+	}
+	return resp, nil
+}
+
+// An AuthError reports that a module proxy refused a request,
+// with HTTP status 401 (Unauthorized) or 403 (Forbidden),
+// and describes the credentials sent with the request.
+type AuthError struct {
+	Host      string // host name of the proxy
+	Mechanism string // source of the credentials sent: "netrc", "url", or "none"
+	Err       *web.HTTPError
+}
+
+func (e *AuthError) Error() string {
+	var msg string
+	switch e.Mechanism {
+	case "netrc":
+		file, _ := auth.NetrcFile()
+		msg = fmt.Sprintf("credentials for %s from %s were rejected", e.Host, file)
+	case "url":
+		msg = fmt.Sprintf("credentials for %s in the GOPROXY URL were rejected", e.Host)
+	default:
+		file, ok := auth.NetrcFile()
+		switch {
+		case !strings.HasPrefix(e.Err.URL, "https://"):
+			msg = fmt.Sprintf("no credentials sent to %s (.netrc is consulted only for https URLs)", e.Host)
+		case ok:
+			msg = fmt.Sprintf("no credentials for %s in %s", e.Host, file)
+		default:
+			msg = fmt.Sprintf("no credentials for %s (no .netrc file)", e.Host)
+		}
+	}
+	return e.Err.Error() + "\n\tauthentication: " + msg
 }
 
-func (p *proxyRepo) getBody(path string) (io.ReadCloser, error) {
-	fullPath := pathpkg.Join(p.url.Path, path)
+func (e *AuthError) Unwrap() error { return e.Err }
 
+// authError returns err wrapped in an *AuthError if it is an *web.HTTPError
+// reporting that the server refused the request for lack of valid
+// credentials, and err unchanged otherwise.
+func authError(err error) error {
+	herr, ok := err.(*web.HTTPError)
+	if !ok || herr.Auth == "" {
+		return err
+	}
+	if herr.StatusCode != http.StatusUnauthorized && herr.StatusCode != http.StatusForbidden {
+		return err
+	}
+	return &AuthError{Host: herr.Host, Mechanism: herr.Auth, Err: herr}
+}
+
+// fileURL returns the URL of path relative to the proxy's base URL.
+func (p *proxyRepo) fileURL(path string) *url.URL {
 	target := *p.url
-	target.Path = fullPath
+	target.Path = pathpkg.Join(p.url.Path, path)
 	target.RawPath = pathpkg.Join(target.RawPath, pathEscape(path))
+	return &target
+}
 
-	resp, err := web.Get(web.DefaultSecurity, &target)
+// getBytesConditional is like getBytes, but it revalidates the copy of the
+// file saved by an earlier call, if there is one
+// (see DownloadOptions.Conditional).
+func (p *proxyRepo) getBytesConditional(ctx context.Context, path string) ([]byte, error) {
+	dir, err := cacheDir(p.path)
+	if err != nil {
+		return p.getBytes(ctx, path)
+	}
+	file := filepath.Join(dir, pathpkg.Base(path)+".etag")
+	target := p.fileURL(path)
+	key := web.Redacted(target)
+	etag, saved := readETagFile(file, key)
+
+	resp, err := web.GetIfNoneMatch(web.WithModule(ctx, p.path), web.DefaultSecurity, target, etag)
 	if err != nil {
 		return nil, err
 	}
+	recordHeaders(ctx, p.path, path, resp.Header)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return saved, nil
+	}
 	if err := resp.Err(); err != nil {
-		resp.Body.Close()
+		return nil, authError(err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
 		return nil, err
 	}
-	return resp.Body, nil
+	if etag := http.Header(resp.Header).Get("ETag"); etag != "" {
+		if err := writeDiskCache(ctx, file, []byte(key+"\n"+etag+"\n"+string(data))); err != nil {
+			fmt.Fprintf(os.Stderr, "go: writing entity tag cache: %v\n", err)
+		}
+	} else if etag != "" {
+		// The proxy no longer sends entity tags for the file.
+		os.Remove(file)
+	}
+	return data, nil
+}
+
+// readETagFile returns the entity tag and the contents saved in file for
+// the file at url, or "" and nil if there are none.
+func readETagFile(file, url string) (etag string, data []byte) {
+	b, err := renameio.ReadFile(file)
+	if err != nil {
+		return "", nil
+	}
+	f := strings.SplitN(string(b), "\n", 3)
+	if len(f) != 3 || f[0] != url || f[1] == "" {
+		return "", nil
+	}
+	return f[1], []byte(f[2])
 }
 
 func (p *proxyRepo) Versions(prefix string) ([]string, error) {
-	data, err := p.getBytes("@v/list")
+	data, err := p.getBytes(context.Background(), "@v/list")
 	if err != nil {
 		return nil, p.versionError("", err)
 	}
@@ -291,7 +464,7 @@ func (p *proxyRepo) Versions(prefix string) ([]string, error) {
 }
 
 func (p *proxyRepo) latest() (*RevInfo, error) {
-	data, err := p.getBytes("@v/list")
+	data, err := p.getBytes(context.Background(), "@v/list")
 	if err != nil {
 		return nil, p.versionError("", err)
 	}
@@ -352,11 +525,20 @@ func (p *proxyRepo) latest() (*RevInfo, error) {
 }
 
 func (p *proxyRepo) Stat(rev string) (*RevInfo, error) {
+	return p.statContext(context.Background(), rev)
+}
+
+func (p *proxyRepo) statContext(ctx context.Context, rev string) (*RevInfo, error) {
 	encRev, err := module.EscapeVersion(rev)
 	if err != nil {
 		return nil, p.versionError(rev, err)
 	}
-	data, err := p.getBytes("@v/" + encRev + ".info")
+	var data []byte
+	if options(ctx).Conditional && rev != module.CanonicalVersion(rev) {
+		data, err = p.getBytesConditional(ctx, "@v/"+encRev+".info")
+	} else {
+		data, err = p.getBytes(ctx, "@v/"+encRev+".info")
+	}
 	if err != nil {
 		return nil, p.versionError(rev, err)
 	}
@@ -374,7 +556,7 @@ func (p *proxyRepo) Stat(rev string) (*RevInfo, error) {
 }
 
 func (p *proxyRepo) Latest() (*RevInfo, error) {
-	data, err := p.getBytes("@latest")
+	data, err := p.getBytes(context.Background(), "@latest")
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return nil, p.versionError("", err)
@@ -389,6 +571,10 @@ func (p *proxyRepo) Latest() (*RevInfo, error) {
 }
 
 func (p *proxyRepo) GoMod(version string) ([]byte, error) {
+	return p.goModContext(context.Background(), version)
+}
+
+func (p *proxyRepo) goModContext(ctx context.Context, version string) ([]byte, error) {
 	if version != module.CanonicalVersion(version) {
 		return nil, p.versionError(version, fmt.Errorf("internal error: version passed to GoMod is not canonical"))
 	}
@@ -397,14 +583,26 @@ func (p *proxyRepo) GoMod(version string) ([]byte, error) {
 	if err != nil {
 		return nil, p.versionError(version, err)
 	}
-	data, err := p.getBytes("@v/" + encVer + ".mod")
+	data, err := p.getBytes(ctx, "@v/"+encVer+".mod")
+	if err != nil {
+		return nil, p.versionError(version, err)
+	}
+	return data, nil
+}
+
+func (p *proxyRepo) signature(version string) ([]byte, error) {
+	encVer, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, p.versionError(version, err)
+	}
+	data, err := p.getBytes(context.Background(), "@v/"+encVer+".sig")
 	if err != nil {
 		return nil, p.versionError(version, err)
 	}
 	return data, nil
 }
 
-func (p *proxyRepo) Zip(dst io.Writer, version string) error {
+func (p *proxyRepo) Zip(ctx context.Context, dst io.Writer, version string) error {
 	if version != module.CanonicalVersion(version) {
 		return p.versionError(version, fmt.Errorf("internal error: version passed to Zip is not canonical"))
 	}
@@ -413,13 +611,16 @@ func (p *proxyRepo) Zip(dst io.Writer, version string) error {
 	if err != nil {
 		return p.versionError(version, err)
 	}
-	body, err := p.getBody("@v/" + encVer + ".zip")
+	resp, err := p.getResponse(ctx, "@v/"+encVer+".zip")
 	if err != nil {
 		return p.versionError(version, err)
 	}
-	defer body.Close()
+	defer resp.Body.Close()
 
-	lr := &io.LimitedReader{R: body, N: codehost.MaxZipFile + 1}
+	if pw, ok := dst.(*progressWriter); ok {
+		pw.total = resp.ContentLength
+	}
+	lr := &io.LimitedReader{R: limitZipReader(ctx, resp.Body), N: codehost.MaxZipFile + 1}
 	if _, err := io.Copy(dst, lr); err != nil {
 		return p.versionError(version, err)
 	}
@@ -429,6 +630,38 @@ func (p *proxyRepo) Zip(dst io.Writer, version string) error {
 	return nil
 }
 
+func (p *proxyRepo) resumeZip(ctx context.Context, dst io.Writer, version string, offset int64) (resumed bool, err error) {
+	if version != module.CanonicalVersion(version) {
+		return false, p.versionError(version, fmt.Errorf("internal error: version passed to Zip is not canonical"))
+	}
+
+	encVer, err := module.EscapeVersion(version)
+	if err != nil {
+		return false, p.versionError(version, err)
+	}
+	resp, err := p.getRangeResponse(ctx, "@v/"+encVer+".zip", offset)
+	if err != nil {
+		return false, p.versionError(version, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		// The proxy sent the whole file; let the caller start over.
+		return false, nil
+	}
+
+	if pw, ok := dst.(*progressWriter); ok && resp.ContentLength >= 0 {
+		pw.total = offset + resp.ContentLength
+	}
+	lr := &io.LimitedReader{R: limitZipReader(ctx, resp.Body), N: codehost.MaxZipFile + 1 - offset}
+	if _, err := io.Copy(dst, lr); err != nil {
+		return true, p.versionError(version, err)
+	}
+	if lr.N <= 0 {
+		return true, p.versionError(version, fmt.Errorf("downloaded zip file too large"))
+	}
+	return true, nil
+}
+
 // pathEscape escapes s so it can be used in a path.
 // That is, it escapes things like ? and # (which really shouldn't appear anyway).
 // It does not escape / to %2F: our REST API is designed so that / can be left as is.
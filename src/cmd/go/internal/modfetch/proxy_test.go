@@ -0,0 +1,101 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/go/internal/web"
+)
+
+func TestAuthError(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "modfetch-auth-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	netrc := filepath.Join(tmpdir, "netrc")
+	defer os.Setenv("NETRC", os.Getenv("NETRC"))
+	os.Setenv("NETRC", netrc)
+
+	herr := func(url string, status int, auth string) *web.HTTPError {
+		return &web.HTTPError{URL: url, Status: http.StatusText(status), StatusCode: status, Host: "proxy.example.com", Auth: auth}
+	}
+	tests := []struct {
+		err  *web.HTTPError
+		want string // suffix of the message, or "" if err is not an AuthError
+	}{
+		{herr("https://proxy.example.com/m/@v/list", 401, "none"), "authentication: no credentials for proxy.example.com (no .netrc file)"},
+		{herr("https://proxy.example.com/m/@v/list", 403, "netrc"), "authentication: credentials for proxy.example.com from " + netrc + " were rejected"},
+		{herr("https://proxy.example.com/m/@v/list", 401, "url"), "authentication: credentials for proxy.example.com in the GOPROXY URL were rejected"},
+		{herr("http://proxy.example.com/m/@v/list", 403, "none"), "authentication: no credentials sent to proxy.example.com (.netrc is consulted only for https URLs)"},
+		{herr("https://proxy.example.com/m/@v/list", 404, "none"), ""},
+		{herr("file:///proxy/m/@v/list", 403, ""), ""},
+	}
+	for _, tt := range tests {
+		err := authError(tt.err)
+		var aerr *AuthError
+		if !errors.As(err, &aerr) {
+			if tt.want != "" {
+				t.Errorf("authError(%d from %s) = %v, want *AuthError", tt.err.StatusCode, tt.err.URL, err)
+			}
+			continue
+		}
+		if tt.want == "" {
+			t.Errorf("authError(%d from %s) = %v, want unchanged error", tt.err.StatusCode, tt.err.URL, err)
+			continue
+		}
+		if msg := err.Error(); !strings.HasSuffix(msg, tt.want) {
+			t.Errorf("authError(%d from %s) = %q, want suffix %q", tt.err.StatusCode, tt.err.URL, msg, tt.want)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("authError(%d from %s) does not wrap the HTTP error", tt.err.StatusCode, tt.err.URL)
+		}
+	}
+
+	if err := ioutil.WriteFile(netrc, []byte("machine other.example.com login u password p\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	want := "authentication: no credentials for proxy.example.com in " + netrc
+	if msg := authError(herr("https://proxy.example.com/m/@v/list", 401, "none")).Error(); !strings.HasSuffix(msg, want) {
+		t.Errorf("with .netrc file: %q, want suffix %q", msg, want)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+		w.Write([]byte(`{"Version":"v1.0.0"}`))
+	}))
+	defer srv.Close()
+	r, err := newProxyRepo(srv.URL, "example.com/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(ua string) { web.UserAgent = ua }(web.UserAgent)
+	for _, ua := range []string{"", "cache-warmer/1.0"} {
+		web.UserAgent = ua
+		if _, err := r.(*proxyRepo).statContext(context.Background(), "v1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+		if ua == "" {
+			if !strings.HasPrefix(got, "Go-http-client/") {
+				t.Errorf("default User-Agent = %q, want the net/http default", got)
+			}
+		} else if got != ua {
+			t.Errorf("with UserAgent = %q, server got User-Agent %q", ua, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+var zipRate struct {
+	mu sync.RWMutex
+	l  *rateLimiter
+}
+
+// SetZipRateLimit limits the aggregate rate at which module zip files are
+// read from module proxies, across all concurrent downloads, to
+// bytesPerSec bytes per second. If bytesPerSec is zero or negative,
+// zip downloads are not limited.
+//
+// The limit does not apply to zip files created from version control
+// repositories, nor to .info and .mod files, which are small.
+func SetZipRateLimit(bytesPerSec int64) {
+	var l *rateLimiter
+	if bytesPerSec > 0 {
+		l = newRateLimiter(float64(bytesPerSec), time.Now())
+	}
+	zipRate.mu.Lock()
+	zipRate.l = l
+	zipRate.mu.Unlock()
+}
+
+// limitZipReader returns a reader that reads from r subject to the limit set
+// by SetZipRateLimit, or r itself if there is no limit.
+func limitZipReader(ctx context.Context, r io.Reader) io.Reader {
+	zipRate.mu.RLock()
+	l := zipRate.l
+	zipRate.mu.RUnlock()
+	if l == nil {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, l: l}
+}
+
+// A rateLimiter is a token bucket shared by all the readers limited by it.
+// Each byte read takes one token. The bucket holds at most one second's
+// worth of tokens, and readers that overdraw it wait until it is refilled.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	tokens float64 // available tokens; negative if overdrawn
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, now time.Time) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: now}
+}
+
+// burst returns the largest number of bytes that a single read should take.
+func (l *rateLimiter) burst() int {
+	const min, max = 512, 32 << 10
+	b := int(l.rate)
+	if b < min {
+		b = min
+	}
+	if b > max {
+		b = max
+	}
+	return b
+}
+
+// take takes n tokens from the bucket at time now, returning how long
+// the caller must wait before the tokens are available.
+func (l *rateLimiter) take(n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+		l.last = now
+	}
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// A rateLimitedReader reads from r, waiting as needed to stay within
+// the rate of l. It stops waiting and returns ctx.Err() if ctx is done.
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (lr *rateLimitedReader) Read(p []byte) (int, error) {
+	if b := lr.l.burst(); len(p) > b {
+		p = p[:b]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if d := lr.l.take(n, time.Now()); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-lr.ctx.Done():
+				t.Stop()
+				return n, lr.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
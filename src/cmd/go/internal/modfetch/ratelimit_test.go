@@ -0,0 +1,70 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Now()
+	l := newRateLimiter(1000, start)
+
+	tests := []struct {
+		n    int
+		at   time.Duration // since start
+		wait time.Duration
+	}{
+		{n: 600, at: 0, wait: 0},                                           // 400 tokens left
+		{n: 600, at: 0, wait: 200 * time.Millisecond},                      // overdrawn by 200
+		{n: 100, at: 200 * time.Millisecond, wait: 100 * time.Millisecond}, // refilled to 0, then 100 over
+		{n: 0, at: 300 * time.Millisecond, wait: 0},                        // refilled to 0
+		{n: 1000, at: 10 * time.Second, wait: 0},                           // refill is capped at the rate
+		{n: 1, at: 10 * time.Second, wait: 1 * time.Millisecond},           // bucket was emptied
+	}
+	for i, tt := range tests {
+		if wait := l.take(tt.n, start.Add(tt.at)); wait != tt.wait {
+			t.Errorf("%d: take(%d) at %v = %v; want %v", i, tt.n, tt.at, wait, tt.wait)
+		}
+	}
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	SetZipRateLimit(1 << 20)
+	defer SetZipRateLimit(0)
+
+	data := bytes.Repeat([]byte("x"), 100<<10)
+	r := limitZipReader(context.Background(), bytes.NewReader(data))
+	if _, ok := r.(*rateLimitedReader); !ok {
+		t.Fatalf("limitZipReader returned %T; want *rateLimitedReader", r)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes; want %d", len(got), len(data))
+	}
+
+	// A canceled read stops waiting.
+	SetZipRateLimit(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = limitZipReader(ctx, bytes.NewReader(data))
+	if _, err := ioutil.ReadAll(r); err != context.Canceled {
+		t.Errorf("reading with a canceled context: %v; want %v", err, context.Canceled)
+	}
+
+	SetZipRateLimit(0)
+	if r := limitZipReader(context.Background(), bytes.NewReader(data)); r == nil {
+		t.Fatal("limitZipReader returned nil")
+	} else if _, ok := r.(*bytes.Reader); !ok {
+		t.Errorf("without a limit, limitZipReader returned %T; want the reader itself", r)
+	}
+}
@@ -5,11 +5,17 @@
 package modfetch
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"cmd/go/internal/cfg"
@@ -19,6 +25,8 @@ import (
 	"cmd/go/internal/str"
 	web "cmd/go/internal/web"
 
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
 )
 
@@ -50,7 +58,9 @@ type Repo interface {
 	GoMod(version string) (data []byte, err error)
 
 	// Zip writes a zip file for the given version to dst.
-	Zip(dst io.Writer, version string) error
+	// If ctx is done before the zip file has been written,
+	// Zip may stop early and return an error.
+	Zip(ctx context.Context, dst io.Writer, version string) error
 }
 
 // A Rev describes a single revision in a module repository.
@@ -62,6 +72,9 @@ type RevInfo struct {
 	// but they are not recorded when talking about module versions.
 	Name  string `json:"-"` // complete ID in underlying repository
 	Short string `json:"-"` // shortened ID, for use in pseudo-version
+
+	// Origin is set only by DownloadInfo. It is not recorded in .info files.
+	Origin *Origin `json:"-"` // where the module's files came from
 }
 
 // Re: module paths, import paths, repository roots, and lookups
@@ -189,6 +202,12 @@ type lookupCacheKey struct {
 // A successful return does not guarantee that the module
 // has any defined versions.
 func Lookup(proxy, path string) (Repo, error) {
+	return lookupContext(context.Background(), proxy, path)
+}
+
+// lookupContext is like Lookup, but follows the DownloadOptions in effect
+// for ctx.
+func lookupContext(ctx context.Context, proxy, path string) (Repo, error) {
 	if traceRepo {
 		defer logCall("Lookup(%q, %q)", proxy, path)()
 	}
@@ -208,11 +227,67 @@ func Lookup(proxy, path string) (Repo, error) {
 		return cached{r, err}
 	}).(cached)
 
+	if c.err == nil && options(ctx).ProxyOnly {
+		if err := checkProxyOnly(proxy, path); err != nil {
+			return nil, err
+		}
+	}
 	return c.r, c.err
 }
 
+// checkProxyOnly returns an error if the module with the given path,
+// as looked up through proxy, is fetched directly from a version control
+// repository rather than from a module proxy, as DownloadOptions.ProxyOnly
+// forbids.
+func checkProxyOnly(proxy, path string) error {
+	if proxy != "direct" && proxy != "noproxy" {
+		return nil
+	}
+	d, ok := directOrigins.Load(path)
+	if !ok {
+		return nil
+	}
+	if o := d.(*Origin); o.VCS != "mod" {
+		return fmt.Errorf("module %s would be fetched directly from %s repository %s, but only proxies are allowed", path, o.VCS, o.URL)
+	}
+	return nil
+}
+
+// Versions returns the tagged versions of the module with the given path,
+// in semantic version order, as listed by the first proxy in GOPROXY that
+// knows the module. Like a proxy's /@v/list endpoint, the list omits
+// pseudo-versions. Versions downloads nothing into the module cache.
+func Versions(path string) ([]string, error) {
+	var versions []string
+	err := TryProxies(func(proxy string) error {
+		repo, err := Lookup(proxy, path)
+		if err != nil {
+			return err
+		}
+		versions, err = repo.Versions("")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	SortVersions(versions)
+	return versions, nil
+}
+
+// Offline, if set, causes lookups of modules that are not present in the
+// module cache to fail with ErrNotInCache instead of contacting a proxy,
+// the origin of the module, or the checksum database.
+var Offline bool
+
+// ErrNotInCache is returned (possibly wrapped) when a module must be fetched
+// but Offline is set.
+var ErrNotInCache = notExistErrorf("not in module cache")
+
 // lookup returns the module with the given module path.
 func lookup(proxy, path string) (r Repo, err error) {
+	if Offline {
+		return nil, ErrNotInCache
+	}
 	if cfg.BuildMod == "vendor" {
 		return nil, errLookupDisabled
 	}
@@ -238,6 +313,147 @@ func lookup(proxy, path string) (r Repo, err error) {
 	}
 }
 
+// An Origin describes where the files for a module version were fetched from.
+type Origin struct {
+	Proxy    string `json:",omitempty"` // GOPROXY entry that served the module, or "direct"
+	VCS      string `json:",omitempty"` // version control system, for modules fetched directly
+	URL      string `json:",omitempty"` // repository URL, for modules fetched directly
+	Revision string `json:",omitempty"` // full revision ID, for modules fetched directly from a VCS
+
+	insecure bool // some file was fetched without TLS
+}
+
+// Insecure reports whether any of the files for the module was fetched
+// over a connection not protected by TLS (or SSH), such as from an http://
+// GOPROXY entry or, for a module matching GOINSECURE, from an http:// or
+// git:// repository URL.
+func (o *Origin) Insecure() bool {
+	return o != nil && o.insecure
+}
+
+var (
+	origins         sync.Map // module.Version → *Origin
+	directOrigins   sync.Map // module path → *Origin with only VCS and URL set
+	directRevisions sync.Map // module.Version → full revision ID read by a codeRepo
+)
+
+// FetchOrigin returns the origin of the go.mod or zip file for mod
+// fetched by this process, preferring that of the zip file.
+// It returns nil if neither file was fetched, for example because
+// both were already present in the module cache.
+func FetchOrigin(mod module.Version) *Origin {
+	if o, ok := origins.Load(mod); ok {
+		return o.(*Origin)
+	}
+	return nil
+}
+
+// recordOrigin records that a file for mod was fetched
+// through the given entry of the proxy list.
+func recordOrigin(mod module.Version, proxy string) {
+	o := &Origin{Proxy: proxy}
+	switch proxy {
+	case "direct", "noproxy":
+		o.Proxy = "direct"
+		if d, ok := directOrigins.Load(mod.Path); ok {
+			o.VCS = d.(*Origin).VCS
+			o.URL = d.(*Origin).URL
+		}
+		if rev, ok := directRevisions.Load(mod); ok {
+			o.Revision = rev.(string)
+		}
+		o.insecure = insecureURL(o.URL)
+	default:
+		if u, err := url.Parse(proxy); err == nil {
+			o.Proxy = web.Redacted(u)
+		}
+		o.insecure = insecureURL(proxy)
+	}
+	if old, ok := origins.Load(mod); ok && old.(*Origin).insecure {
+		// The go.mod file was fetched insecurely, even if the zip file was not.
+		o.insecure = true
+	}
+	origins.Store(mod, o)
+}
+
+// insecureURL reports whether fetching from the proxy or repository URL u
+// does not use TLS or SSH. A URL without a scheme, such as the scp-like
+// git@host:path, uses SSH. Connections to the loopback interface never
+// leave the machine, so they are not considered insecure.
+func insecureURL(u string) bool {
+	i := strings.Index(u, "://")
+	if i < 0 {
+		return false
+	}
+	switch u[:i] {
+	case "https", "file", "ssh", "git+ssh", "bzr+ssh", "svn+ssh":
+		return false
+	}
+	if pu, err := url.Parse(u); err == nil {
+		host := pu.Hostname()
+		if host == "localhost" {
+			return false
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+// A zipResumer is a Repo that can resume writing a zip file partway through.
+type zipResumer interface {
+	// resumeZip writes to dst the bytes of the zip file for version from
+	// offset onward and reports true. If the repo cannot send part of the
+	// zip file, resumeZip writes nothing and reports false, and the caller
+	// must call Zip instead.
+	resumeZip(ctx context.Context, dst io.Writer, version string, offset int64) (resumed bool, err error)
+}
+
+// resumeZip calls r.resumeZip if r is a zipResumer,
+// and otherwise reports false.
+func resumeZip(ctx context.Context, r Repo, dst io.Writer, version string, offset int64) (bool, error) {
+	if zr, ok := r.(zipResumer); ok {
+		return zr.resumeZip(ctx, dst, version, offset)
+	}
+	return false, nil
+}
+
+// A contextRepo is a Repo whose Stat and GoMod requests can be canceled.
+type contextRepo interface {
+	// statContext is like Stat, but if ctx is done before the
+	// information has been fetched, the request is abandoned and
+	// statContext returns an error wrapping ctx.Err().
+	statContext(ctx context.Context, rev string) (*RevInfo, error)
+
+	// goModContext is like GoMod, but it abandons the request if ctx is done.
+	goModContext(ctx context.Context, version string) ([]byte, error)
+}
+
+// statContext calls r.statContext if r is a contextRepo, and otherwise
+// calls r.Stat, which cannot be canceled once it has started.
+func statContext(ctx context.Context, r Repo, rev string) (*RevInfo, error) {
+	if cr, ok := r.(contextRepo); ok {
+		return cr.statContext(ctx, rev)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, module.VersionError(module.Version{Path: r.ModulePath(), Version: rev}, err)
+	}
+	return r.Stat(rev)
+}
+
+// goModContext calls r.goModContext if r is a contextRepo, and otherwise
+// calls r.GoMod, which cannot be canceled once it has started.
+func goModContext(ctx context.Context, r Repo, version string) ([]byte, error) {
+	if cr, ok := r.(contextRepo); ok {
+		return cr.goModContext(ctx, version)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, module.VersionError(module.Version{Path: r.ModulePath(), Version: version}, err)
+	}
+	return r.GoMod(version)
+}
+
 type lookupDisabledError struct{}
 
 func (lookupDisabledError) Error() string {
@@ -267,11 +483,12 @@ func lookupDirect(path string) (Repo, error) {
 		return nil, notExistError{err: err}
 	}
 
+	directOrigins.Store(path, &Origin{VCS: rr.VCS, URL: rr.Repo})
+
 	if rr.VCS == "mod" {
 		// Fetch module from proxy with base URL rr.Repo.
 		return newProxyRepo(rr.Repo, path)
 	}
-
 	code, err := lookupCodeRepo(rr)
 	if err != nil {
 		return nil, err
@@ -337,6 +554,49 @@ func ImportRepoRev(path, rev string) (Repo, *RevInfo, error) {
 	return repo, info, nil
 }
 
+// RepoRev returns the module version found at the given revision
+// (typically a commit hash or tag) of the Git repository with the given remote URL.
+// The module path is the one declared by the go.mod file at the root of
+// the repository, and the version is the one the module path's own
+// repository would assign to that revision.
+func RepoRev(remote, rev string) (module.Version, error) {
+	if Offline {
+		return module.Version{}, fmt.Errorf("cannot look up repository %s: %w", remote, ErrNotInCache)
+	}
+	code, err := codehost.NewRepo("git", remote)
+	if err != nil {
+		return module.Version{}, err
+	}
+	revInfo, err := code.Stat(rev)
+	if err != nil {
+		return module.Version{}, err
+	}
+	data, err := code.ReadFile(revInfo.Name, "go.mod", codehost.MaxGoMod)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return module.Version{}, fmt.Errorf("repository %s has no go.mod file at revision %s", remote, rev)
+		}
+		return module.Version{}, err
+	}
+	path := modfile.ModulePath(data)
+	if path == "" {
+		return module.Version{}, fmt.Errorf("go.mod file in repository %s at revision %s has no module statement", remote, rev)
+	}
+	if err := module.CheckPath(path); err != nil {
+		return module.Version{}, fmt.Errorf("repository %s at revision %s declares an invalid module path: %v", remote, rev, err)
+	}
+
+	repo, err := newCodeRepo(code, path, path)
+	if err != nil {
+		return module.Version{}, err
+	}
+	info, err := repo.(*codeRepo).convert(revInfo, rev)
+	if err != nil {
+		return module.Version{}, err
+	}
+	return module.Version{Path: path, Version: info.Version}, nil
+}
+
 func SortVersions(list []string) {
 	sort.Slice(list, func(i, j int) bool {
 		cmp := semver.Compare(list[i], list[j])
@@ -388,6 +648,11 @@ func (l *loggingRepo) Stat(rev string) (*RevInfo, error) {
 	return l.r.Stat(rev)
 }
 
+func (l *loggingRepo) statContext(ctx context.Context, rev string) (*RevInfo, error) {
+	defer logCall("Repo[%s]: Stat(%q)", l.r.ModulePath(), rev)()
+	return statContext(ctx, l.r, rev)
+}
+
 func (l *loggingRepo) Latest() (*RevInfo, error) {
 	defer logCall("Repo[%s]: Latest()", l.r.ModulePath())()
 	return l.r.Latest()
@@ -398,13 +663,31 @@ func (l *loggingRepo) GoMod(version string) ([]byte, error) {
 	return l.r.GoMod(version)
 }
 
-func (l *loggingRepo) Zip(dst io.Writer, version string) error {
+func (l *loggingRepo) goModContext(ctx context.Context, version string) ([]byte, error) {
+	defer logCall("Repo[%s]: GoMod(%q)", l.r.ModulePath(), version)()
+	return goModContext(ctx, l.r, version)
+}
+
+func (l *loggingRepo) signature(version string) ([]byte, error) {
+	defer logCall("%s.signature(%q)", l.r.ModulePath(), version)()
+	if s, ok := l.r.(signer); ok {
+		return s.signature(version)
+	}
+	return nil, errNoSignature
+}
+
+func (l *loggingRepo) resumeZip(ctx context.Context, dst io.Writer, version string, offset int64) (bool, error) {
+	defer logCall("%s.resumeZip(%q, %d)", l.r.ModulePath(), version, offset)()
+	return resumeZip(ctx, l.r, dst, version, offset)
+}
+
+func (l *loggingRepo) Zip(ctx context.Context, dst io.Writer, version string) error {
 	dstName := "_"
 	if dst, ok := dst.(interface{ Name() string }); ok {
 		dstName = strconv.Quote(dst.Name())
 	}
 	defer logCall("Repo[%s]: Zip(%s, %q)", l.r.ModulePath(), dstName, version)()
-	return l.r.Zip(dst, version)
+	return l.r.Zip(ctx, dst, version)
 }
 
 // A notExistError is like os.ErrNotExist, but with a custom message
@@ -427,3 +710,30 @@ func (notExistError) Is(target error) bool {
 func (e notExistError) Unwrap() error {
 	return e.err
 }
+
+// IsTransient reports whether err may be caused by a temporary condition,
+// such as a timeout, a reset connection, or a server error reported by a
+// module proxy, so that repeating the failed operation may succeed.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var httpErr *web.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode >= 500 {
+		return true
+	}
+	var tempErr interface {
+		Temporary() bool
+		Timeout() bool
+	}
+	if errors.As(err, &tempErr) && (tempErr.Temporary() || tempErr.Timeout()) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isContextErr reports whether err results from a canceled context
+// or one whose deadline has passed.
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// A signer is a Repo that can serve a detached signature for a module version.
+//
+// A signature is a note (see golang.org/x/mod/sumdb/note) whose text holds
+// go.sum lines for the module version, such as
+//
+//	example.com/m v1.0.0 h1:...
+//	example.com/m v1.0.0/go.mod h1:...
+//
+// A module proxy serves it at $GOPROXY/<module>/@v/<version>.sig.
+type signer interface {
+	// signature returns the signature for version.
+	signature(version string) ([]byte, error)
+}
+
+// A SignatureError reports that a module version failed signature verification.
+type SignatureError struct {
+	Mod module.Version
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("%s@%s: verifying signature: %v", e.Mod.Path, e.Mod.Version, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error { return e.Err }
+
+// errNoSignature is the SignatureError.Err for a module served without one.
+var errNoSignature = errors.New("no signature available")
+
+// fetchSignature returns the signature for mod from the first proxy in
+// GOPROXY that serves mod.
+func fetchSignature(mod module.Version) ([]byte, error) {
+	var data []byte
+	err := TryProxies(func(proxy string) error {
+		repo, err := Lookup(proxy, mod.Path)
+		if err != nil {
+			return err
+		}
+		s, ok := repo.(signer)
+		if !ok {
+			// Modules fetched directly from version control are never signed.
+			return errNoSignature
+		}
+		data, err = s.signature(mod.Version)
+		return err
+	})
+	return data, err
+}
+
+// CheckSignature fetches the signature for mod and checks that it is
+// signed by v and lists the given checksums for the module's zip file
+// and go.mod file. An empty checksum is not checked. The error, if any,
+// is a *SignatureError.
+func CheckSignature(mod module.Version, v note.Verifier, sum, goModSum string) error {
+	data, err := fetchSignature(mod)
+	if err != nil {
+		if errors.Is(err, errNoSignature) || errors.Is(err, os.ErrNotExist) {
+			err = errNoSignature
+		}
+		return &SignatureError{Mod: mod, Err: err}
+	}
+	n, err := note.Open(data, note.VerifierList(v))
+	if err != nil {
+		return &SignatureError{Mod: mod, Err: err}
+	}
+	lines := strings.Split(n.Text, "\n")
+	for _, want := range []struct{ version, sum string }{
+		{mod.Version, sum},
+		{mod.Version + "/go.mod", goModSum},
+	} {
+		if want.sum == "" {
+			continue
+		}
+		line := mod.Path + " " + want.version + " " + want.sum
+		found := false
+		for _, l := range lines {
+			if l == line {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &SignatureError{Mod: mod, Err: fmt.Errorf("signature does not list %s", line)}
+		}
+	}
+	return nil
+}
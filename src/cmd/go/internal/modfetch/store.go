@@ -0,0 +1,223 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cmd/go/internal/renameio"
+
+	"golang.org/x/mod/module"
+)
+
+// A CacheStore holds copies of the files in the module download cache
+// ($GOPATH/pkg/mod/cache/download), so that they can be shared by
+// machines that do not share a module cache.
+//
+// Files are named by their slash-separated paths relative to the download
+// cache, such as "golang.org/x/text/@v/v0.3.2.zip", with the module path
+// and version escaped as in the cache itself.
+//
+// The local module cache remains the working copy: builds read extracted
+// module directories, and the go command reports the names of cached files,
+// so files taken from a CacheStore are first written to the local module
+// cache. Files are checked against go.sum and the checksum database as they
+// are, so a CacheStore need not be trusted.
+type CacheStore interface {
+	// Get returns the contents of the named file.
+	// If the file does not exist, the error satisfies
+	// errors.Is(err, os.ErrNotExist).
+	Get(name string) (io.ReadCloser, error)
+
+	// Put stores the contents of r as the named file. Readers calling
+	// Get concurrently must see either the old file or the new one,
+	// never a partially written file.
+	Put(name string, r io.Reader) error
+
+	// Stat returns the size of the named file.
+	// If the file does not exist, the error satisfies
+	// errors.Is(err, os.ErrNotExist).
+	Stat(name string) (size int64, err error)
+}
+
+// store returns the CacheStore in the DownloadOptions in effect for ctx,
+// or nil if there is none.
+func store(ctx context.Context) CacheStore {
+	if Offline {
+		return nil
+	}
+	return options(ctx).Store
+}
+
+// DirStore returns a CacheStore that keeps its files in the directory dir,
+// laid out like the download cache itself. The directory may be shared,
+// for example on a network file system, by concurrent go commands.
+func DirStore(dir string) CacheStore {
+	return dirStore(dir)
+}
+
+type dirStore string
+
+func (d dirStore) path(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains("/"+name+"/", "/../") {
+		return "", fmt.Errorf("invalid cache store file name %q", name)
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+func (d dirStore) Get(name string) (io.ReadCloser, error) {
+	file, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(file)
+}
+
+func (d dirStore) Put(name string, r io.Reader) error {
+	file, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	return renameio.WriteToFile(file, r, 0666)
+}
+
+func (d dirStore) Stat(name string) (int64, error) {
+	file, err := d.path(name)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// storeName returns the CacheStore name for the local download cache file.
+// Files in the sharded layout have the same names as unsharded ones.
+func storeName(file string) (string, error) {
+	root := filepath.Join(PkgMod, "cache/download")
+	rel, err := filepath.Rel(root, file)
+	if err != nil || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("internal error: %s is not in the download cache", file)
+	}
+	return unshardName(filepath.ToSlash(rel)), nil
+}
+
+// fillFromStore copies the named local download cache file from the
+// CacheStore, if there is one and it holds the file, and reports whether
+// it did so.
+func fillFromStore(ctx context.Context, file string) bool {
+	s := store(ctx)
+	if s == nil {
+		return false
+	}
+	name, err := storeName(file)
+	if err != nil {
+		return false
+	}
+	r, err := s.Get(name)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	mode := options(ctx).CacheMode
+	if err := mkdirAll(filepath.Dir(file), mode); err != nil {
+		return false
+	}
+	return renameio.WriteToFile(file, r, 0666) == nil && setCacheMode(file, mode) == nil
+}
+
+// readStore copies the named file from the CacheStore, if there is one,
+// to w and reports whether it did so. Nothing is written to w if the
+// CacheStore does not hold the file.
+func readStore(ctx context.Context, file string, w io.Writer) (bool, error) {
+	s := store(ctx)
+	if s == nil {
+		return false, nil
+	}
+	name, err := storeName(file)
+	if err != nil {
+		return false, nil
+	}
+	r, err := s.Get(name)
+	if err != nil {
+		return false, nil
+	}
+	defer r.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return true, fmt.Errorf("reading %s from cache store: %v", name, err)
+	}
+	return true, nil
+}
+
+// putStore copies the named local download cache file to the CacheStore,
+// if there is one. Failures are reported to standard error but are not
+// otherwise errors: the file is still in the local module cache.
+func putStore(ctx context.Context, file string) {
+	s := store(ctx)
+	if s == nil {
+		return
+	}
+	if err := putStoreFile(s, file); err != nil {
+		fmt.Fprintf(os.Stderr, "go: writing cache store: %v\n", err)
+	}
+}
+
+func putStoreFile(s CacheStore, file string) error {
+	name, err := storeName(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Put(name, f)
+}
+
+// StoreModule copies the .info, .mod, and .zip files of mod from the local
+// module cache to s, skipping files that s already holds or that are not in
+// the local module cache. It lets the go command fill a CacheStore with
+// modules that were already in the local module cache. Like the Store option,
+// StoreModule does nothing while Offline is set.
+func StoreModule(s CacheStore, mod module.Version) error {
+	if s == nil || Offline {
+		return nil
+	}
+	for _, suffix := range []string{"info", "mod", "zip"} {
+		file, err := CachePath(mod, suffix)
+		if err != nil {
+			return err
+		}
+		local, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		name, err := storeName(file)
+		if err != nil {
+			return err
+		}
+		if size, err := s.Stat(name); err == nil && size == local.Size() {
+			continue
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := putStoreFile(s, file); err != nil {
+			return module.VersionError(mod, fmt.Errorf("writing cache store: %v", err))
+		}
+	}
+	return nil
+}
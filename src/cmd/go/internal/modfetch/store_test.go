@@ -0,0 +1,53 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDirStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modfetch-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := DirStore(dir)
+
+	const name = "example.com/m/@v/v1.0.0.mod"
+	if _, err := s.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(%q) before Put: err = %v, want ErrNotExist", name, err)
+	}
+	if _, err := s.Get(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get(%q) before Put: err = %v, want ErrNotExist", name, err)
+	}
+
+	const data = "module example.com/m\n"
+	if err := s.Put(name, strings.NewReader(data)); err != nil {
+		t.Fatalf("Put(%q): %v", name, err)
+	}
+	if size, err := s.Stat(name); err != nil || size != int64(len(data)) {
+		t.Errorf("Stat(%q) = %d, %v; want %d, nil", name, size, err, len(data))
+	}
+	r, err := s.Get(name)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", name, err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != data {
+		t.Errorf("Get(%q) = %q, %v; want %q, nil", name, got, err, data)
+	}
+
+	for _, bad := range []string{"", "/etc/passwd", "../outside", "example.com/../../outside"} {
+		if err := s.Put(bad, strings.NewReader(data)); err == nil {
+			t.Errorf("Put(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -10,6 +10,7 @@ package modfetch
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -120,6 +121,9 @@ type dbClient struct {
 }
 
 func (c *dbClient) ReadRemote(path string) ([]byte, error) {
+	if Offline {
+		return nil, ErrNotInCache
+	}
 	c.once.Do(c.initBase)
 	if c.baseErr != nil {
 		return nil, c.baseErr
@@ -206,11 +210,14 @@ func (c *dbClient) ReadConfig(file string) (data []byte, err error) {
 		// the first time we connect to a given database.
 		return []byte{}, nil
 	}
+	if err == nil && file == c.name+"/latest" {
+		recordTreeHead(c.name, data)
+	}
 	return data, err
 }
 
 // WriteConfig rewrites the latest tree head.
-func (*dbClient) WriteConfig(file string, old, new []byte) error {
+func (c *dbClient) WriteConfig(file string, old, new []byte) error {
 	if file == "key" {
 		// Should not happen.
 		return fmt.Errorf("cannot write key")
@@ -238,7 +245,13 @@ func (*dbClient) WriteConfig(file string, old, new []byte) error {
 	if _, err := f.Write(new); err != nil {
 		return err
 	}
-	return f.Close()
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if file == c.name+"/latest" {
+		recordTreeHead(c.name, new)
+	}
+	return nil
 }
 
 // ReadCache reads cached lookups or tiles from
@@ -260,8 +273,11 @@ func (*dbClient) ReadCache(file string) ([]byte, error) {
 // WriteCache updates cached lookups or tiles.
 func (*dbClient) WriteCache(file string, data []byte) {
 	targ := filepath.Join(PkgMod, "cache/download/sumdb", file)
-	os.MkdirAll(filepath.Dir(targ), 0777)
-	lockedfile.Write(targ, bytes.NewReader(data), 0666)
+	mode := options(context.Background()).CacheMode
+	mkdirAll(filepath.Dir(targ), mode)
+	if lockedfile.Write(targ, bytes.NewReader(data), 0666) == nil {
+		setCacheMode(targ, mode)
+	}
 }
 
 func (*dbClient) Log(msg string) {
@@ -0,0 +1,92 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cmd/go/internal/web"
+
+	"golang.org/x/mod/module"
+)
+
+// fetchTracing reports whether GODEBUG=modfetchtrace=1 is set.
+// If so, the time spent in each phase of fetching a module is printed
+// to standard error as a "# modfetchtrace" line holding a JSON fetchTrace.
+var fetchTracing bool
+
+func init() {
+	for _, f := range strings.Split(os.Getenv("GODEBUG"), ",") {
+		if f == "modfetchtrace=1" {
+			fetchTracing = true
+			web.TimingFunc = traceRequest
+			break
+		}
+	}
+}
+
+// A fetchTrace is one entry printed when fetchTracing is set.
+// Durations are in seconds.
+type fetchTrace struct {
+	Module   string  `json:",omitempty"`
+	Version  string  `json:",omitempty"`
+	Phase    string  // "get", "verify", or "extract"
+	URL      string  `json:",omitempty"`
+	Status   string  `json:",omitempty"`
+	DNS      float64 `json:",omitempty"`
+	Connect  float64 `json:",omitempty"`
+	TLS      float64 `json:",omitempty"`
+	TTFB     float64 `json:",omitempty"`
+	Body     float64 `json:",omitempty"`
+	Duration float64
+}
+
+var traceMu sync.Mutex
+
+func printTrace(t *fetchTrace) {
+	js, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	traceMu.Lock()
+	fmt.Fprintf(os.Stderr, "# modfetchtrace %s\n", js)
+	traceMu.Unlock()
+}
+
+// traceRequest prints the timing of an HTTP request made on behalf of
+// a module fetch. It is installed as web.TimingFunc.
+func traceRequest(t web.Timing) {
+	printTrace(&fetchTrace{
+		Module:   t.Module,
+		Phase:    "get",
+		URL:      t.URL,
+		Status:   t.Status,
+		DNS:      t.DNS.Seconds(),
+		Connect:  t.Connect.Seconds(),
+		TLS:      t.TLS.Seconds(),
+		TTFB:     t.TTFB.Seconds(),
+		Body:     t.Body.Seconds(),
+		Duration: t.Total.Seconds(),
+	})
+}
+
+// tracePhase prints the time spent since start in the given phase
+// of fetching mod, if fetchTracing is set.
+func tracePhase(mod module.Version, phase string, start time.Time) {
+	if !fetchTracing {
+		return
+	}
+	printTrace(&fetchTrace{
+		Module:   mod.Path,
+		Version:  mod.Version,
+		Phase:    phase,
+		Duration: time.Since(start).Seconds(),
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modfetch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// A CacheUsage reports the disk space used by a module version
+// in the module cache.
+type CacheUsage struct {
+	Download int64 // size of the files in the download cache (.info, .mod, .zip, ...)
+	Dir      int64 // total size of the files in the extracted directory
+}
+
+// Total returns the total disk space reported by u.
+func (u CacheUsage) Total() int64 {
+	return u.Download + u.Dir
+}
+
+// Usage returns the disk space used by mod in the module cache.
+// A module that is not in the module cache uses no space.
+func Usage(mod module.Version) (CacheUsage, error) {
+	var u CacheUsage
+	info, err := CachePath(mod, "info")
+	if err != nil {
+		return u, err
+	}
+	dirs, err := cacheDirs(mod.Path)
+	if err != nil {
+		return u, err
+	}
+	// The download cache holds one file for each suffix, such as v1.0.0.zip
+	// and v1.0.0.ziphash, along with any lock files, in either layout.
+	prefix := strings.TrimSuffix(filepath.Base(info), "info")
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return u, err
+		}
+		for _, fi := range files {
+			if !fi.IsDir() && strings.HasPrefix(fi.Name(), prefix) {
+				u.Download += fi.Size()
+			}
+		}
+	}
+
+	// Count a partially extracted directory too: it still uses space.
+	dir, err := DownloadDir(mod)
+	if dir == "" {
+		return u, err
+	}
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			u.Dir += fi.Size()
+		}
+		return nil
+	})
+	return u, err
+}
+
+// CachedModules returns the module versions that have a .mod or .zip file
+// in the download cache, in no particular order.
+func CachedModules() ([]module.Version, error) {
+	if PkgMod == "" {
+		return nil, fmt.Errorf("internal error: modfetch.PkgMod not set")
+	}
+	root := filepath.Join(PkgMod, "cache/download")
+	var mods []module.Version
+	seen := make(map[module.Version]bool)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if path == filepath.Join(root, "sumdb") {
+			return filepath.SkipDir
+		}
+		if fi.Name() != "@v" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		modPath, err := module.UnescapePath(unshardName(filepath.ToSlash(rel)))
+		if err != nil {
+			// Not a module cache directory; ignore it.
+			return filepath.SkipDir
+		}
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			name := f.Name()
+			ext := filepath.Ext(name)
+			if f.IsDir() || (ext != ".mod" && ext != ".zip") {
+				continue
+			}
+			v, err := module.UnescapeVersion(strings.TrimSuffix(name, ext))
+			if err != nil {
+				continue
+			}
+			// A version may have files in both the sharded and unsharded layouts.
+			m := module.Version{Path: modPath, Version: v}
+			if !seen[m] {
+				seen[m] = true
+				mods = append(mods, m)
+			}
+		}
+		return filepath.SkipDir
+	})
+	return mods, err
+}
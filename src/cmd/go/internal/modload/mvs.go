@@ -5,6 +5,7 @@
 package modload
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -32,6 +33,13 @@ type mvsReqs struct {
 	versions  sync.Map
 }
 
+// readAhead limits the number of goroutines reading go.mod files ahead of
+// their use. mvs.BuildList walks the requirement graph with a small, fixed
+// number of workers; reading the requirements of each module as soon as a
+// module requiring it has been read lets the go.mod files of a large graph
+// be fetched concurrently without waiting for a worker to reach them.
+var readAhead = make(chan struct{}, 50)
+
 // Reqs returns the current module requirement graph.
 // Future calls to SetBuildList do not affect the operation
 // of the returned Reqs.
@@ -69,6 +77,18 @@ func (r *mvsReqs) Required(mod module.Version) ([]module.Version, error) {
 			list[i] = mv
 		}
 
+		for _, mv := range list {
+			select {
+			case readAhead <- struct{}{}:
+				go func(mv module.Version) {
+					r.Required(mv)
+					<-readAhead
+				}(mv)
+			default:
+				// Too many go.mod files are being read already;
+				// mv will be read when it is needed.
+			}
+		}
 		return cached{list, nil}
 	}).(cached)
 
@@ -248,6 +268,6 @@ func fetch(mod module.Version) (dir string, isLocal bool, err error) {
 		mod = r
 	}
 
-	dir, err = modfetch.Download(mod)
+	dir, err = modfetch.DownloadWithContext(context.Background(), mod)
 	return dir, false, err
 }
@@ -0,0 +1,115 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modload
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cmd/go/internal/mvs"
+
+	"golang.org/x/mod/module"
+)
+
+// graphSize is the number of modules in the requirement graph
+// served by serveGraph.
+const graphSize = 1000
+
+// graphDelay is the time, in nanoseconds, that the proxy started by
+// serveGraph takes to answer each request.
+var graphDelay int64
+
+// serveGraph starts a module proxy serving requirement graphs of graphSize
+// modules, each module at version v1.0.0. For each prefix P, module P/m0 is
+// the root of a graph in which module P/mI requires P/m(2I+1) and P/m(2I+2),
+// if they exist, so that graphs with different prefixes share nothing in the
+// module cache. The proxy answers 404 Not Found for any other module.
+func serveGraph() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(atomic.LoadInt64(&graphDelay)))
+		i := strings.LastIndex(r.URL.Path, "/@v/")
+		j := strings.LastIndex(r.URL.Path[:i+1], "/m")
+		if i < 0 || j < 0 || !strings.HasPrefix(r.URL.Path, "/example.com/graph") {
+			http.NotFound(w, r)
+			return
+		}
+		path, file := r.URL.Path[1:i], r.URL.Path[i+len("/@v/"):]
+		n, err := strconv.Atoi(r.URL.Path[j+len("/m") : i])
+		if err != nil || n >= graphSize {
+			http.NotFound(w, r)
+			return
+		}
+		switch file {
+		case "list":
+			fmt.Fprintf(w, "v1.0.0\n")
+		case "v1.0.0.info":
+			fmt.Fprintf(w, `{"Version":"v1.0.0"}`)
+		case "v1.0.0.mod":
+			fmt.Fprintf(w, "module %s\n", path)
+			for _, req := range []int{2*n + 1, 2*n + 2} {
+				if req < graphSize {
+					fmt.Fprintf(w, "require %s/m%d v1.0.0\n", r.URL.Path[1:j], req)
+				}
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+var graphPrefixes int32
+
+// graphBuildList returns the build list of a main module requiring only
+// the root of a graph served by serveGraph, with a prefix not used before.
+func graphBuildList(t testing.TB) []module.Version {
+	prefix := fmt.Sprintf("example.com/graph%d", atomic.AddInt32(&graphPrefixes, 1))
+	defer func(old module.Version) { Target = old }(Target)
+	Target = module.Version{Path: "example.com/main"}
+	reqs := &mvsReqs{buildList: []module.Version{Target, {Path: prefix + "/m0", Version: "v1.0.0"}}}
+	list, err := mvs.BuildList(Target, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range list[1:] {
+		list[i+1].Path = strings.TrimPrefix(m.Path, prefix+"/")
+	}
+	return list
+}
+
+func TestBuildListOrder(t *testing.T) {
+	want := []module.Version{{Path: "example.com/main"}}
+	for i := 0; i < graphSize; i++ {
+		want = append(want, module.Version{Path: fmt.Sprintf("m%d", i), Version: "v1.0.0"})
+	}
+	sort.Slice(want[1:], func(i, j int) bool { return want[i+1].Path < want[j+1].Path })
+
+	// Each build list is loaded with an empty cache,
+	// so the go.mod files are read in a different order each time.
+	for i := 0; i < 3; i++ {
+		if got := graphBuildList(t); !reflect.DeepEqual(got, want) {
+			t.Fatalf("build list %d differs from the sorted list of all %d modules", i, graphSize)
+		}
+	}
+}
+
+func BenchmarkBuildList(b *testing.B) {
+	// Simulate the latency of a remote proxy.
+	defer atomic.StoreInt64(&graphDelay, 0)
+	atomic.StoreInt64(&graphDelay, int64(20*time.Millisecond))
+
+	for i := 0; i < b.N; i++ {
+		if list := graphBuildList(b); len(list) != graphSize+1 {
+			b.Fatalf("build list has %d modules, want %d", len(list), graphSize+1)
+		}
+	}
+}
@@ -64,6 +64,59 @@ func Query(path, query, current string, allowed func(module.Version) bool) (*mod
 	return info, err
 }
 
+// QueryRange returns the tagged versions of the module with the given path
+// that match the range query (<v, <=v, >v, or >=v), in semantic version order.
+// It considers versions as Query does, but returns all of them instead of only
+// the one closest to v: allowed and +incompatible versions are filtered out
+// in the same way, and prereleases are returned only if no release matches.
+func QueryRange(path, query string, allowed func(module.Version) bool) ([]string, error) {
+	var versions []string
+	err := modfetch.TryProxies(func(proxy string) (err error) {
+		versions, err = queryRangeProxy(proxy, path, query, allowed)
+		return err
+	})
+	return versions, err
+}
+
+func queryRangeProxy(proxy, path, query string, allowed func(module.Version) bool) ([]string, error) {
+	if cfg.BuildMod == "vendor" {
+		return nil, errQueryDisabled
+	}
+	if allowed == nil {
+		allowed = func(module.Version) bool { return true }
+	}
+	ok, _, preferIncompatible, err := rangeQuery(path, query, allowed)
+	if err != nil {
+		return nil, err
+	}
+	if path == Target.Path {
+		return nil, fmt.Errorf("can't query specific version (%q) for the main module (%s)", query, path)
+	}
+	if str.HasPathPrefix(path, "std") || str.HasPathPrefix(path, "cmd") {
+		return nil, fmt.Errorf("explicit requirement on standard-library module %s not allowed", path)
+	}
+
+	repo, err := modfetch.Lookup(proxy, path)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := repo.Versions("")
+	if err != nil {
+		return nil, err
+	}
+	releases, prereleases, err := filterVersions(path, versions, ok, preferIncompatible)
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) > 0 {
+		return releases, nil
+	}
+	if len(prereleases) > 0 {
+		return prereleases, nil
+	}
+	return nil, &NoMatchingVersionError{query: query}
+}
+
 var errQueryDisabled error = queryDisabledError{}
 
 type queryDisabledError struct{}
@@ -88,16 +141,6 @@ func queryProxy(proxy, path, query, current string, allowed func(module.Version)
 
 	// Parse query to detect parse errors (and possibly handle query)
 	// before any network I/O.
-	badVersion := func(v string) (*modfetch.RevInfo, error) {
-		return nil, fmt.Errorf("invalid semantic version %q in range %q", v, query)
-	}
-	matchesMajor := func(v string) bool {
-		_, pathMajor, ok := module.SplitPathVersion(path)
-		if !ok {
-			return false
-		}
-		return module.CheckPathMajor(v, pathMajor) == nil
-	}
 	var (
 		ok                 func(module.Version) bool
 		prefix             string
@@ -125,70 +168,23 @@ func queryProxy(proxy, path, query, current string, allowed func(module.Version)
 			}
 		}
 
-	case strings.HasPrefix(query, "<="):
-		v := query[len("<="):]
-		if !semver.IsValid(v) {
-			return badVersion(v)
-		}
-		if isSemverPrefix(v) {
-			// Refuse to say whether <=v1.2 allows v1.2.3 (remember, @v1.2 might mean v1.2.3).
-			return nil, fmt.Errorf("ambiguous semantic version %q in range %q", v, query)
-		}
-		ok = func(m module.Version) bool {
-			return semver.Compare(m.Version, v) <= 0 && allowed(m)
-		}
-		if !matchesMajor(v) {
-			preferIncompatible = true
-		}
-
-	case strings.HasPrefix(query, "<"):
-		v := query[len("<"):]
-		if !semver.IsValid(v) {
-			return badVersion(v)
-		}
-		ok = func(m module.Version) bool {
-			return semver.Compare(m.Version, v) < 0 && allowed(m)
-		}
-		if !matchesMajor(v) {
-			preferIncompatible = true
-		}
-
-	case strings.HasPrefix(query, ">="):
-		v := query[len(">="):]
-		if !semver.IsValid(v) {
-			return badVersion(v)
-		}
-		ok = func(m module.Version) bool {
-			return semver.Compare(m.Version, v) >= 0 && allowed(m)
-		}
-		preferOlder = true
-		if !matchesMajor(v) {
-			preferIncompatible = true
-		}
-
-	case strings.HasPrefix(query, ">"):
-		v := query[len(">"):]
-		if !semver.IsValid(v) {
-			return badVersion(v)
-		}
-		if isSemverPrefix(v) {
-			// Refuse to say whether >v1.2 allows v1.2.3 (remember, @v1.2 might mean v1.2.3).
-			return nil, fmt.Errorf("ambiguous semantic version %q in range %q", v, query)
-		}
-		ok = func(m module.Version) bool {
-			return semver.Compare(m.Version, v) > 0 && allowed(m)
-		}
-		preferOlder = true
-		if !matchesMajor(v) {
-			preferIncompatible = true
+	case strings.HasPrefix(query, "<") || strings.HasPrefix(query, ">"):
+		var (
+			incompatible bool
+			err          error
+		)
+		ok, preferOlder, incompatible, err = rangeQuery(path, query, allowed)
+		if err != nil {
+			return nil, err
 		}
+		preferIncompatible = preferIncompatible || incompatible
 
 	case semver.IsValid(query) && isSemverPrefix(query):
 		ok = func(m module.Version) bool {
 			return matchSemverPrefix(query, m.Version) && allowed(m)
 		}
 		prefix = query + "."
-		if !matchesMajor(query) {
+		if !matchesMajor(path, query) {
 			preferIncompatible = true
 		}
 
@@ -298,6 +294,86 @@ func queryProxy(proxy, path, query, current string, allowed func(module.Version)
 	return nil, &NoMatchingVersionError{query: query, current: current}
 }
 
+// rangeQuery returns the predicate that versions of the module with the given
+// path must satisfy to match the range query (<v, <=v, >v, or >=v), and
+// whether the query prefers older versions and +incompatible versions.
+func rangeQuery(path, query string, allowed func(module.Version) bool) (ok func(module.Version) bool, preferOlder, preferIncompatible bool, err error) {
+	var v string
+	switch {
+	case strings.HasPrefix(query, "<="):
+		v = query[len("<="):]
+		if !semver.IsValid(v) {
+			return nil, false, false, fmt.Errorf("invalid semantic version %q in range %q", v, query)
+		}
+		if isSemverPrefix(v) {
+			// Refuse to say whether <=v1.2 allows v1.2.3 (remember, @v1.2 might mean v1.2.3).
+			return nil, false, false, fmt.Errorf("ambiguous semantic version %q in range %q", v, query)
+		}
+		ok = func(m module.Version) bool {
+			return semver.Compare(m.Version, v) <= 0 && allowed(m)
+		}
+		if !matchesMajor(path, v) {
+			preferIncompatible = true
+		}
+
+	case strings.HasPrefix(query, "<"):
+		v = query[len("<"):]
+		if !semver.IsValid(v) {
+			return nil, false, false, fmt.Errorf("invalid semantic version %q in range %q", v, query)
+		}
+		ok = func(m module.Version) bool {
+			return semver.Compare(m.Version, v) < 0 && allowed(m)
+		}
+		if !matchesMajor(path, v) {
+			preferIncompatible = true
+		}
+
+	case strings.HasPrefix(query, ">="):
+		v = query[len(">="):]
+		if !semver.IsValid(v) {
+			return nil, false, false, fmt.Errorf("invalid semantic version %q in range %q", v, query)
+		}
+		ok = func(m module.Version) bool {
+			return semver.Compare(m.Version, v) >= 0 && allowed(m)
+		}
+		preferOlder = true
+		if !matchesMajor(path, v) {
+			preferIncompatible = true
+		}
+
+	case strings.HasPrefix(query, ">"):
+		v = query[len(">"):]
+		if !semver.IsValid(v) {
+			return nil, false, false, fmt.Errorf("invalid semantic version %q in range %q", v, query)
+		}
+		if isSemverPrefix(v) {
+			// Refuse to say whether >v1.2 allows v1.2.3 (remember, @v1.2 might mean v1.2.3).
+			return nil, false, false, fmt.Errorf("ambiguous semantic version %q in range %q", v, query)
+		}
+		ok = func(m module.Version) bool {
+			return semver.Compare(m.Version, v) > 0 && allowed(m)
+		}
+		preferOlder = true
+		if !matchesMajor(path, v) {
+			preferIncompatible = true
+		}
+
+	default:
+		return nil, false, false, fmt.Errorf("invalid version range %q", query)
+	}
+	return ok, preferOlder, preferIncompatible, nil
+}
+
+// matchesMajor reports whether v is a valid version for the major version
+// suffix, if any, of path.
+func matchesMajor(path, v string) bool {
+	_, pathMajor, ok := module.SplitPathVersion(path)
+	if !ok {
+		return false
+	}
+	return module.CheckPathMajor(v, pathMajor) == nil
+}
+
 // isSemverPrefix reports whether v is a semantic version prefix: v1 or v1.2 (not v1.2.3).
 // The caller is assumed to have checked that semver.IsValid(v) is true.
 func isSemverPrefix(v string) bool {
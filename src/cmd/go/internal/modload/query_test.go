@@ -26,7 +26,12 @@ func TestMain(m *testing.M) {
 }
 
 func testMain(m *testing.M) int {
-	cfg.GOPROXY = "direct"
+	// The module graphs used by mvs_test.go come from a local proxy,
+	// which answers 404 Not Found for any other module.
+	graph := serveGraph()
+	defer graph.Close()
+	cfg.GOPROXY = graph.URL + ",direct"
+	cfg.GONOSUMDB = "example.com/graph*"
 
 	dir, err := ioutil.TempDir("", "modload-test-")
 	if err != nil {
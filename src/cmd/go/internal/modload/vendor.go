@@ -14,6 +14,7 @@ import (
 	"sync"
 
 	"cmd/go/internal/base"
+	"cmd/go/internal/modinfo"
 
 	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
@@ -126,6 +127,33 @@ func readVendorList() {
 	})
 }
 
+// VendorModules is like ListModules, but it reports the modules that
+// vendor/modules.txt lists as providing packages to the build, in order of
+// appearance, without loading the build list. The Replace field of each is
+// set from the replacements recorded in vendor/modules.txt. If the main
+// module has no vendor/modules.txt file, VendorModules returns an error
+// satisfying errors.Is(err, os.ErrNotExist).
+func VendorModules() ([]*modinfo.ModulePublic, error) {
+	if _, err := os.Stat(filepath.Join(ModRoot(), "vendor/modules.txt")); err != nil {
+		return nil, err
+	}
+	readVendorList()
+	var mods []*modinfo.ModulePublic
+	for _, mod := range vendorList {
+		m := &modinfo.ModulePublic{Path: mod.Path, Version: mod.Version}
+		r := vendorMeta[mod].Replacement
+		if r.Path == "" {
+			// A wildcard replacement applies to every version.
+			r = vendorMeta[module.Version{Path: mod.Path}].Replacement
+		}
+		if r.Path != "" {
+			m.Replace = &modinfo.ModulePublic{Path: r.Path, Version: r.Version}
+		}
+		mods = append(mods, m)
+	}
+	return mods, nil
+}
+
 // checkVendorConsistency verifies that the vendor/modules.txt file matches (if
 // go 1.14) or at least does not contradict (go 1.13 or earlier) the
 // requirements and replacements listed in the main module's go.mod file.
@@ -11,12 +11,14 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -32,6 +34,30 @@ const (
 	Insecure                            // Allow plain HTTP if not explicitly HTTPS; skip HTTPS validation.
 )
 
+// UserAgent, if non-empty, is sent as the User-Agent header of each HTTP
+// request made by this package, in place of the net/http default.
+var UserAgent string
+
+// A Timing breaks down the time taken by an HTTP request, as reported to
+// TimingFunc. A phase that did not happen, such as connecting to the server
+// when an idle connection was reused, takes no time.
+type Timing struct {
+	URL     string        // redacted
+	Module  string        // path of the module fetched, if known (see WithModule)
+	Status  string        // status of the response
+	DNS     time.Duration // looking up the server's host name
+	Connect time.Duration // establishing the TCP connection
+	TLS     time.Duration // the TLS handshake
+	TTFB    time.Duration // from writing the request to the first byte of the response
+	Body    time.Duration // from the first byte of the response to closing its body
+	Total   time.Duration // from starting the request to closing its body
+}
+
+// TimingFunc, if non-nil, is called with the Timing of each HTTP request
+// that gets a response, once the response body has been closed.
+// It may be called concurrently from multiple goroutines.
+var TimingFunc func(Timing)
+
 // An HTTPError describes an HTTP error response (non-200 result).
 type HTTPError struct {
 	URL        string // redacted
@@ -39,6 +65,13 @@ type HTTPError struct {
 	StatusCode int
 	Err        error  // underlying error, if known
 	Detail     string // limited to maxErrorDetailLines and maxErrorDetailBytes
+
+	// For HTTP and HTTPS requests, Host is the host name of the server, and
+	// Auth reports the source of the credentials sent with the request:
+	// "netrc" for a .netrc file, "url" for the userinfo part of the URL, or
+	// "none" for no credentials.
+	Host string
+	Auth string
 }
 
 const (
@@ -95,14 +128,17 @@ func GetBytes(u *url.URL) ([]byte, error) {
 }
 
 type Response struct {
-	URL        string // redacted
-	Status     string
-	StatusCode int
-	Header     map[string][]string
-	Body       io.ReadCloser // Either the original body or &errorDetail.
+	URL           string // redacted
+	Status        string
+	StatusCode    int
+	Header        map[string][]string
+	Body          io.ReadCloser // Either the original body or &errorDetail.
+	ContentLength int64         // length of the original body, or -1 if unknown
 
 	fileErr     error
 	errorDetail errorDetailBuffer
+	host        string // host name of the server, for HTTPError
+	auth        string // source of the credentials sent, for HTTPError
 }
 
 // Err returns an *HTTPError corresponding to the response r.
@@ -119,6 +155,8 @@ func (r *Response) Err() error {
 		StatusCode: r.StatusCode,
 		Err:        r.fileErr,
 		Detail:     r.formatErrorDetail(),
+		Host:       r.host,
+		Auth:       r.auth,
 	}
 }
 
@@ -180,7 +218,43 @@ func (r *Response) formatErrorDetail() string {
 // Get returns a non-nil error only if the request did not receive a response
 // under any applicable scheme. (A non-2xx response does not cause an error.)
 func Get(security SecurityMode, u *url.URL) (*Response, error) {
-	return get(security, u)
+	return get(context.Background(), security, u, 0, "")
+}
+
+// GetContext is like Get, but the request is canceled if ctx is done
+// before the response body has been read.
+func GetContext(ctx context.Context, security SecurityMode, u *url.URL) (*Response, error) {
+	return get(ctx, security, u, 0, "")
+}
+
+// GetRange is like GetContext, but it asks the server to send only the bytes
+// of the resource from offset onward. If the server does so, the response has
+// StatusCode 206 (Partial Content) and its ContentLength is the number of
+// bytes remaining. Otherwise, the response holds the whole resource as usual.
+func GetRange(ctx context.Context, security SecurityMode, u *url.URL, offset int64) (*Response, error) {
+	return get(ctx, security, u, offset, "")
+}
+
+// GetIfNoneMatch is like GetContext, but it asks the server to send the
+// resource only if its entity tag does not match etag, as returned in the
+// ETag header of an earlier response. If the tag still matches, the response
+// has StatusCode 304 (Not Modified) and no body. Servers that do not support
+// conditional requests send the whole resource as usual.
+func GetIfNoneMatch(ctx context.Context, security SecurityMode, u *url.URL, etag string) (*Response, error) {
+	return get(ctx, security, u, 0, etag)
+}
+
+type moduleKey struct{}
+
+// WithModule returns a copy of ctx that attributes the requests made with it
+// to the module with the given path, in entries reported to cfg.Trace.
+func WithModule(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, moduleKey{}, path)
+}
+
+func moduleFromContext(ctx context.Context) string {
+	path, _ := ctx.Value(moduleKey{}).(string)
+	return path
 }
 
 // Redacted returns a redacted string form of the URL,
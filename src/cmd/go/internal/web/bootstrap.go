@@ -12,11 +12,12 @@
 package web
 
 import (
+	"context"
 	"errors"
 	urlpkg "net/url"
 )
 
-func get(security SecurityMode, url *urlpkg.URL) (*Response, error) {
+func get(ctx context.Context, security SecurityMode, url *urlpkg.URL, offset int64, etag string) (*Response, error) {
 	return nil, errors.New("no http in bootstrap go command")
 }
 
@@ -12,13 +12,17 @@
 package web
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"mime"
 	"net/http"
+	"net/http/httptrace"
 	urlpkg "net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"cmd/go/internal/auth"
@@ -51,7 +55,30 @@ var securityPreservingHTTPClient = &http.Client{
 	},
 }
 
-func get(security SecurityMode, url *urlpkg.URL) (*Response, error) {
+func get(ctx context.Context, security SecurityMode, url *urlpkg.URL, offset int64, etag string) (*Response, error) {
+	if cfg.Trace == nil {
+		return getURL(ctx, security, url, offset, etag)
+	}
+
+	start := time.Now()
+	resp, err := getURL(ctx, security, url, offset, etag)
+	e := cfg.TraceEntry{
+		Command:  "get " + Redacted(url),
+		Module:   moduleFromContext(ctx),
+		Duration: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		e.ExitStatus = -1
+		e.Error = err.Error()
+	} else {
+		e.Command = "get " + resp.URL
+		e.Status = resp.Status
+	}
+	cfg.Trace(e)
+	return resp, err
+}
+
+func getURL(ctx context.Context, security SecurityMode, url *urlpkg.URL, offset int64, etag string) (*Response, error) {
 	start := time.Now()
 
 	if url.Scheme == "file" {
@@ -72,6 +99,9 @@ func get(security SecurityMode, url *urlpkg.URL) (*Response, error) {
 		return res, nil
 	}
 
+	// creds records the source of the credentials sent with the last request,
+	// so that an error response can report it (see HTTPError.Auth).
+	var creds string
 	fetch := func(url *urlpkg.URL) (*urlpkg.URL, *http.Response, error) {
 		// Note: The -v build flag does not mean "print logging information",
 		// despite its historical misuse for this in GOPATH-based go get.
@@ -81,12 +111,31 @@ func get(security SecurityMode, url *urlpkg.URL) (*Response, error) {
 			fmt.Fprintf(os.Stderr, "# get %s\n", Redacted(url))
 		}
 
-		req, err := http.NewRequest("GET", url.String(), nil)
+		var timer *requestTimer
+		reqCtx := ctx
+		if TimingFunc != nil {
+			timer = &requestTimer{t: Timing{URL: Redacted(url), Module: moduleFromContext(ctx)}, start: time.Now()}
+			reqCtx = httptrace.WithClientTrace(ctx, timer.clientTrace())
+		}
+		req, err := http.NewRequestWithContext(reqCtx, "GET", url.String(), nil)
 		if err != nil {
 			return nil, nil, err
 		}
-		if url.Scheme == "https" {
-			auth.AddCredentials(req)
+		creds = "none"
+		if url.Scheme == "https" && auth.AddCredentials(req) {
+			creds = "netrc"
+		} else if url.User != nil {
+			// The HTTP client sends the credentials in the URL, if any.
+			creds = "url"
+		}
+		if UserAgent != "" {
+			req.Header.Set("User-Agent", UserAgent)
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
 		}
 
 		var res *http.Response
@@ -95,6 +144,12 @@ func get(security SecurityMode, url *urlpkg.URL) (*Response, error) {
 		} else {
 			res, err = securityPreservingHTTPClient.Do(req)
 		}
+		if timer != nil && err == nil {
+			timer.mu.Lock()
+			timer.t.Status = res.Status
+			timer.mu.Unlock()
+			res.Body = &timedBody{ReadCloser: res.Body, timer: timer}
+		}
 		return url, res, err
 	}
 
@@ -169,11 +224,14 @@ func get(security SecurityMode, url *urlpkg.URL) (*Response, error) {
 	}
 
 	r := &Response{
-		URL:        Redacted(fetched),
-		Status:     res.Status,
-		StatusCode: res.StatusCode,
-		Header:     map[string][]string(res.Header),
-		Body:       res.Body,
+		URL:           Redacted(fetched),
+		Status:        res.Status,
+		StatusCode:    res.StatusCode,
+		Header:        map[string][]string(res.Header),
+		Body:          res.Body,
+		ContentLength: res.ContentLength,
+		host:          fetched.Hostname(),
+		auth:          creds,
 	}
 
 	if res.StatusCode != http.StatusOK {
@@ -192,6 +250,75 @@ func get(security SecurityMode, url *urlpkg.URL) (*Response, error) {
 	return r, nil
 }
 
+// A requestTimer records the Timing of an HTTP request
+// from the events reported by its httptrace.ClientTrace.
+type requestTimer struct {
+	mu                               sync.Mutex
+	t                                Timing
+	start                            time.Time
+	dnsStart, connectStart, tlsStart time.Time
+	wroteRequest, firstByte          time.Time
+}
+
+func (rt *requestTimer) clientTrace() *httptrace.ClientTrace {
+	since := func(start *time.Time) time.Duration {
+		if start.IsZero() {
+			return 0
+		}
+		return time.Since(*start)
+	}
+	record := func(f func()) {
+		rt.mu.Lock()
+		f()
+		rt.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { record(func() { rt.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { record(func() { rt.t.DNS += since(&rt.dnsStart) }) },
+		ConnectStart: func(network, addr string) {
+			record(func() { rt.connectStart = time.Now() })
+		},
+		ConnectDone: func(network, addr string, err error) {
+			record(func() { rt.t.Connect += since(&rt.connectStart) })
+		},
+		TLSHandshakeStart: func() { record(func() { rt.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			record(func() { rt.t.TLS += since(&rt.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { record(func() { rt.wroteRequest = time.Now() }) },
+		GotFirstResponseByte: func() {
+			record(func() {
+				rt.firstByte = time.Now()
+				rt.t.TTFB = rt.firstByte.Sub(rt.wroteRequest)
+			})
+		},
+	}
+}
+
+// A timedBody is the body of a response whose Timing is reported to
+// TimingFunc when the body is closed.
+type timedBody struct {
+	io.ReadCloser
+	timer *requestTimer
+	once  sync.Once
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		rt := b.timer
+		rt.mu.Lock()
+		t := rt.t
+		if !rt.firstByte.IsZero() {
+			t.Body = time.Since(rt.firstByte)
+		}
+		t.Total = time.Since(rt.start)
+		rt.mu.Unlock()
+		TimingFunc(t)
+	})
+	return err
+}
+
 func getFile(u *urlpkg.URL) (*Response, error) {
 	path, err := urlToFilePath(u)
 	if err != nil {
@@ -223,11 +350,16 @@ func getFile(u *urlpkg.URL) (*Response, error) {
 		return nil, err
 	}
 
+	size := int64(-1)
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		size = fi.Size()
+	}
 	return &Response{
-		URL:        Redacted(u),
-		Status:     http.StatusText(http.StatusOK),
-		StatusCode: http.StatusOK,
-		Body:       f,
+		URL:           Redacted(u),
+		Status:        http.StatusText(http.StatusOK),
+		StatusCode:    http.StatusOK,
+		Body:          f,
+		ContentLength: size,
 	}, nil
 }
 
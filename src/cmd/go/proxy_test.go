@@ -7,6 +7,7 @@ package main_test
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -22,6 +23,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"cmd/go/internal/modfetch"
 	"cmd/go/internal/modfetch/codehost"
@@ -32,6 +34,7 @@ import (
 	"golang.org/x/mod/semver"
 	"golang.org/x/mod/sumdb"
 	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
 )
 
 var (
@@ -138,6 +141,52 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 		quiet = true
 	}
 
+	// /mod/slowzip/ never finishes serving a .zip file:
+	// it waits for the client to cancel the request.
+	if strings.HasPrefix(path, "slowzip/") {
+		path = path[len("slowzip/"):]
+		if strings.HasSuffix(path, ".zip") {
+			<-r.Context().Done()
+			return
+		}
+	}
+
+	// /mod/halfzip/ serves only the first half of each .zip file,
+	// as though the connection were interrupted.
+	halfZip := false
+	if strings.HasPrefix(path, "halfzip/") {
+		path = path[len("halfzip/"):]
+		halfZip = true
+	}
+
+	// /mod/rangezip/ refuses to serve a .zip file except in response
+	// to a request for part of it, as when resuming a download.
+	rangeZip := false
+	if strings.HasPrefix(path, "rangezip/") {
+		path = path[len("rangezip/"):]
+		rangeZip = true
+	}
+
+	// /mod/etag/ sends an entity tag with each .info file and honors
+	// If-None-Match requests that carry it.
+	etag := false
+	if strings.HasPrefix(path, "etag/") {
+		path = path[len("etag/"):]
+		etag = true
+	}
+
+	// /mod/signed/ serves a .sig file for each module version, listing its
+	// go.sum lines and signed with the test checksum database key.
+	// /mod/badsig/ does the same, but lists the wrong checksums.
+	var sigGoSum func(path, vers string) ([]byte, error)
+	if strings.HasPrefix(path, "signed/") {
+		path = path[len("signed/"):]
+		sigGoSum = proxyGoSum
+	} else if strings.HasPrefix(path, "badsig/") {
+		path = path[len("badsig/"):]
+		sigGoSum = proxyGoSumWrong
+	}
+
 	// Next element may opt into special behavior.
 	if j := strings.Index(path, "/"); j >= 0 {
 		n, err := strconv.Atoi(path[:j])
@@ -326,10 +375,40 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch ext {
+	case "sig":
+		if sigGoSum == nil {
+			break
+		}
+		text, err := sigGoSum(path, vers)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		signer, err := note.NewSigner(testSumDBSignerKey)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		msg, err := note.Sign(&note.Note{Text: string(text)}, signer)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Write(msg)
+		return
+
 	case "info", "mod":
 		want := "." + ext
 		for _, f := range a.Files {
 			if f.Name == want {
+				if etag && ext == "info" {
+					tag := fmt.Sprintf("%q", fmt.Sprintf("%x", sha256.Sum256(f.Data)))
+					w.Header().Set("ETag", tag)
+					if r.Header.Get("If-None-Match") == tag {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
 				w.Write(f.Data)
 				return
 			}
@@ -374,7 +453,16 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, c.err.Error(), 500)
 			return
 		}
-		w.Write(c.zip)
+		if halfZip {
+			w.Header().Set("Content-Length", strconv.Itoa(len(c.zip)))
+			w.Write(c.zip[:len(c.zip)/2])
+			return
+		}
+		if rangeZip && r.Header.Get("Range") == "" {
+			http.Error(w, "range required", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(c.zip))
 		return
 
 	}
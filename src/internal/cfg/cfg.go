@@ -46,6 +46,7 @@ const KnownEnv = `
 	GOINSECURE
 	GOMIPS
 	GOMIPS64
+	GOMODCACHESHARD
 	GONOPROXY
 	GONOSUMDB
 	GOOS